@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Password and email verification endpoints
+func (s *Server) changePassword(c *gin.Context) {
+	var req services.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	if err := s.authService.ChangePassword(user, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+func (s *Server) forgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Always answer the same way so the endpoint can't be used to probe for accounts
+	if err := s.authService.RequestPasswordReset(req.Email); err != nil {
+		requestLogger(c).WithError(err).Error("Failed to send password reset email")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address belongs to an account, a reset link has been sent"})
+}
+
+func (s *Server) resetPassword(c *gin.Context) {
+	var req services.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.authService.ResetPassword(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+func (s *Server) verifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.authService.VerifyEmail(req.Token); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+func (s *Server) resendVerification(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.authService.ResendEmailVerification(req.Email); err != nil {
+		requestLogger(c).WithError(err).Error("Failed to resend verification email")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address belongs to an unverified account, a verification link has been sent"})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// API key endpoints
+func (s *Server) listApiKeys(c *gin.Context) {
+	user := s.getCurrentUser(c)
+	keys, err := s.apiKeyService.ListApiKeys(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+		"total":    len(keys),
+	})
+}
+
+func (s *Server) createApiKey(c *gin.Context) {
+	var req services.CreateApiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	apiKey, plaintext, err := s.apiKeyService.CreateApiKey(user, &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	auditResource(c, apiKey.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": apiKey,
+		"key":     plaintext,
+	})
+}
+
+func (s *Server) revokeApiKey(c *gin.Context) {
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	if err := s.apiKeyService.RevokeApiKey(user.ID, keyID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
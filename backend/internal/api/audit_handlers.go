@@ -0,0 +1,273 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// listMyTenantAuditLogs lists the active tenant's audit log
+func (s *Server) listMyTenantAuditLogs(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondAuditLogs(c, &tenantID)
+}
+
+// Admin audit log endpoint. Lists every tenant's entries, and system entries,
+// unless tenant_id narrows it to one tenant.
+func (s *Server) listAuditLogs(c *gin.Context) {
+	tenantID, ok := optionalTenantQuery(c)
+	if !ok {
+		return
+	}
+
+	s.respondAuditLogs(c, tenantID)
+}
+
+// respondAuditLogs writes a page of audit entries as JSON, or exports every
+// matching entry when format=csv or format=jsonl is given. Entries are
+// filtered by the user_id, action, resource, resource_id, from and to query
+// parameters; an action ending in "." matches every action it prefixes.
+func (s *Server) respondAuditLogs(c *gin.Context, tenantID *uuid.UUID) {
+	query := services.AuditQuery{
+		TenantID:   tenantID,
+		Action:     c.Query("action"),
+		Resource:   c.Query("resource"),
+		ResourceID: c.Query("resource_id"),
+	}
+
+	if c.Query("user_id") != "" {
+		id, err := uuid.Parse(c.Query("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		query.UserID = &id
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	} {
+		value, ok := parseUsageTime(c.Query(param.name))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s: use RFC 3339 or YYYY-MM-DD", param.name)})
+			return
+		}
+		*param.value = value
+	}
+
+	switch format := c.Query("format"); format {
+	case "csv", "jsonl":
+		s.exportAuditLogs(c, query, format)
+		return
+	case "", "json":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format: use json, csv or jsonl"})
+		return
+	}
+
+	query.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(services.DefaultAuditLimit)))
+	query.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if query.Limit <= 0 || query.Limit > services.MaxAuditLimit {
+		query.Limit = services.DefaultAuditLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	entries, total, err := s.auditService.Query(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"audit_logs": entries,
+		"total":      total,
+		"limit":      query.Limit,
+		"offset":     query.Offset,
+	})
+}
+
+// exportAuditLogs streams matching entries, oldest first, as CSV or JSON
+// lines. Headers are sent before the first entry, so a failure part way
+// through can only be logged.
+func (s *Server) exportAuditLogs(c *gin.Context, query services.AuditQuery, format string) {
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	var write func(*models.AuditLog) error
+	if format == "csv" {
+		w := csv.NewWriter(c.Writer)
+		defer w.Flush()
+		w.Write([]string{"created_at", "id", "tenant_id", "user_id", "action",
+			"resource", "resource_id", "ip_address", "user_agent", "details"})
+		write = func(entry *models.AuditLog) error {
+			return w.Write([]string{
+				entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+				entry.ID.String(),
+				uuidString(entry.TenantID),
+				uuidString(entry.UserID),
+				entry.Action,
+				entry.Resource,
+				entry.ResourceID,
+				entry.IPAddress,
+				entry.UserAgent,
+				entry.Details,
+			})
+		}
+	} else {
+		encoder := json.NewEncoder(c.Writer)
+		write = func(entry *models.AuditLog) error {
+			return encoder.Encode(entry)
+		}
+	}
+
+	if err := s.auditService.Export(query, write); err != nil {
+		requestLogger(c).WithError(err).Error("Failed to export audit logs")
+	}
+}
+
+func uuidString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+// verifyMyTenantAuditLogs checks the active tenant's audit chain
+func (s *Server) verifyMyTenantAuditLogs(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondAuditVerification(c, &tenantID)
+}
+
+// Admin audit chain verification. Checks one tenant's chain, or the chain of
+// entries without a tenant when tenant_id is omitted.
+func (s *Server) verifyAuditLogs(c *gin.Context) {
+	tenantID, ok := optionalTenantQuery(c)
+	if !ok {
+		return
+	}
+
+	s.respondAuditVerification(c, tenantID)
+}
+
+func (s *Server) respondAuditVerification(c *gin.Context, tenantID *uuid.UUID) {
+	result, err := s.auditService.VerifyChain(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// listAuditCheckpoints lists signed checkpoints, oldest first, optionally of
+// one tenant and since a time. format=jsonl exports them one per line with
+// the signed message and public key, for storage outside the database.
+func (s *Server) listAuditCheckpoints(c *gin.Context) {
+	tenantID, ok := optionalTenantQuery(c)
+	if !ok {
+		return
+	}
+	since, ok := parseUsageTime(c.Query("since"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since: use RFC 3339 or YYYY-MM-DD"})
+		return
+	}
+
+	checkpoints, err := s.auditService.ListCheckpoints(tenantID, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit checkpoints"})
+		return
+	}
+
+	exported := make([]services.ExportedCheckpoint, 0, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		exported = append(exported, s.auditService.ExportCheckpoint(checkpoint))
+	}
+
+	if c.Query("format") == "jsonl" {
+		filename := fmt.Sprintf("audit-checkpoints-%s.jsonl", time.Now().UTC().Format("20060102T150405Z"))
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		for _, checkpoint := range exported {
+			encoder.Encode(checkpoint)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"public_key":  s.auditService.PublicKey(),
+		"checkpoints": exported,
+		"total":       len(exported),
+	})
+}
+
+// createAuditCheckpoints signs every chain that grew since its last
+// checkpoint without waiting for the next interval
+func (s *Server) createAuditCheckpoints(c *gin.Context) {
+	checkpoints, err := s.auditService.CreateCheckpoints()
+	if err != nil {
+		if errors.Is(err, services.ErrAuditCheckpointsDisabled) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create audit checkpoints"})
+		return
+	}
+
+	exported := make([]services.ExportedCheckpoint, 0, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		exported = append(exported, s.auditService.ExportCheckpoint(checkpoint))
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"checkpoints": exported,
+		"total":       len(exported),
+	})
+}
+
+// optionalTenantQuery parses the tenant_id query parameter, which may be
+// omitted
+func optionalTenantQuery(c *gin.Context) (*uuid.UUID, bool) {
+	if c.Query("tenant_id") == "" {
+		return nil, true
+	}
+	id, err := uuid.Parse(c.Query("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return nil, false
+	}
+	return &id, true
+}
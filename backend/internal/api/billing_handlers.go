@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"nomad-services-api/internal/payments"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookSize bounds the payload of payment webhooks
+const maxWebhookSize = 1 << 20
+
+// listMyPaymentMethods returns the active tenant's saved payment methods
+func (s *Server) listMyPaymentMethods(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	methods, err := s.billingService.ListPaymentMethods(tenantID)
+	if err != nil {
+		s.respondBillingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, methods)
+}
+
+// addMyPaymentMethod saves a payment method and makes it the default
+func (s *Server) addMyPaymentMethod(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	var req services.PaymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	method, err := s.billingService.AddPaymentMethod(tenantID, &req, user.ID, s.clientInfo(c))
+	if err != nil {
+		s.respondBillingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, method)
+}
+
+func (s *Server) removeMyPaymentMethod(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	if err := s.billingService.RemovePaymentMethod(tenantID, c.Param("id"), user.ID, s.clientInfo(c)); err != nil {
+		s.respondBillingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment method removed"})
+}
+
+// payMyInvoice charges one of the active tenant's open invoices
+func (s *Server) payMyInvoice(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+	invoiceID, ok := s.invoiceIDParam(c)
+	if !ok {
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	invoice, err := s.billingService.PayInvoice(&tenantID, invoiceID, &user.ID, s.clientInfo(c))
+	if err != nil {
+		s.respondBillingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// chargeInvoice charges any open invoice to its tenant's payment method
+// (admin only)
+func (s *Server) chargeInvoice(c *gin.Context) {
+	invoiceID, ok := s.invoiceIDParam(c)
+	if !ok {
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	invoice, err := s.billingService.PayInvoice(nil, invoiceID, &user.ID, s.clientInfo(c))
+	if err != nil {
+		s.respondBillingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// handlePaymentWebhook receives events from the payment provider. It is not
+// authenticated; the provider's signature is verified instead.
+func (s *Server) handlePaymentWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookSize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Payload too large"})
+		return
+	}
+
+	if err := s.billingService.HandleWebhook(payload, c.Request.Header); err != nil {
+		switch {
+		case errors.Is(err, payments.ErrInvalidSignature):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid signature"})
+		case errors.Is(err, services.ErrPaymentsDisabled):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			requestLogger(c).WithError(err).Error("Failed to process payment webhook")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+func (s *Server) respondBillingError(c *gin.Context, err error) {
+	var failed *services.PaymentFailedError
+	if errors.As(err, &failed) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": failed.Error()})
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrPaymentsDisabled):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvoiceNotFound), errors.Is(err, services.ErrTenantNotFound), errors.Is(err, payments.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNoPaymentMethod):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getMyTenantBudget returns the active tenant's budget and current spend
+func (s *Server) getMyTenantBudget(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondBudget(c, tenantID)
+}
+
+func (s *Server) setMyTenantBudget(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.saveBudget(c, tenantID)
+}
+
+func (s *Server) deleteMyTenantBudget(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.removeBudget(c, tenantID)
+}
+
+// Admin budget endpoints
+func (s *Server) getTenantBudget(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	s.respondBudget(c, tenantID)
+}
+
+func (s *Server) setTenantBudget(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	s.saveBudget(c, tenantID)
+}
+
+func (s *Server) deleteTenantBudget(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	s.removeBudget(c, tenantID)
+}
+
+func (s *Server) respondBudget(c *gin.Context, tenantID uuid.UUID) {
+	budget, err := s.budgetService.GetBudget(tenantID)
+	if err != nil {
+		s.respondBudgetError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, budget)
+}
+
+func (s *Server) saveBudget(c *gin.Context, tenantID uuid.UUID) {
+	var req services.BudgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	budget, err := s.budgetService.SetBudget(tenantID, &req, user.ID, s.clientInfo(c))
+	if err != nil {
+		s.respondBudgetError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, budget)
+}
+
+func (s *Server) removeBudget(c *gin.Context, tenantID uuid.UUID) {
+	user := s.getCurrentUser(c)
+	if err := s.budgetService.DeleteBudget(tenantID, user.ID, s.clientInfo(c)); err != nil {
+		s.respondBudgetError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Budget deleted"})
+}
+
+func (s *Server) respondBudgetError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrBudgetNotFound), errors.Is(err, services.ErrTenantNotFound), errors.Is(err, services.ErrPlanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// estimateServiceCost prices a service before it is created, from the same
+// request body as POST /services
+func (s *Server) estimateServiceCost(c *gin.Context) {
+	var req services.CreateServiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	estimate, err := s.estimateService.EstimateRequest(c.Request.Context(), s.activeTenantID(c), &req)
+	if err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, estimate)
+}
+
+// planServiceDeployment is a dry run of starting a service: what Nomad would
+// schedule and what it would cost
+func (s *Server) planServiceDeployment(c *gin.Context) {
+	serviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+		return
+	}
+
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	plan, err := s.serviceManager.PlanDeployment(c.Request.Context(), scope, serviceID)
+	if err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	service, err := s.serviceManager.GetService(c.Request.Context(), scope, serviceID)
+	if err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
+		return
+	}
+	plan.Estimate, err = s.estimateService.Estimate(c.Request.Context(), service.TenantID, service)
+	if err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
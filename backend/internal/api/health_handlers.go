@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"time"
+
+	"nomad-services-api/internal/services"
+	"nomad-services-api/internal/version"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Health check endpoint. Kept for existing monitors; it reports the build
+// without checking dependencies, like /livez.
+func (s *Server) healthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "healthy",
+		"version": version.Version,
+		"commit":  version.Commit,
+	})
+}
+
+// livez reports that the process is up and serving requests. It checks no
+// dependencies, so an outage of the database or Nomad doesn't get the API
+// restarted.
+func (s *Server) livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": services.HealthOK})
+}
+
+// readyz checks the dependencies needed to serve requests and responds 503
+// when any of them fails, so load balancers stop sending traffic
+func (s *Server) readyz(c *gin.Context) {
+	report := s.healthService.Ready(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status != services.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// getDiagnostics reports the build, runtime, readiness and configuration of
+// the server. Secrets in the configuration are redacted.
+func (s *Server) getDiagnostics(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	startedAt := s.healthService.StartedAt()
+	c.JSON(http.StatusOK, gin.H{
+		"build":          version.Get(),
+		"started_at":     startedAt.UTC(),
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+		"runtime": gin.H{
+			"goroutines":       runtime.NumGoroutine(),
+			"gomaxprocs":       runtime.GOMAXPROCS(0),
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"sys_bytes":        mem.Sys,
+			"num_gc":           mem.NumGC,
+		},
+		"readiness": s.healthService.Ready(c.Request.Context()),
+		"config":    redactConfig(reflect.ValueOf(s.config)),
+	})
+}
+
+// redactConfig converts the configuration to JSON values, keyed by field
+// name, with durations as strings. Set string fields whose names look like
+// secrets are redacted; unset ones stay empty, so it shows whether they are
+// configured.
+func redactConfig(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return nil
+		}
+		return redactConfig(value.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{}, value.NumField())
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Type.Kind() == reflect.String && isSecretField(field.Name) && value.Field(i).String() != "" {
+				fields[field.Name] = "[REDACTED]"
+				continue
+			}
+			fields[field.Name] = redactConfig(value.Field(i))
+		}
+		return fields
+	}
+
+	if duration, ok := value.Interface().(time.Duration); ok {
+		return duration.String()
+	}
+	return value.Interface()
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Invitation endpoints for tenant admins
+func (s *Server) listInvitations(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	invitations, err := s.invitationService.ListInvitations(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invitations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invitations": invitations,
+		"total":       len(invitations),
+	})
+}
+
+func (s *Server) createInvitation(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	var req services.InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, err := s.invitationService.Invite(tenantID, &req, s.getCurrentUser(c))
+	if err != nil {
+		s.respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+func (s *Server) resendInvitation(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	invitationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	invitation, err := s.invitationService.Resend(tenantID, invitationID, s.getCurrentUser(c))
+	if err != nil {
+		s.respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitation)
+}
+
+func (s *Server) revokeInvitation(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	invitationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	if err := s.invitationService.Revoke(tenantID, invitationID); err != nil {
+		s.respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// Invitation endpoints for invitees
+func (s *Server) previewInvitation(c *gin.Context) {
+	preview, err := s.invitationService.Preview(c.Param("token"))
+	if err != nil {
+		s.respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
+func (s *Server) acceptInvitationWithSignup(c *gin.Context) {
+	var req services.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := s.invitationService.AcceptWithSignup(&req)
+	if err != nil {
+		s.respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Invitation accepted successfully",
+		"user":    user,
+	})
+}
+
+func (s *Server) acceptInvitation(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	membership, err := s.invitationService.AcceptAsUser(req.Token, s.getCurrentUser(c))
+	if err != nil {
+		s.respondInvitationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Invitation accepted successfully",
+		"membership": membership,
+	})
+}
+
+func (s *Server) respondInvitationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvitationNotFound), errors.Is(err, services.ErrInvalidInvitation):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// listMyInvoices returns the active tenant's issued invoices
+func (s *Server) listMyInvoices(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondInvoices(c, tenantID, false)
+}
+
+// getMyInvoice returns one of the active tenant's invoices, as HTML with
+// format=html
+func (s *Server) getMyInvoice(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondInvoice(c, &tenantID)
+}
+
+// Admin invoice endpoints
+func (s *Server) listTenantInvoices(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	s.respondInvoices(c, tenantID, true)
+}
+
+func (s *Server) getInvoice(c *gin.Context) {
+	s.respondInvoice(c, nil)
+}
+
+func (s *Server) addInvoiceLine(c *gin.Context) {
+	invoiceID, ok := s.invoiceIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req services.InvoiceLineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	invoice, err := s.invoiceService.AddLine(invoiceID, &req, user.ID, s.clientInfo(c))
+	if err != nil {
+		s.respondInvoiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+func (s *Server) finalizeInvoice(c *gin.Context) {
+	s.changeInvoice(c, func(id, userID uuid.UUID, client services.ClientInfo) (*models.Invoice, error) {
+		return s.invoiceService.Finalize(id, &userID, client)
+	})
+}
+
+func (s *Server) payInvoice(c *gin.Context) {
+	s.changeInvoice(c, func(id, userID uuid.UUID, client services.ClientInfo) (*models.Invoice, error) {
+		return s.invoiceService.MarkPaid(id, "", &userID, client)
+	})
+}
+
+func (s *Server) voidInvoice(c *gin.Context) {
+	s.changeInvoice(c, s.invoiceService.Void)
+}
+
+func (s *Server) changeInvoice(c *gin.Context, change func(id, userID uuid.UUID, client services.ClientInfo) (*models.Invoice, error)) {
+	invoiceID, ok := s.invoiceIDParam(c)
+	if !ok {
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	invoice, err := change(invoiceID, user.ID, s.clientInfo(c))
+	if err != nil {
+		s.respondInvoiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+func (s *Server) respondInvoices(c *gin.Context, tenantID uuid.UUID, includeDrafts bool) {
+	invoices, err := s.invoiceService.ListInvoices(tenantID, includeDrafts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoices": invoices,
+		"total":    len(invoices),
+	})
+}
+
+func (s *Server) respondInvoice(c *gin.Context, tenantID *uuid.UUID) {
+	invoiceID, ok := s.invoiceIDParam(c)
+	if !ok {
+		return
+	}
+
+	invoice, err := s.invoiceService.GetInvoice(tenantID, invoiceID)
+	if err != nil {
+		s.respondInvoiceError(c, err)
+		return
+	}
+
+	if c.Query("format") != "html" {
+		c.JSON(http.StatusOK, invoice)
+		return
+	}
+
+	html, err := s.invoiceService.RenderHTML(invoice)
+	if err != nil {
+		s.respondInvoiceError(c, err)
+		return
+	}
+	if c.Query("download") == "true" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", invoice.Number+".html"))
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", html)
+}
+
+func (s *Server) invoiceIDParam(c *gin.Context) (uuid.UUID, bool) {
+	invoiceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return uuid.Nil, false
+	}
+	return invoiceID, true
+}
+
+func (s *Server) respondInvoiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvoiceNotFound), errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
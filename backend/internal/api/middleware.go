@@ -1,62 +1,113 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"nomad-services-api/internal/metrics"
 	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/ratelimit"
+	"nomad-services-api/internal/requestid"
+	"nomad-services-api/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// authMiddleware validates JWT tokens and sets user context
+// authMiddleware validates JWT tokens or API keys and sets user context
 func (s *Server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
+		var user *models.User
+		var activeTenantID *uuid.UUID
 
-		// Extract token from "Bearer <token>"
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
-			return
-		}
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			apiKey, err := s.apiKeyService.Authenticate(key)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
 
-		token := tokenParts[1]
+			user = &apiKey.User
+			activeTenantID = apiKey.TenantID
+			c.Set("api_key", apiKey)
+		} else {
+			authHeader := c.GetHeader("Authorization")
+			if authHeader == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+				c.Abort()
+				return
+			}
 
-		// Validate token
-		claims, err := s.authService.ValidateToken(token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			// Extract token from "Bearer <token>"
+			tokenParts := strings.Split(authHeader, " ")
+			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+				c.Abort()
+				return
+			}
+
+			token := tokenParts[1]
+
+			// Validate token
+			claims, err := s.authService.ValidateToken(token)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+
+			// Get user from database
+			user, err = s.userService.GetUserByID(claims.UserID)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+				c.Abort()
+				return
+			}
+
+			activeTenantID = claims.TenantID
+			c.Set("claims", claims)
+		}
+
+		// Requests act in the tenant the token or API key was issued for,
+		// as long as the user is still a member
+		if err := s.tenantService.ResolveActiveTenant(user, activeTenantID); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, services.ErrNotTenantMember) {
+				status = http.StatusUnauthorized
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Get user from database
-		user, err := s.userService.GetUserByID(claims.UserID)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		// Check if user and tenant are active
+		if err := services.CheckAccountActive(user); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Check if user is active
-		if !user.IsActive {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is inactive"})
+		// Users in tenants that enforce MFA may only reach the enrollment endpoints until enrolled
+		if s.mfaService.IsRequired(user) && !user.MFAEnabled && !isMFAEnrollmentPath(c.FullPath()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "MFA enrollment required"})
 			c.Abort()
 			return
 		}
 
-		// Set user and claims in context
+		// Set user in context
 		c.Set("user", user)
-		c.Set("claims", claims)
 		c.Set("user_id", user.ID)
 		c.Set("tenant_id", user.TenantID)
 
@@ -64,8 +115,15 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 	}
 }
 
-// adminMiddleware ensures only admin users can access certain endpoints
-func (s *Server) adminMiddleware() gin.HandlerFunc {
+// isMFAEnrollmentPath reports whether a route stays reachable while MFA enrollment is pending
+func isMFAEnrollmentPath(path string) bool {
+	return path == "/api/v1/users/me" ||
+		path == "/api/v1/users/me/mfa/enroll" ||
+		path == "/api/v1/users/me/mfa/confirm"
+}
+
+// requirePermission ensures the current user holds every one of perms
+func (s *Server) requirePermission(perms ...models.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := s.getCurrentUser(c)
 		if user == nil {
@@ -74,28 +132,15 @@ func (s *Server) adminMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if user.Role != models.UserRoleAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
-
-// tenantAdminMiddleware ensures only tenant admins or system admins can access certain endpoints
-func (s *Server) tenantAdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user := s.getCurrentUser(c)
-		if user == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+		allowed, err := s.rbacService.HasPermission(user, perms...)
+		if err != nil {
+			requestLogger(c).WithError(err).WithField("user_id", user.ID).Error("Failed to resolve permissions")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve permissions"})
 			c.Abort()
 			return
 		}
-
-		if user.Role != models.UserRoleAdmin && user.Role != models.UserRoleTenantAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 			c.Abort()
 			return
 		}
@@ -152,31 +197,180 @@ func (s *Server) loggingMiddleware() gin.HandlerFunc {
 	})
 }
 
-// rateLimitMiddleware implements basic rate limiting (simple implementation)
-func (s *Server) rateLimitMiddleware() gin.HandlerFunc {
-	// This is a simple implementation. For production, consider using redis-based rate limiting
+// deployRoutes are the routes that use the deploy rate limit bucket
+var deployRoutes = map[string]bool{
+	"/api/v1/services/:id/start":   true,
+	"/api/v1/services/:id/stop":    true,
+	"/api/v1/services/:id/restart": true,
+	"/api/v1/services/:id/scale":   true,
+}
+
+// rateLimitCheck is one bucket a request has to take a token from
+type rateLimitCheck struct {
+	key   string
+	limit ratelimit.Limit
+}
+
+// rateLimitMiddleware applies token bucket rate limiting. Anonymous requests are
+// limited per client IP; authenticated requests per user or API key and per tenant,
+// with limits taken from the tenant's plan. An empty class derives the bucket from
+// the route: deploy routes, reads (GET/HEAD) and other writes.
+func (s *Server) rateLimitMiddleware(class ratelimit.RouteClass) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.rateLimiter == nil {
+			c.Next()
+			return
+		}
+
+		routeClass := class
+		if routeClass == "" {
+			routeClass = routeClassFor(c)
+		}
+
+		var tightest *ratelimit.Result
+		for _, check := range s.rateLimitChecks(c, routeClass) {
+			result, err := s.rateLimiter.Allow(c.Request.Context(), check.key, check.limit)
+			if err != nil {
+				// Fail open so a limiter outage doesn't take the API down
+				requestLogger(c).WithError(err).Warn("Rate limiter unavailable")
+				continue
+			}
+
+			if tightest == nil || !result.Allowed || result.Remaining < tightest.Remaining {
+				r := result
+				tightest = &r
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+
+		if tightest != nil {
+			c.Header("X-RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.ResetAfter)))
+
+			if !tightest.Allowed {
+				c.Header("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitChecks returns the buckets that apply to the current principal
+func (s *Server) rateLimitChecks(c *gin.Context, class ratelimit.RouteClass) []rateLimitCheck {
+	user := s.getCurrentUser(c)
+	if user == nil {
+		return []rateLimitCheck{{
+			key:   fmt.Sprintf("%s:%s:%s", ratelimit.PrincipalAnonymous, c.ClientIP(), class),
+			limit: ratelimit.AnonymousLimits[class],
+		}}
+	}
+
+	plan := models.TenantPlan(s.config.RateLimit.DefaultPlan)
+	if user.Tenant != nil {
+		plan = user.Tenant.Plan
+	}
+	catalogPlan, err := s.planService.CachedPlan(plan)
+	if err != nil && !errors.Is(err, services.ErrPlanNotFound) {
+		requestLogger(c).WithError(err).Warn("Failed to load plan rate limits, using defaults")
+	}
+	limits := ratelimit.LimitsForPlan(plan, catalogPlan)
+
+	var checks []rateLimitCheck
+	if apiKey, ok := c.Get("api_key"); ok {
+		checks = append(checks, rateLimitCheck{
+			key:   fmt.Sprintf("%s:%s:%s", ratelimit.PrincipalAPIKey, apiKey.(*models.ApiKey).ID, class),
+			limit: limits.APIKey[class],
+		})
+	} else {
+		checks = append(checks, rateLimitCheck{
+			key:   fmt.Sprintf("%s:%s:%s", ratelimit.PrincipalUser, user.ID, class),
+			limit: limits.User[class],
+		})
+	}
+
+	if user.TenantID != nil {
+		checks = append(checks, rateLimitCheck{
+			key:   fmt.Sprintf("%s:%s:%s", ratelimit.PrincipalTenant, user.TenantID, class),
+			limit: limits.Tenant[class],
+		})
+	}
+
+	return checks
+}
+
+// routeClassFor picks the rate limit bucket for the matched route
+func routeClassFor(c *gin.Context) ratelimit.RouteClass {
+	if deployRoutes[c.FullPath()] {
+		return ratelimit.RouteClassDeploy
+	}
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ratelimit.RouteClassRead
+	default:
+		return ratelimit.RouteClassWrite
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// metricsMiddleware records the count and latency of requests by route
+// pattern and status. Requests matching no route share the route "unmatched".
+func (s *Server) metricsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// TODO: Implement rate limiting logic
-		// For now, just pass through
+		start := time.Now()
 		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveHTTPRequest(c.Request.Method, route, strconv.Itoa(c.Writer.Status()), time.Since(start))
 	}
 }
 
-// requestIDMiddleware adds a unique request ID to each request
+// loggerKey holds the request's log entry in the gin context
+const loggerKey = "logger"
+
+// requestIDMiddleware gives each request a UUID, or keeps the caller's
+// X-Request-ID when it is one. The ID is returned in the X-Request-ID
+// header, recorded on the request's span and carried by the request's
+// context, so services log it and pass it on to Nomad.
 func (s *Server) requestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requestID := c.GetHeader("X-Request-ID")
-		if requestID == "" {
-			// Generate a simple request ID (in production, use UUID)
-			requestID = fmt.Sprintf("%d", time.Now().UnixNano())
+		requestID, ok := requestid.Parse(c.GetHeader(requestid.Header))
+		if !ok {
+			requestID = requestid.New()
 		}
-		
-		c.Header("X-Request-ID", requestID)
+
+		ctx := requestid.NewContext(c.Request.Context(), requestID)
+		c.Request = c.Request.WithContext(ctx)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", requestID))
+
+		c.Header(requestid.Header, requestID)
 		c.Set("request_id", requestID)
+		c.Set(loggerKey, logrus.WithContext(ctx).WithField("request_id", requestID))
 		c.Next()
 	}
 }
 
+// requestLogger returns the request's log entry, which carries its request
+// ID and, when traced, its trace and span IDs
+func requestLogger(c *gin.Context) *logrus.Entry {
+	if entry, ok := c.Get(loggerKey); ok {
+		return entry.(*logrus.Entry)
+	}
+	return logrus.WithContext(c.Request.Context())
+}
+
 // errorHandlerMiddleware handles errors consistently
 func (s *Server) errorHandlerMiddleware() gin.HandlerFunc {
 	return gin.Recovery()
@@ -197,3 +391,214 @@ func (s *Server) securityHeadersMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// auditRoute names the audit action and resource of a mutating route
+type auditRoute struct {
+	action   string
+	resource string
+}
+
+// auditRoutes maps "METHOD path" to the audit entry requests get. Routes
+// whose services record their own audit entries map to an empty action and
+// are skipped, as are dry runs. Other mutating routes are recorded as
+// "request.<method>".
+var auditRoutes = map[string]auditRoute{
+	"POST /api/v1/services":                                {"service.create", "service"},
+	"POST /api/v1/services/":                               {"service.create", "service"},
+	"PUT /api/v1/services/:id":                             {"service.update", "service"},
+	"DELETE /api/v1/services/:id":                          {"service.delete", "service"},
+	"POST /api/v1/services/:id/start":                      {"service.start", "service"},
+	"POST /api/v1/services/:id/stop":                       {"service.stop", "service"},
+	"POST /api/v1/services/:id/restart":                    {"service.restart", "service"},
+	"POST /api/v1/services/:id/scale":                      {"service.scale", "service"},
+	"POST /api/v1/services/:id/plan":                       {},
+	"POST /api/v1/services/estimate":                       {},
+	"PUT /api/v1/users/me":                                 {"user.update", "user"},
+	"POST /api/v1/users/me/password":                       {"user.password_changed", "user"},
+	"POST /api/v1/users/me/mfa/enroll":                     {"mfa.enroll", "user"},
+	"POST /api/v1/users/me/mfa/confirm":                    {"mfa.confirm", "user"},
+	"POST /api/v1/users/me/mfa/recovery-codes":             {"mfa.recovery_codes_regenerated", "user"},
+	"DELETE /api/v1/users/me/mfa":                          {"mfa.disable", "user"},
+	"POST /api/v1/users/me/api-keys":                       {"api_key.create", "api_key"},
+	"DELETE /api/v1/users/me/api-keys/:id":                 {"api_key.revoke", "api_key"},
+	"POST /api/v1/users/me/invitations/accept":             {"invitation.accept", "invitation"},
+	"POST /api/v1/auth/switch-tenant":                      {},
+	"PUT /api/v1/tenant":                                   {"tenant.update", "tenant"},
+	"PUT /api/v1/tenant/plan":                              {"tenant.plan_change", "tenant"},
+	"POST /api/v1/tenant/plan/preview":                     {},
+	"POST /api/v1/tenant/subscription/cancel":              {},
+	"POST /api/v1/tenant/subscription/resume":              {},
+	"POST /api/v1/tenant/invoices/:id/pay":                 {},
+	"POST /api/v1/tenant/payment-methods":                  {},
+	"DELETE /api/v1/tenant/payment-methods/:id":            {},
+	"PUT /api/v1/tenant/budget":                            {},
+	"DELETE /api/v1/tenant/budget":                         {},
+	"DELETE /api/v1/tenant/members/:id":                    {"member.remove", "user"},
+	"POST /api/v1/tenant/invitations":                      {"invitation.create", "invitation"},
+	"POST /api/v1/tenant/invitations/:id/resend":           {"invitation.resend", "invitation"},
+	"DELETE /api/v1/tenant/invitations/:id":                {"invitation.revoke", "invitation"},
+	"POST /api/v1/tenant/roles":                            {"role.create", "role"},
+	"PUT /api/v1/tenant/roles/:id":                         {"role.update", "role"},
+	"DELETE /api/v1/tenant/roles/:id":                      {"role.delete", "role"},
+	"PUT /api/v1/tenant/members/:id/role":                  {"member.role_change", "user"},
+	"PUT /api/v1/admin/users/:id/role":                     {"user.role_change", "user"},
+	"PUT /api/v1/admin/users/:id/activate":                 {"user.activate", "user"},
+	"PUT /api/v1/admin/users/:id/deactivate":               {"user.deactivate", "user"},
+	"PUT /api/v1/admin/users/:id/unlock":                   {},
+	"DELETE /api/v1/admin/users/:id/mfa":                   {"mfa.reset", "user"},
+	"POST /api/v1/admin/tenants":                           {"tenant.create", "tenant"},
+	"PUT /api/v1/admin/tenants/:id":                        {"tenant.update", "tenant"},
+	"DELETE /api/v1/admin/tenants/:id":                     {"tenant.delete", "tenant"},
+	"PUT /api/v1/admin/tenants/:id/activate":               {"tenant.activate", "tenant"},
+	"PUT /api/v1/admin/tenants/:id/deactivate":             {"tenant.deactivate", "tenant"},
+	"PUT /api/v1/admin/tenants/:id/mfa":                    {"tenant.mfa_policy", "tenant"},
+	"PUT /api/v1/admin/tenants/:id/quota":                  {"tenant.quota_update", "tenant"},
+	"POST /api/v1/admin/tenants/:id/members":               {"member.add", "user"},
+	"DELETE /api/v1/admin/tenants/:id/members/:userId":     {"member.remove", "user"},
+	"POST /api/v1/admin/tenants/:id/subscription/payments": {},
+	"PUT /api/v1/admin/tenants/:id/budget":                 {},
+	"DELETE /api/v1/admin/tenants/:id/budget":              {},
+	"POST /api/v1/admin/invoices/:id/lines":                {},
+	"POST /api/v1/admin/invoices/:id/finalize":             {},
+	"POST /api/v1/admin/invoices/:id/pay":                  {},
+	"POST /api/v1/admin/invoices/:id/charge":               {},
+	"POST /api/v1/admin/invoices/:id/void":                 {},
+	"POST /api/v1/admin/audit-checkpoints":                 {"audit.checkpoint", "audit_checkpoint"},
+	"PUT /api/v1/admin/plans/:id":                          {"plan.update", "plan"},
+}
+
+// Context keys handlers use to add to their request's audit entry
+const (
+	auditResourceIDKey = "audit_resource_id"
+	auditChangesKey    = "audit_changes"
+)
+
+// maxAuditBody bounds the request bodies copied into audit entries
+const maxAuditBody = 64 << 10
+
+// auditMiddleware records an audit entry for every mutating request once it
+// has been handled. Entries carry the redacted JSON request body, the
+// response status and, when the handler provided one, a diff of the changed
+// resource. Writing is asynchronous so it doesn't slow requests down.
+func (s *Server) auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		route, known := auditRoutes[c.Request.Method+" "+c.FullPath()]
+		if known && route.action == "" {
+			c.Next()
+			return
+		}
+		if !known {
+			route = auditRoute{"request." + strings.ToLower(c.Request.Method), "api"}
+		}
+
+		var body []byte
+		if c.Request.Body != nil && strings.HasPrefix(c.ContentType(), "application/json") {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBody+1))
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		}
+
+		c.Next()
+
+		user := s.getCurrentUser(c)
+		if user == nil {
+			return
+		}
+
+		details := map[string]interface{}{
+			"method": c.Request.Method,
+			"path":   c.FullPath(),
+			"status": c.Writer.Status(),
+		}
+		if len(body) > 0 && len(body) <= maxAuditBody {
+			var request interface{}
+			if json.Unmarshal(body, &request) == nil {
+				details["request"] = redactAuditValue(request)
+			}
+		}
+		if changes, ok := c.Get(auditChangesKey); ok {
+			details["changes"] = changes
+		}
+		if apiKey, ok := c.Get("api_key"); ok {
+			details["api_key_id"] = apiKey.(*models.ApiKey).ID
+		}
+		if requestID := c.GetString("request_id"); requestID != "" {
+			details["request_id"] = requestID
+		}
+
+		resourceID := c.GetString(auditResourceIDKey)
+		if resourceID == "" {
+			resourceID = c.Param("id")
+		}
+
+		// Admin changes to a tenant are logged against that tenant, so its
+		// admins see them
+		tenantID := s.activeTenantID(c)
+		if strings.HasPrefix(c.FullPath(), "/api/v1/admin/tenants/:id") {
+			if id, err := uuid.Parse(c.Param("id")); err == nil {
+				tenantID = &id
+			}
+		}
+
+		data, err := json.Marshal(details)
+		if err != nil {
+			requestLogger(c).WithError(err).WithField("action", route.action).Error("Failed to encode audit details")
+			return
+		}
+
+		s.auditService.RecordEntry(&models.AuditLog{
+			UserID:     &user.ID,
+			TenantID:   tenantID,
+			Action:     route.action,
+			Resource:   route.resource,
+			ResourceID: resourceID,
+			Details:    string(data),
+			IPAddress:  c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+		})
+	}
+}
+
+// auditResource names the resource a request created, for its audit entry
+func auditResource(c *gin.Context, id uuid.UUID) {
+	c.Set(auditResourceIDKey, id.String())
+}
+
+// auditChanges adds the fields a request changed to its audit entry
+func auditChanges(c *gin.Context, before, after interface{}) {
+	c.Set(auditChangesKey, services.AuditDiff(before, after))
+}
+
+// redactAuditValue replaces secrets in a decoded request body
+func redactAuditValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSecretField(key) {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactAuditValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactAuditValue(v[i])
+		}
+	}
+	return value
+}
+
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, secret := range []string{"password", "secret", "token", "code", "key"} {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+	return false
+}
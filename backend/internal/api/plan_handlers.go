@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listPlans returns the public plan catalog
+func (s *Server) listPlans(c *gin.Context) {
+	s.respondPlans(c, false)
+}
+
+func (s *Server) getPlan(c *gin.Context) {
+	plan, err := s.planService.GetPlan(models.TenantPlan(c.Param("id")))
+	if err == nil && !plan.Public {
+		err = services.ErrPlanNotFound
+	}
+	if err != nil {
+		s.respondPlanError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// getMyTenantPlan returns the active tenant's plan and subscription
+func (s *Server) getMyTenantPlan(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	current, err := s.planService.CurrentPlan(tenantID)
+	if err != nil {
+		s.respondPlanError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, current)
+}
+
+// previewMyTenantPlan reports whether the active tenant can change to a plan
+// without changing anything
+func (s *Server) previewMyTenantPlan(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	var req services.ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preview, err := s.planService.PreviewChange(tenantID, req.Plan, false)
+	if err != nil {
+		s.respondPlanError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
+// changeMyTenantPlan upgrades or downgrades the active tenant
+func (s *Server) changeMyTenantPlan(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	var req services.ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := s.planService.ChangePlan(tenantID, req.Plan, false); err != nil {
+		s.respondPlanError(c, err)
+		return
+	}
+
+	current, err := s.planService.CurrentPlan(tenantID)
+	if err != nil {
+		s.respondPlanError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, current)
+}
+
+// Admin plan endpoints
+func (s *Server) listAllPlans(c *gin.Context) {
+	s.respondPlans(c, true)
+}
+
+func (s *Server) savePlan(c *gin.Context) {
+	var req services.PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := s.planService.SavePlan(models.TenantPlan(c.Param("id")), &req)
+	if err != nil {
+		s.respondPlanError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (s *Server) respondPlans(c *gin.Context, includePrivate bool) {
+	plans, err := s.planService.ListPlans(includePrivate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list plans"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plans": plans,
+		"total": len(plans),
+	})
+}
+
+func (s *Server) respondPlanError(c *gin.Context, err error) {
+	var limits *services.PlanChangeError
+	if errors.As(err, &limits) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      limits.Error(),
+			"violations": limits.Violations,
+		})
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrPlanNotFound), errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getMyTenantQuota returns the active tenant's quota and current usage
+func (s *Server) getMyTenantQuota(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondQuotaReport(c, tenantID)
+}
+
+func (s *Server) getTenantQuota(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	s.respondQuotaReport(c, tenantID)
+}
+
+func (s *Server) updateTenantQuota(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req services.QuotaOverridesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := s.quotaService.SetOverrides(tenantID, &req)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (s *Server) respondQuotaReport(c *gin.Context, tenantID uuid.UUID) {
+	report, err := s.quotaService.Report(tenantID)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
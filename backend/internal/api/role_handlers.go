@@ -0,0 +1,173 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Role endpoints
+func (s *Server) getMyPermissions(c *gin.Context) {
+	user := s.getCurrentUser(c)
+	perms, err := s.rbacService.Permissions(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve permissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_id":      user.TenantID,
+		"role":           user.Role,
+		"custom_role_id": user.CustomRoleID,
+		"permissions":    perms,
+	})
+}
+
+func (s *Server) listRoles(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	roles, err := s.rbacService.ListRoles(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"built_in": s.rbacService.BuiltinRoles(),
+		"custom":   roles,
+	})
+}
+
+func (s *Server) createRole(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	var req services.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := s.rbacService.CreateRole(tenantID, &req, s.getCurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	auditResource(c, role.ID)
+
+	c.JSON(http.StatusCreated, role)
+}
+
+func (s *Server) updateRole(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var req services.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := s.rbacService.GetRole(tenantID, roleID)
+	if err != nil {
+		s.respondRoleError(c, err)
+		return
+	}
+
+	role, err := s.rbacService.UpdateRole(tenantID, roleID, &req, s.getCurrentUser(c))
+	if err != nil {
+		s.respondRoleError(c, err)
+		return
+	}
+	auditChanges(c, before, role)
+
+	c.JSON(http.StatusOK, role)
+}
+
+func (s *Server) deleteRole(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	roleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	if err := s.rbacService.DeleteRole(tenantID, roleID); err != nil {
+		s.respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+func (s *Server) assignMemberRole(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, _ := s.tenantService.GetMembership(userID, tenantID)
+
+	user, err := s.rbacService.AssignRole(tenantID, userID, &req, s.getCurrentUser(c))
+	if err != nil {
+		s.respondRoleError(c, err)
+		return
+	}
+	if after, err := s.tenantService.GetMembership(userID, tenantID); err == nil {
+		auditChanges(c, before, after)
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// requireTenant returns the request's active tenant, responding with 403 when
+// the request does not act in one
+func (s *Server) requireTenant(c *gin.Context) (uuid.UUID, bool) {
+	tenantID := s.activeTenantID(c)
+	if tenantID == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Tenant membership required"})
+		return uuid.Nil, false
+	}
+	return *tenantID, true
+}
+
+func (s *Server) respondRoleError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
@@ -1,26 +1,52 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/metrics"
 	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/ratelimit"
+	"nomad-services-api/internal/requestid"
 	"nomad-services-api/internal/services"
+	"nomad-services-api/internal/tracing"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type Server struct {
-	config         *config.Config
-	router         *gin.Engine
-	authService    *services.AuthService
-	serviceManager *services.ServiceManager
-	userService    *services.UserService
+	config              *config.Config
+	router              *gin.Engine
+	httpServer          *http.Server
+	authService         *services.AuthService
+	serviceManager      *services.ServiceManager
+	userService         *services.UserService
+	mfaService          *services.MFAService
+	apiKeyService       *services.ApiKeyService
+	rbacService         *services.RBACService
+	tenantService       *services.TenantService
+	invitationService   *services.InvitationService
+	quotaService        *services.QuotaService
+	planService         *services.PlanService
+	subscriptionService *services.SubscriptionService
+	meteringService     *services.MeteringService
+	invoiceService      *services.InvoiceService
+	billingService      *services.BillingService
+	estimateService     *services.EstimateService
+	budgetService       *services.BudgetService
+	auditService        *services.AuditService
+	healthService       *services.HealthService
+	rateLimiter         ratelimit.Store
 }
 
 func NewServer(
@@ -28,6 +54,22 @@ func NewServer(
 	authService *services.AuthService,
 	serviceManager *services.ServiceManager,
 	userService *services.UserService,
+	mfaService *services.MFAService,
+	apiKeyService *services.ApiKeyService,
+	rbacService *services.RBACService,
+	tenantService *services.TenantService,
+	invitationService *services.InvitationService,
+	quotaService *services.QuotaService,
+	planService *services.PlanService,
+	subscriptionService *services.SubscriptionService,
+	meteringService *services.MeteringService,
+	invoiceService *services.InvoiceService,
+	billingService *services.BillingService,
+	estimateService *services.EstimateService,
+	budgetService *services.BudgetService,
+	auditService *services.AuditService,
+	healthService *services.HealthService,
+	rateLimiter ratelimit.Store,
 ) *Server {
 	if cfg.Server.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -36,6 +78,14 @@ func NewServer(
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
+	router.Use(otelgin.Middleware(tracing.ServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		// Scraped and probed every few seconds
+		switch r.URL.Path {
+		case "/metrics", "/health", "/livez", "/readyz":
+			return false
+		}
+		return true
+	})))
 
 	// Disable automatic redirects to prevent CORS issues
 	router.RedirectTrailingSlash = false
@@ -45,103 +95,259 @@ func NewServer(
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = cfg.Server.CORSOrigins
 	corsConfig.AllowCredentials = true
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-API-Key", requestid.Header}
+	corsConfig.ExposeHeaders = []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", requestid.Header}
 	router.Use(cors.New(corsConfig))
 
 	server := &Server{
-		config:         cfg,
-		router:         router,
-		authService:    authService,
-		serviceManager: serviceManager,
-		userService:    userService,
+		config:              cfg,
+		router:              router,
+		authService:         authService,
+		serviceManager:      serviceManager,
+		userService:         userService,
+		mfaService:          mfaService,
+		apiKeyService:       apiKeyService,
+		rbacService:         rbacService,
+		tenantService:       tenantService,
+		invitationService:   invitationService,
+		quotaService:        quotaService,
+		planService:         planService,
+		subscriptionService: subscriptionService,
+		meteringService:     meteringService,
+		invoiceService:      invoiceService,
+		billingService:      billingService,
+		estimateService:     estimateService,
+		budgetService:       budgetService,
+		auditService:        auditService,
+		healthService:       healthService,
+		rateLimiter:         rateLimiter,
+	}
+
+	port := cfg.Server.Port
+	if !strings.HasPrefix(port, ":") {
+		port = ":" + port
 	}
+	server.httpServer = &http.Server{Addr: port, Handler: router}
 
 	server.setupRoutes()
 	return server
 }
 
 func (s *Server) setupRoutes() {
-	// Health check
+	s.router.Use(s.requestIDMiddleware())
+
+	if s.config.Server.MetricsEnabled {
+		s.router.Use(s.metricsMiddleware())
+		s.router.GET("/metrics", s.serveMetrics())
+	}
+
+	// Health checks
 	s.router.GET("/health", s.healthCheck)
+	s.router.GET("/livez", s.livez)
+	s.router.GET("/readyz", s.readyz)
 
 	// API v1 routes
 	v1 := s.router.Group("/api/v1")
 	{
 		// Authentication routes
 		auth := v1.Group("/auth")
+		auth.Use(s.rateLimitMiddleware(ratelimit.RouteClassAuth))
 		{
 			auth.POST("/register", s.register)
+			auth.POST("/signup", s.signup)
+			auth.GET("/slug-availability", s.slugAvailability)
+			auth.GET("/invitations/:token", s.previewInvitation)
+			auth.POST("/invitations/accept", s.acceptInvitationWithSignup)
 			auth.POST("/login", s.login)
 			auth.POST("/refresh", s.refreshToken)
+			auth.POST("/mfa/verify", s.verifyMFA)
+			auth.POST("/forgot-password", s.forgotPassword)
+			auth.POST("/reset-password", s.resetPassword)
+			auth.POST("/verify-email", s.verifyEmail)
+			auth.POST("/resend-verification", s.resendVerification)
+		}
+
+		// Payment provider webhooks, verified by signature
+		v1.POST("/webhooks/payments", s.handlePaymentWebhook)
+
+		// Plan catalog
+		plans := v1.Group("/plans")
+		plans.Use(s.rateLimitMiddleware(ratelimit.RouteClassRead))
+		{
+			plans.GET("", s.listPlans)
+			plans.GET("/:id", s.getPlan)
 		}
 
 		// Protected routes
 		protected := v1.Group("/")
-		protected.Use(s.authMiddleware())
+		protected.Use(s.authMiddleware(), s.rateLimitMiddleware(""), s.auditMiddleware())
 		{
 			// User routes
 			users := protected.Group("/users")
 			{
 				users.GET("/me", s.getMe)
 				users.PUT("/me", s.updateMe)
+				users.POST("/me/password", s.changePassword)
+				users.POST("/me/mfa/enroll", s.enrollMFA)
+				users.POST("/me/mfa/confirm", s.confirmMFA)
+				users.POST("/me/mfa/recovery-codes", s.regenerateRecoveryCodes)
+				users.DELETE("/me/mfa", s.disableMFA)
+				users.GET("/me/api-keys", s.listApiKeys)
+				users.POST("/me/api-keys", s.createApiKey)
+				users.DELETE("/me/api-keys/:id", s.revokeApiKey)
+				users.GET("/me/permissions", s.getMyPermissions)
+				users.POST("/me/invitations/accept", s.acceptInvitation)
+				users.GET("/me/tenants", s.listMyTenants)
 			}
 
+			protected.POST("/auth/switch-tenant", s.switchTenant)
+
 			// Service routes
 			servicesGroup := protected.Group("/services")
 			{
-				servicesGroup.POST("/", s.createService)
-				servicesGroup.GET("/", s.listServices)
-				servicesGroup.GET("/:id", s.getService)
-				servicesGroup.PUT("/:id", s.updateService)
-				servicesGroup.DELETE("/:id", s.deleteService)
-				servicesGroup.POST("/:id/start", s.startService)
-				servicesGroup.POST("/:id/stop", s.stopService)
-				servicesGroup.POST("/:id/restart", s.restartService)
-				servicesGroup.GET("/:id/logs", s.getServiceLogs)
-				servicesGroup.GET("/:id/metrics", s.getServiceMetrics)
+				servicesGroup.POST("/", s.requirePermission(models.PermissionServiceCreate), s.createService)
+				servicesGroup.POST("/estimate", s.requirePermission(models.PermissionServiceCreate), s.estimateServiceCost)
+				servicesGroup.GET("/", s.requirePermission(models.PermissionServiceRead), s.listServices)
+				servicesGroup.GET("/:id", s.requirePermission(models.PermissionServiceRead), s.getService)
+				servicesGroup.PUT("/:id", s.requirePermission(models.PermissionServiceUpdate), s.updateService)
+				servicesGroup.DELETE("/:id", s.requirePermission(models.PermissionServiceDelete), s.deleteService)
+				servicesGroup.POST("/:id/start", s.requirePermission(models.PermissionServiceDeploy), s.startService)
+				servicesGroup.POST("/:id/stop", s.requirePermission(models.PermissionServiceDeploy), s.stopService)
+				servicesGroup.POST("/:id/restart", s.requirePermission(models.PermissionServiceDeploy), s.restartService)
+				servicesGroup.POST("/:id/scale", s.requirePermission(models.PermissionServiceDeploy), s.scaleService)
+				servicesGroup.POST("/:id/plan", s.requirePermission(models.PermissionServiceDeploy), s.planServiceDeployment)
+				servicesGroup.GET("/:id/logs", s.requirePermission(models.PermissionServiceLogs), s.getServiceLogs)
+				servicesGroup.GET("/:id/metrics", s.requirePermission(models.PermissionServiceMetrics), s.getServiceMetrics)
+				servicesGroup.GET("/:id/usage", s.requirePermission(models.PermissionServiceMetrics), s.getServiceUsage)
 			}
 
 			// Add routes without trailing slash for better compatibility
-			protected.POST("/services", s.createService)
-			protected.GET("/services", s.listServices)
-			protected.GET("/templates", s.listServiceTemplates)
+			protected.POST("/services", s.requirePermission(models.PermissionServiceCreate), s.createService)
+			protected.GET("/services", s.requirePermission(models.PermissionServiceRead), s.listServices)
+			protected.GET("/templates", s.requirePermission(models.PermissionTemplateRead), s.listServiceTemplates)
 
 			// Service templates routes
 			templates := protected.Group("/templates")
+			templates.Use(s.requirePermission(models.PermissionTemplateRead))
 			{
 				templates.GET("/", s.listServiceTemplates)
 				templates.GET("/:id", s.getServiceTemplate)
 			}
 
+			// Tenant routes, scoped to the current user's tenant
+			tenant := protected.Group("/tenant")
+			{
+				tenant.GET("", s.requirePermission(models.PermissionTenantRead), s.getMyTenant)
+				tenant.PUT("", s.requirePermission(models.PermissionTenantManage), s.updateMyTenant)
+				tenant.GET("/quota", s.requirePermission(models.PermissionTenantRead), s.getMyTenantQuota)
+				tenant.GET("/plan", s.requirePermission(models.PermissionTenantRead), s.getMyTenantPlan)
+				tenant.PUT("/plan", s.requirePermission(models.PermissionTenantBilling), s.changeMyTenantPlan)
+				tenant.POST("/plan/preview", s.requirePermission(models.PermissionTenantBilling), s.previewMyTenantPlan)
+				tenant.POST("/subscription/cancel", s.requirePermission(models.PermissionTenantBilling), s.cancelMySubscription)
+				tenant.POST("/subscription/resume", s.requirePermission(models.PermissionTenantBilling), s.resumeMySubscription)
+				tenant.GET("/usage", s.requirePermission(models.PermissionTenantBilling), s.getMyTenantUsage)
+				tenant.GET("/budget", s.requirePermission(models.PermissionTenantBilling), s.getMyTenantBudget)
+				tenant.PUT("/budget", s.requirePermission(models.PermissionTenantBilling), s.setMyTenantBudget)
+				tenant.DELETE("/budget", s.requirePermission(models.PermissionTenantBilling), s.deleteMyTenantBudget)
+				tenant.GET("/invoices", s.requirePermission(models.PermissionTenantBilling), s.listMyInvoices)
+				tenant.GET("/invoices/:id", s.requirePermission(models.PermissionTenantBilling), s.getMyInvoice)
+				tenant.POST("/invoices/:id/pay", s.requirePermission(models.PermissionTenantBilling), s.payMyInvoice)
+				tenant.GET("/payment-methods", s.requirePermission(models.PermissionTenantBilling), s.listMyPaymentMethods)
+				tenant.POST("/payment-methods", s.requirePermission(models.PermissionTenantBilling), s.addMyPaymentMethod)
+				tenant.DELETE("/payment-methods/:id", s.requirePermission(models.PermissionTenantBilling), s.removeMyPaymentMethod)
+				tenant.GET("/members", s.requirePermission(models.PermissionTenantRead), s.listMyTenantMembers)
+				tenant.DELETE("/members/:id", s.requirePermission(models.PermissionUserManage), s.removeMyTenantMember)
+				tenant.GET("/invitations", s.requirePermission(models.PermissionUserInvite), s.listInvitations)
+				tenant.POST("/invitations", s.requirePermission(models.PermissionUserInvite), s.createInvitation)
+				tenant.POST("/invitations/:id/resend", s.requirePermission(models.PermissionUserInvite), s.resendInvitation)
+				tenant.DELETE("/invitations/:id", s.requirePermission(models.PermissionUserInvite), s.revokeInvitation)
+				tenant.GET("/roles", s.requirePermission(models.PermissionTenantRead), s.listRoles)
+				tenant.POST("/roles", s.requirePermission(models.PermissionRoleManage), s.createRole)
+				tenant.PUT("/roles/:id", s.requirePermission(models.PermissionRoleManage), s.updateRole)
+				tenant.DELETE("/roles/:id", s.requirePermission(models.PermissionRoleManage), s.deleteRole)
+				tenant.PUT("/members/:id/role", s.requirePermission(models.PermissionUserManage), s.assignMemberRole)
+
+				tenant.GET("/audit-logs", s.requirePermission(models.PermissionAuditRead), s.listMyTenantAuditLogs)
+				tenant.GET("/audit-logs/verify", s.requirePermission(models.PermissionAuditRead), s.verifyMyTenantAuditLogs)
+			}
+
 			// Admin routes
 			admin := protected.Group("/admin")
-			admin.Use(s.adminMiddleware())
 			{
-				admin.GET("/users", s.listUsers)
-				admin.PUT("/users/:id/role", s.updateUserRole)
-				admin.PUT("/users/:id/activate", s.activateUser)
-				admin.PUT("/users/:id/deactivate", s.deactivateUser)
+				admin.GET("/users", s.requirePermission(models.PermissionAdminUsers), s.listUsers)
+				admin.PUT("/users/:id/role", s.requirePermission(models.PermissionAdminUsers), s.updateUserRole)
+				admin.PUT("/users/:id/activate", s.requirePermission(models.PermissionAdminUsers), s.activateUser)
+				admin.PUT("/users/:id/deactivate", s.requirePermission(models.PermissionAdminUsers), s.deactivateUser)
+				admin.PUT("/users/:id/unlock", s.requirePermission(models.PermissionAdminUsers), s.unlockUser)
+				admin.DELETE("/users/:id/mfa", s.requirePermission(models.PermissionAdminUsers), s.resetUserMFA)
+				admin.GET("/tenants", s.requirePermission(models.PermissionAdminTenants), s.listTenants)
+				admin.POST("/tenants", s.requirePermission(models.PermissionAdminTenants), s.createTenant)
+				admin.GET("/tenants/:id", s.requirePermission(models.PermissionAdminTenants), s.getTenant)
+				admin.PUT("/tenants/:id", s.requirePermission(models.PermissionAdminTenants), s.updateTenant)
+				admin.DELETE("/tenants/:id", s.requirePermission(models.PermissionAdminTenants), s.deleteTenant)
+				admin.PUT("/tenants/:id/activate", s.requirePermission(models.PermissionAdminTenants), s.activateTenant)
+				admin.PUT("/tenants/:id/deactivate", s.requirePermission(models.PermissionAdminTenants), s.deactivateTenant)
+				admin.PUT("/tenants/:id/mfa", s.requirePermission(models.PermissionAdminTenants), s.updateTenantMFAPolicy)
+				admin.GET("/tenants/:id/quota", s.requirePermission(models.PermissionAdminTenants), s.getTenantQuota)
+				admin.PUT("/tenants/:id/quota", s.requirePermission(models.PermissionAdminTenants), s.updateTenantQuota)
+				admin.GET("/tenants/:id/members", s.requirePermission(models.PermissionAdminTenants), s.listTenantMembers)
+				admin.POST("/tenants/:id/members", s.requirePermission(models.PermissionAdminTenants), s.addTenantMember)
+				admin.DELETE("/tenants/:id/members/:userId", s.requirePermission(models.PermissionAdminTenants), s.removeTenantMember)
+				admin.POST("/tenants/:id/subscription/payments", s.requirePermission(models.PermissionAdminTenants), s.recordTenantPayment)
+				admin.GET("/tenants/:id/usage", s.requirePermission(models.PermissionAdminTenants), s.getTenantUsage)
+				admin.GET("/tenants/:id/budget", s.requirePermission(models.PermissionAdminTenants), s.getTenantBudget)
+				admin.PUT("/tenants/:id/budget", s.requirePermission(models.PermissionAdminTenants), s.setTenantBudget)
+				admin.DELETE("/tenants/:id/budget", s.requirePermission(models.PermissionAdminTenants), s.deleteTenantBudget)
+				admin.GET("/tenants/:id/invoices", s.requirePermission(models.PermissionAdminTenants), s.listTenantInvoices)
+				admin.GET("/invoices/:id", s.requirePermission(models.PermissionAdminTenants), s.getInvoice)
+				admin.POST("/invoices/:id/lines", s.requirePermission(models.PermissionAdminTenants), s.addInvoiceLine)
+				admin.POST("/invoices/:id/finalize", s.requirePermission(models.PermissionAdminTenants), s.finalizeInvoice)
+				admin.POST("/invoices/:id/pay", s.requirePermission(models.PermissionAdminTenants), s.payInvoice)
+				admin.POST("/invoices/:id/charge", s.requirePermission(models.PermissionAdminTenants), s.chargeInvoice)
+				admin.POST("/invoices/:id/void", s.requirePermission(models.PermissionAdminTenants), s.voidInvoice)
+				admin.GET("/plans", s.requirePermission(models.PermissionAdminTenants), s.listAllPlans)
+				admin.PUT("/plans/:id", s.requirePermission(models.PermissionAdminTenants), s.savePlan)
+
+				admin.GET("/audit-logs", s.requirePermission(models.PermissionAdminAudit), s.listAuditLogs)
+				admin.GET("/audit-logs/verify", s.requirePermission(models.PermissionAdminAudit), s.verifyAuditLogs)
+				admin.GET("/audit-checkpoints", s.requirePermission(models.PermissionAdminAudit), s.listAuditCheckpoints)
+				admin.POST("/audit-checkpoints", s.requirePermission(models.PermissionAdminAudit), s.createAuditCheckpoints)
+
+				// Diagnostics
+				admin.GET("/diagnostics", s.requirePermission(models.PermissionAdminSystem), s.getDiagnostics)
 			}
 		}
 	}
 }
 
+// Start serves the API until Shutdown is called
 func (s *Server) Start() error {
-	port := s.config.Server.Port
-	if !strings.HasPrefix(port, ":") {
-		port = ":" + port
+	logrus.Infof("Server starting on port %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
+}
 
-	logrus.Infof("Server starting on port %s", port)
-	return s.router.Run(port)
+// Shutdown stops accepting connections and waits until the requests in
+// flight have finished or ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
 
-// Health check endpoint
-func (s *Server) healthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "healthy",
-		"version": "1.0.0",
-	})
+// serveMetrics exposes the Prometheus metrics. When METRICS_TOKEN is set,
+// scrapers must send it as a bearer token.
+func (s *Server) serveMetrics() gin.HandlerFunc {
+	handler := metrics.Handler()
+	return func(c *gin.Context) {
+		if token := s.config.Server.MetricsToken; token != "" {
+			if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid metrics token"})
+				return
+			}
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
 }
 
 // Authentication endpoints
@@ -164,6 +370,40 @@ func (s *Server) register(c *gin.Context) {
 	})
 }
 
+func (s *Server) signup(c *gin.Context) {
+	var req services.SignupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := s.authService.Signup(&req)
+	if err != nil {
+		if errors.Is(err, services.ErrTenantSlugTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Organization created successfully",
+		"tenant":  response.Tenant,
+		"user":    response.User,
+	})
+}
+
+func (s *Server) slugAvailability(c *gin.Context) {
+	slug := strings.ToLower(strings.TrimSpace(c.Query("slug")))
+	if slug == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug is required"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s.tenantService.CheckSlug(slug))
+}
+
 func (s *Server) login(c *gin.Context) {
 	var req services.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -171,9 +411,9 @@ func (s *Server) login(c *gin.Context) {
 		return
 	}
 
-	response, err := s.authService.Login(&req)
+	response, err := s.authService.Login(&req, s.clientInfo(c))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		s.respondAuthError(c, err)
 		return
 	}
 
@@ -218,6 +458,13 @@ func (s *Server) updateMe(c *gin.Context) {
 		return
 	}
 
+	// A new address has to be verified again
+	emailChanged := req.Email != user.Email
+	if emailChanged {
+		user.EmailVerified = false
+		user.EmailVerifiedAt = nil
+	}
+
 	user.FirstName = req.FirstName
 	user.LastName = req.LastName
 	user.Email = req.Email
@@ -227,6 +474,12 @@ func (s *Server) updateMe(c *gin.Context) {
 		return
 	}
 
+	if emailChanged {
+		if err := s.authService.SendEmailVerification(user); err != nil {
+			requestLogger(c).WithError(err).WithField("user_id", user.ID).Error("Failed to send verification email")
+		}
+	}
+
 	c.JSON(http.StatusOK, user)
 }
 
@@ -238,19 +491,28 @@ func (s *Server) createService(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	service, err := s.serviceManager.CreateService(&req, user.ID, user.TenantID)
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	service, err := s.serviceManager.CreateService(c.Request.Context(), scope, &req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		s.respondServiceError(c, err, http.StatusBadRequest)
 		return
 	}
+	auditResource(c, service.ID)
 
 	c.JSON(http.StatusCreated, service)
 }
 
 func (s *Server) listServices(c *gin.Context) {
-	user := s.getCurrentUser(c)
-	services, err := s.serviceManager.ListServices(user.TenantID)
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	services, err := s.serviceManager.ListServices(c.Request.Context(), scope)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list services"})
 		return
@@ -269,10 +531,14 @@ func (s *Server) getService(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	service, err := s.serviceManager.GetService(serviceID, user.TenantID)
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	service, err := s.serviceManager.GetService(c.Request.Context(), scope, serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		s.respondServiceError(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -292,16 +558,23 @@ func (s *Server) updateService(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	service, err := s.serviceManager.GetService(serviceID, user.TenantID)
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	before, err := s.serviceManager.GetService(c.Request.Context(), scope, serviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		s.respondServiceError(c, err, http.StatusInternalServerError)
 		return
 	}
 
-	service.Name = req.Name
-	service.Description = req.Description
-	service.Config = req.Config
+	service, err := s.serviceManager.UpdateService(c.Request.Context(), scope, serviceID, &req)
+	if err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
+		return
+	}
+	auditChanges(c, before, service)
 
 	c.JSON(http.StatusOK, service)
 }
@@ -313,14 +586,16 @@ func (s *Server) deleteService(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	_, err = s.serviceManager.GetService(serviceID, user.TenantID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	if err := s.serviceManager.DeleteService(c.Request.Context(), scope, serviceID); err != nil {
+		s.respondServiceError(c, err, http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: Implement service deletion
 	c.JSON(http.StatusOK, gin.H{"message": "Service deleted successfully"})
 }
 
@@ -331,10 +606,14 @@ func (s *Server) startService(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	deployment, err := s.serviceManager.StartService(serviceID, user.ID)
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	deployment, err := s.serviceManager.StartService(c.Request.Context(), scope, serviceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		s.respondServiceError(c, err, http.StatusBadRequest)
 		return
 	}
 
@@ -351,9 +630,13 @@ func (s *Server) stopService(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	if err := s.serviceManager.StopService(serviceID, user.ID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	if err := s.serviceManager.StopService(c.Request.Context(), scope, serviceID); err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
 		return
 	}
 
@@ -367,15 +650,48 @@ func (s *Server) restartService(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	if err := s.serviceManager.RestartService(serviceID, user.ID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	if err := s.serviceManager.RestartService(c.Request.Context(), scope, serviceID); err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Service restarted successfully"})
 }
 
+func (s *Server) scaleService(c *gin.Context) {
+	serviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+		return
+	}
+
+	var req struct {
+		Instances int `json:"instances" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	service, err := s.serviceManager.ScaleService(c.Request.Context(), scope, serviceID, req.Instances)
+	if err != nil {
+		s.respondServiceError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, service)
+}
+
 func (s *Server) getServiceLogs(c *gin.Context) {
 	serviceID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -383,10 +699,14 @@ func (s *Server) getServiceLogs(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	logs, err := s.serviceManager.GetServiceLogs(serviceID, user.TenantID)
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	logs, err := s.serviceManager.GetServiceLogs(c.Request.Context(), scope, serviceID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.respondServiceError(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -400,10 +720,14 @@ func (s *Server) getServiceMetrics(c *gin.Context) {
 		return
 	}
 
-	user := s.getCurrentUser(c)
-	metrics, err := s.serviceManager.GetServiceMetrics(serviceID, user.TenantID)
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	metrics, err := s.serviceManager.GetServiceMetrics(c.Request.Context(), scope, serviceID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.respondServiceError(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -411,15 +735,34 @@ func (s *Server) getServiceMetrics(c *gin.Context) {
 }
 
 func (s *Server) listServiceTemplates(c *gin.Context) {
-	// TODO: Implement template listing
+	templates, err := s.serviceManager.ListTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list templates"})
+		return
+	}
+
+	priced := s.estimateService.EstimateTemplates(c.Request.Context(), s.activeTenantID(c), templates)
 	c.JSON(http.StatusOK, gin.H{
-		"templates": []gin.H{},
-		"total":     0,
+		"templates": priced,
+		"total":     len(priced),
 	})
 }
 
+// getServiceTemplate returns a template by name, with its estimate
 func (s *Server) getServiceTemplate(c *gin.Context) {
-	// TODO: Implement template retrieval
+	templates, err := s.serviceManager.ListTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list templates"})
+		return
+	}
+
+	for _, template := range templates {
+		if template.Name == c.Param("id") {
+			priced := s.estimateService.EstimateTemplates(c.Request.Context(), s.activeTenantID(c), []models.ServiceTemplate{template})
+			c.JSON(http.StatusOK, priced[0])
+			return
+		}
+	}
 	c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
 }
 
@@ -460,10 +803,22 @@ func (s *Server) updateUserRole(c *gin.Context) {
 		return
 	}
 
+	if !services.IsValidRole(models.UserRole(req.Role)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	before, err := s.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
 	if err := s.userService.UpdateUserRole(userID, models.UserRole(req.Role)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
 		return
 	}
+	auditChanges(c, gin.H{"role": before.Role}, gin.H{"role": req.Role})
 
 	c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
 }
@@ -498,7 +853,80 @@ func (s *Server) deactivateUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User deactivated successfully"})
 }
 
+func (s *Server) unlockUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := s.authService.UnlockAccount(userID, s.getCurrentUser(c), s.clientInfo(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
 // Helper methods
+func (s *Server) clientInfo(c *gin.Context) services.ClientInfo {
+	return services.ClientInfo{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// respondAuthError maps login errors to responses, adding Retry-After when throttled
+func (s *Server) respondAuthError(c *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": throttled.Error()})
+		return
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+}
+
+// scope returns the data scope of the current user. System admins can cross
+// tenants by passing ?all_tenants=true.
+func (s *Server) scope(c *gin.Context) (services.Scope, bool) {
+	user := s.getCurrentUser(c)
+	scope := services.Scope{
+		UserID:   user.ID,
+		TenantID: s.activeTenantID(c),
+	}
+
+	if c.Query("all_tenants") == "true" {
+		allowed, err := s.rbacService.HasPermission(user, models.PermissionAdminTenants)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve permissions"})
+			return scope, false
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return scope, false
+		}
+		scope.AllTenants = true
+	}
+
+	return scope, true
+}
+
+// respondServiceError maps ErrServiceNotFound to 404, ErrQuotaExceeded,
+// ErrTenantSuspended and ErrBudgetExceeded to 403 and other errors to status
+func (s *Server) respondServiceError(c *gin.Context, err error, status int) {
+	if errors.Is(err, services.ErrServiceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+	if errors.Is(err, services.ErrQuotaExceeded) || errors.Is(err, services.ErrTenantSuspended) || errors.Is(err, services.ErrBudgetExceeded) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
 func (s *Server) getCurrentUser(c *gin.Context) *models.User {
 	user, exists := c.Get("user")
 	if !exists {
@@ -507,6 +935,16 @@ func (s *Server) getCurrentUser(c *gin.Context) *models.User {
 	return user.(*models.User)
 }
 
+// activeTenantID returns the tenant the request acts in, as resolved from
+// the token claims or API key by authMiddleware
+func (s *Server) activeTenantID(c *gin.Context) *uuid.UUID {
+	tenantID, exists := c.Get("tenant_id")
+	if !exists {
+		return nil
+	}
+	return tenantID.(*uuid.UUID)
+}
+
 func (s *Server) getCurrentClaims(c *gin.Context) *services.Claims {
 	claims, exists := c.Get("claims")
 	if !exists {
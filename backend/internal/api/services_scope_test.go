@@ -0,0 +1,277 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/database"
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// scopeFixture is a service owned by tenant A and API keys for a member of
+// each tenant
+type scopeFixture struct {
+	server    *Server
+	serviceID uuid.UUID
+	keyA      string
+	keyB      string
+}
+
+// newScopeFixture builds a server on the PostgreSQL database named by
+// TEST_DB_NAME (connection settings come from the usual DB_* variables).
+// Fixtures use random names, so the database can be shared and reused. Nomad
+// points at an address nothing listens on: a request that gets past the
+// tenant scope fails with a Nomad error instead of a 404.
+func newScopeFixture(t *testing.T) *scopeFixture {
+	t.Helper()
+
+	dbName := os.Getenv("TEST_DB_NAME")
+	if dbName == "" {
+		t.Skip("TEST_DB_NAME is not set; these tests need a PostgreSQL database")
+	}
+
+	gin.SetMode(gin.TestMode)
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	cfg.Database.DBName = dbName
+	cfg.Nomad.Address = "http://127.0.0.1:1"
+	cfg.Nomad.TenantNamespaces = false
+	cfg.Server.MetricsEnabled = false
+
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+
+	server := newTestServer(t, db, cfg)
+	fixture := &scopeFixture{server: server}
+
+	tenantA, userA := createTenantMember(t, db, server.tenantService)
+	_, userB := createTenantMember(t, db, server.tenantService)
+
+	service := &models.Service{
+		Name:      "scope-" + uuid.NewString()[:8],
+		Type:      models.ServiceTypeWebServer,
+		TenantID:  &tenantA.ID,
+		CreatedBy: userA.ID,
+		Config:    models.ServiceConfig{Image: "nginx:latest", Instances: 1},
+	}
+	if err := db.Create(service).Error; err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	fixture.serviceID = service.ID
+
+	fixture.keyA = createTestApiKey(t, server.apiKeyService, userA)
+	fixture.keyB = createTestApiKey(t, server.apiKeyService, userB)
+	return fixture
+}
+
+// newTestServer wires the services like main does, without starting any
+// background loops
+func newTestServer(t *testing.T, db *gorm.DB, cfg *config.Config) *Server {
+	t.Helper()
+
+	passwordPolicy, err := services.NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatalf("failed to load password policy: %v", err)
+	}
+	auditService, err := services.NewAuditService(db, cfg)
+	if err != nil {
+		t.Fatalf("failed to create audit service: %v", err)
+	}
+
+	nomadService := services.NewNomadService(cfg)
+	userService := services.NewUserService(db)
+	mailService := services.NewMailService(&services.LogMailer{}, cfg)
+	mfaService := services.NewMFAService(db, cfg)
+	apiKeyService := services.NewApiKeyService(db)
+	rbacService := services.NewRBACService(db)
+	quotaService := services.NewQuotaService(db, nomadService, cfg)
+	planService := services.NewPlanService(db, quotaService)
+	namespaceService := services.NewNamespaceService(db, nomadService, quotaService, cfg)
+	tenantService := services.NewTenantService(db, namespaceService)
+	authService := services.NewAuthService(cfg, userService, mfaService, mailService, auditService, tenantService, passwordPolicy)
+	invitationService := services.NewInvitationService(db, cfg, mailService, authService, rbacService)
+	serviceManager := services.NewServiceManager(nomadService, quotaService, namespaceService, cfg)
+	serviceManager.SetDB(db)
+	invoiceService := services.NewInvoiceService(db, auditService, mailService, cfg)
+	subscriptionService := services.NewSubscriptionService(db, serviceManager, invoiceService, auditService, mailService, cfg)
+	billingService := services.NewBillingService(db, nil, invoiceService, subscriptionService, auditService)
+	estimateService := services.NewEstimateService(db, nomadService, quotaService, cfg)
+	meteringService := services.NewMeteringService(db, nomadService, namespaceService, cfg)
+	budgetService := services.NewBudgetService(db, serviceManager, auditService, mailService, cfg)
+	healthService := services.NewHealthService(db, nomadService, serviceManager, cfg)
+
+	if err := planService.SeedDefaults(); err != nil {
+		t.Fatalf("failed to seed plans: %v", err)
+	}
+
+	return NewServer(cfg, authService, serviceManager, userService, mfaService, apiKeyService, rbacService, tenantService,
+		invitationService, quotaService, planService, subscriptionService, meteringService, invoiceService, billingService,
+		estimateService, budgetService, auditService, healthService, nil)
+}
+
+// createTenantMember creates a tenant with a tenant admin, who holds every
+// service permission of the tenant but not admin:tenants
+func createTenantMember(t *testing.T, db *gorm.DB, tenantService *services.TenantService) (*models.Tenant, *models.User) {
+	t.Helper()
+
+	suffix := uuid.NewString()[:8]
+	tenant := &models.Tenant{
+		Name:        "Scope " + suffix,
+		Slug:        "scope-" + suffix,
+		IsActive:    true,
+		Plan:        models.TenantPlanPro,
+		MaxServices: 10,
+	}
+	if err := db.Create(tenant).Error; err != nil {
+		t.Fatalf("failed to create tenant: %v", err)
+	}
+
+	user := &models.User{
+		Email:         "scope-" + suffix + "@example.com",
+		Username:      "scope-" + suffix,
+		Password:      "unused",
+		Role:          models.UserRoleTenantAdmin,
+		IsActive:      true,
+		EmailVerified: true,
+		TenantID:      &tenant.ID,
+	}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := tenantService.AddMember(tenant.ID, user.ID, models.UserRoleTenantAdmin); err != nil {
+		t.Fatalf("failed to add tenant member: %v", err)
+	}
+	return tenant, user
+}
+
+func createTestApiKey(t *testing.T, apiKeyService *services.ApiKeyService, user *models.User) string {
+	t.Helper()
+
+	_, key, err := apiKeyService.CreateApiKey(user, &services.CreateApiKeyRequest{Name: "scope test"})
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+	return key
+}
+
+func (f *scopeFixture) do(t *testing.T, method, path, apiKey string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("failed to encode body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+
+	recorder := httptest.NewRecorder()
+	f.server.router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// serviceEndpoint is a route that acts on one service, with a valid body
+// where the route needs one. The path is relative to /services/:id.
+type serviceEndpoint struct {
+	name   string
+	method string
+	path   string
+	body   interface{}
+}
+
+var serviceEndpoints = []serviceEndpoint{
+	{"get", http.MethodGet, "", nil},
+	{"update", http.MethodPut, "", services.CreateServiceRequest{
+		Name:   "renamed",
+		Type:   models.ServiceTypeWebServer,
+		Config: models.ServiceConfig{Image: "nginx:latest", Instances: 1},
+	}},
+	{"delete", http.MethodDelete, "", nil},
+	{"start", http.MethodPost, "/start", nil},
+	{"stop", http.MethodPost, "/stop", nil},
+	{"restart", http.MethodPost, "/restart", nil},
+	{"scale", http.MethodPost, "/scale", map[string]int{"instances": 2}},
+	{"plan", http.MethodPost, "/plan", nil},
+	{"logs", http.MethodGet, "/logs", nil},
+	{"metrics", http.MethodGet, "/metrics", nil},
+	{"usage", http.MethodGet, "/usage", nil},
+}
+
+func TestServiceEndpointsHideOtherTenantsServices(t *testing.T) {
+	f := newScopeFixture(t)
+
+	for _, endpoint := range serviceEndpoints {
+		t.Run(endpoint.name, func(t *testing.T) {
+			path := "/api/v1/services/" + f.serviceID.String() + endpoint.path
+			recorder := f.do(t, endpoint.method, path, f.keyB, endpoint.body)
+
+			if recorder.Code != http.StatusNotFound {
+				t.Fatalf("%s %s as another tenant: got status %d, want %d: %s",
+					endpoint.method, path, recorder.Code, http.StatusNotFound, recorder.Body.String())
+			}
+			if !strings.Contains(recorder.Body.String(), "Service not found") {
+				t.Errorf("%s %s as another tenant: got body %s, want ErrServiceNotFound",
+					endpoint.method, path, recorder.Body.String())
+			}
+		})
+	}
+
+	// The service is still there and unchanged for its own tenant
+	recorder := f.do(t, http.MethodGet, "/api/v1/services/"+f.serviceID.String(), f.keyA, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET as the owning tenant: got status %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+	var service models.Service
+	if err := json.Unmarshal(recorder.Body.Bytes(), &service); err != nil {
+		t.Fatalf("failed to decode service: %v", err)
+	}
+	if service.Name == "renamed" {
+		t.Errorf("service was renamed by another tenant")
+	}
+}
+
+func TestAllTenantsRequiresAdminTenantsPermission(t *testing.T) {
+	f := newScopeFixture(t)
+
+	t.Run("list", func(t *testing.T) {
+		for _, key := range []string{f.keyA, f.keyB} {
+			recorder := f.do(t, http.MethodGet, "/api/v1/services?all_tenants=true", key, nil)
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("GET /api/v1/services?all_tenants=true without admin:tenants: got status %d, want %d: %s",
+					recorder.Code, http.StatusForbidden, recorder.Body.String())
+			}
+		}
+	})
+
+	for _, endpoint := range serviceEndpoints {
+		t.Run(endpoint.name, func(t *testing.T) {
+			path := "/api/v1/services/" + f.serviceID.String() + endpoint.path + "?all_tenants=true"
+
+			for _, key := range []string{f.keyA, f.keyB} {
+				recorder := f.do(t, endpoint.method, path, key, endpoint.body)
+				if recorder.Code != http.StatusForbidden {
+					t.Errorf("%s %s without admin:tenants: got status %d, want %d: %s",
+						endpoint.method, path, recorder.Code, http.StatusForbidden, recorder.Body.String())
+				}
+			}
+		})
+	}
+}
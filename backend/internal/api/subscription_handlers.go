@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cancelMySubscription ends the active tenant's subscription with its current
+// period
+func (s *Server) cancelMySubscription(c *gin.Context) {
+	s.setMySubscriptionCanceled(c, true)
+}
+
+// resumeMySubscription undoes a scheduled cancellation
+func (s *Server) resumeMySubscription(c *gin.Context) {
+	s.setMySubscriptionCanceled(c, false)
+}
+
+// recordTenantPayment settles a tenant's subscription and reactivates the
+// tenant if it was suspended (admin only)
+func (s *Server) recordTenantPayment(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	user := s.getCurrentUser(c)
+	subscription, err := s.subscriptionService.RecordPayment(tenantID, &user.ID, s.clientInfo(c))
+	if err != nil {
+		s.respondSubscriptionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (s *Server) setMySubscriptionCanceled(c *gin.Context, cancel bool) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	var subscription *models.Subscription
+	var err error
+	user := s.getCurrentUser(c)
+	if cancel {
+		subscription, err = s.subscriptionService.Cancel(tenantID, user.ID, s.clientInfo(c))
+	} else {
+		subscription, err = s.subscriptionService.Resume(tenantID, user.ID, s.clientInfo(c))
+	}
+	if err != nil {
+		s.respondSubscriptionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (s *Server) respondSubscriptionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSubscriptionNotFound), errors.Is(err, services.ErrTenantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
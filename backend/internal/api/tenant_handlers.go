@@ -0,0 +1,351 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Tenant endpoints for tenant admins, scoped to the current user's tenant
+func (s *Server) getMyTenant(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	tenant, err := s.tenantService.GetTenant(tenantID)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tenant)
+}
+
+func (s *Server) updateMyTenant(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	var req services.TenantSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := s.tenantService.GetTenant(tenantID)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	tenant, err := s.tenantService.UpdateSettings(tenantID, &req)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+	auditChanges(c, before, tenant)
+
+	c.JSON(http.StatusOK, tenant)
+}
+
+func (s *Server) listMyTenantMembers(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondTenantMembers(c, tenantID)
+}
+
+func (s *Server) removeMyTenantMember(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if userID == s.getCurrentUser(c).ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove yourself from the tenant"})
+		return
+	}
+
+	if err := s.tenantService.RemoveMember(tenantID, userID); err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// listMyTenants returns the tenants the current user belongs to, flagging the
+// one the request acts in
+func (s *Server) listMyTenants(c *gin.Context) {
+	user := s.getCurrentUser(c)
+	memberships, err := s.tenantService.ListMemberships(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tenants"})
+		return
+	}
+
+	type tenantMembership struct {
+		models.TenantMembership
+		Active bool `json:"active"`
+	}
+
+	activeTenantID := s.activeTenantID(c)
+	tenants := make([]tenantMembership, 0, len(memberships))
+	for _, membership := range memberships {
+		tenants = append(tenants, tenantMembership{
+			TenantMembership: membership,
+			Active:           activeTenantID != nil && membership.TenantID == *activeTenantID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenants": tenants,
+		"total":   len(tenants),
+	})
+}
+
+// switchTenant issues tokens scoped to another tenant of the current user
+func (s *Server) switchTenant(c *gin.Context) {
+	var req struct {
+		TenantID uuid.UUID `json:"tenant_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := s.authService.SwitchTenant(s.getCurrentUser(c), req.TenantID, s.clientInfo(c))
+	if err != nil {
+		if errors.Is(err, services.ErrNotTenantMember) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Admin tenant endpoints
+func (s *Server) listTenants(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	tenants, total, err := s.tenantService.ListTenants(limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tenants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenants": tenants,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+func (s *Server) createTenant(c *gin.Context) {
+	var req services.CreateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenant, err := s.tenantService.CreateTenant(&req)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+	auditResource(c, tenant.ID)
+
+	c.JSON(http.StatusCreated, tenant)
+}
+
+func (s *Server) getTenant(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	tenant, err := s.tenantService.GetTenant(tenantID)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tenant)
+}
+
+func (s *Server) updateTenant(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req services.UpdateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, err := s.tenantService.GetTenant(tenantID)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	tenant, err := s.tenantService.UpdateTenant(tenantID, &req)
+	if err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+	auditChanges(c, before, tenant)
+
+	// Plan changes change the default quotas
+	s.quotaService.SyncNomadQuota(tenant)
+
+	c.JSON(http.StatusOK, tenant)
+}
+
+func (s *Server) deleteTenant(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := s.tenantService.DeleteTenant(tenantID); err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tenant deleted successfully"})
+}
+
+func (s *Server) activateTenant(c *gin.Context) {
+	s.setTenantActive(c, true)
+}
+
+func (s *Server) deactivateTenant(c *gin.Context) {
+	s.setTenantActive(c, false)
+}
+
+func (s *Server) listTenantMembers(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	s.respondTenantMembers(c, tenantID)
+}
+
+func (s *Server) addTenantMember(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID uuid.UUID       `json:"user_id" binding:"required"`
+		Role   models.UserRole `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.tenantService.AddMember(tenantID, req.UserID, req.Role); err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member added successfully"})
+}
+
+func (s *Server) removeTenantMember(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := s.tenantService.RemoveMember(tenantID, userID); err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+func (s *Server) setTenantActive(c *gin.Context, active bool) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := s.tenantService.SetActive(tenantID, active); err != nil {
+		s.respondTenantError(c, err)
+		return
+	}
+
+	message := "Tenant activated successfully"
+	if !active {
+		message = "Tenant deactivated successfully"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (s *Server) respondTenantMembers(c *gin.Context, tenantID uuid.UUID) {
+	members, err := s.tenantService.ListMembers(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tenant members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"members": members,
+		"total":   len(members),
+	})
+}
+
+func (s *Server) tenantIDParam(c *gin.Context) (uuid.UUID, bool) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return uuid.Nil, false
+	}
+	return tenantID, true
+}
+
+func (s *Server) respondTenantError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrTenantNotFound), errors.Is(err, services.ErrNotTenantMember):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTenantSlugTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getMyTenantUsage reports the active tenant's metered usage
+func (s *Server) getMyTenantUsage(c *gin.Context) {
+	tenantID, ok := s.requireTenant(c)
+	if !ok {
+		return
+	}
+
+	s.respondUsage(c, tenantID, nil)
+}
+
+// getServiceUsage reports the metered usage of one service
+func (s *Server) getServiceUsage(c *gin.Context) {
+	serviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+		return
+	}
+
+	scope, ok := s.scope(c)
+	if !ok {
+		return
+	}
+
+	service, err := s.serviceManager.GetService(c.Request.Context(), scope, serviceID)
+	if err != nil {
+		s.respondServiceError(c, err, http.StatusInternalServerError)
+		return
+	}
+	if service.TenantID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usage is only metered for tenant services"})
+		return
+	}
+
+	s.respondUsage(c, *service.TenantID, &service.ID)
+}
+
+// Admin usage endpoint
+func (s *Server) getTenantUsage(c *gin.Context) {
+	tenantID, ok := s.tenantIDParam(c)
+	if !ok {
+		return
+	}
+
+	s.respondUsage(c, tenantID, nil)
+}
+
+// respondUsage writes a usage report as JSON, or as CSV buckets when
+// format=csv is given. The range and granularity come from the from, to and
+// granularity query parameters; service_id narrows a tenant report.
+func (s *Server) respondUsage(c *gin.Context, tenantID uuid.UUID, serviceID *uuid.UUID) {
+	query := services.UsageQuery{
+		ServiceID:   serviceID,
+		Granularity: models.UsageGranularity(c.Query("granularity")),
+	}
+
+	if serviceID == nil && c.Query("service_id") != "" {
+		id, err := uuid.Parse(c.Query("service_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+			return
+		}
+		query.ServiceID = &id
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	} {
+		value, ok := parseUsageTime(c.Query(param.name))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s: use RFC 3339 or YYYY-MM-DD", param.name)})
+			return
+		}
+		*param.value = value
+	}
+
+	report, err := s.meteringService.Report(tenantID, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		writeUsageCSV(c, report)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func writeUsageCSV(c *gin.Context, report *services.UsageReport) {
+	filename := fmt.Sprintf("usage-%s-%s.csv", report.TenantID, report.From.Format("2006-01-02"))
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"bucket_start", "granularity", "service_id", "service_name",
+		"allocation_seconds", "cpu_mhz_seconds", "memory_mb_seconds", "disk_mb_seconds"})
+	for _, bucket := range report.Buckets {
+		w.Write([]string{
+			bucket.BucketStart.UTC().Format(time.RFC3339),
+			string(bucket.Granularity),
+			bucket.ServiceID.String(),
+			bucket.ServiceName,
+			strconv.FormatInt(bucket.AllocationSeconds, 10),
+			strconv.FormatInt(bucket.CPUSeconds, 10),
+			strconv.FormatInt(bucket.MemorySeconds, 10),
+			strconv.FormatInt(bucket.DiskSeconds, 10),
+		})
+	}
+	w.Flush()
+}
+
+// parseUsageTime accepts an RFC 3339 timestamp or a date. An empty value
+// is the zero time, which leaves the report default in place.
+func parseUsageTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), true
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
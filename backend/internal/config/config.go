@@ -8,11 +8,20 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	Nomad    NomadConfig
-	SaaS     SaaSConfig
+	Server    ServerConfig
+	Database  DatabaseConfig
+	JWT       JWTConfig
+	Nomad     NomadConfig
+	SaaS      SaaSConfig
+	MFA       MFAConfig
+	Auth      AuthConfig
+	Mail      MailConfig
+	Password  PasswordConfig
+	RateLimit RateLimitConfig
+	Metering  MeteringConfig
+	Payments  PaymentsConfig
+	Audit     AuditConfig
+	Tracing   TracingConfig
 }
 
 type ServerConfig struct {
@@ -21,6 +30,11 @@ type ServerConfig struct {
 	LogLevel    string
 	LogFile     string
 	CORSOrigins []string
+
+	MetricsEnabled bool   // serve Prometheus metrics on /metrics
+	MetricsToken   string // bearer token /metrics requires, if set
+
+	ShutdownTimeout time.Duration // how long in-flight requests get to finish on SIGTERM
 }
 
 type DatabaseConfig struct {
@@ -39,10 +53,61 @@ type JWTConfig struct {
 }
 
 type NomadConfig struct {
-	Address   string
-	JobsPath  string
-	Namespace string
-	Token     string
+	Address       string
+	JobsPath      string
+	Namespace     string
+	Token         string
+	QuotasEnabled bool // mirror tenant quotas into Nomad quota specs (Nomad Enterprise)
+
+	TenantNamespaces bool // run each tenant's jobs in its own namespace
+	TenantTokens     bool // use a tenant-scoped ACL token for each tenant's jobs
+
+	ReconcileInterval time.Duration // how often service statuses are refreshed from Nomad
+}
+
+type MFAConfig struct {
+	Issuer            string
+	ChallengeDuration time.Duration
+}
+
+type AuthConfig struct {
+	RequireEmailVerification  bool
+	EmailVerificationDuration time.Duration
+	PasswordResetDuration     time.Duration
+	MaxFailedLogins           int
+	LockoutDuration           time.Duration
+	LoginBackoffBase          time.Duration
+	LoginBackoffMax           time.Duration
+	InvitationDuration        time.Duration
+}
+
+type PasswordConfig struct {
+	MinLength            int
+	RequireUpper         bool
+	RequireLower         bool
+	RequireDigit         bool
+	RequireSymbol        bool
+	BreachedPasswordFile string
+}
+
+type MailConfig struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	FileDir      string
+	AppURL       string
+}
+
+type RateLimitConfig struct {
+	Enabled       bool
+	Backend       string
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	DefaultPlan   string
 }
 
 type SaaSConfig struct {
@@ -50,8 +115,50 @@ type SaaSConfig struct {
 	MaxServicesPerTenant int
 	PricingEnabled  bool
 	BillingEnabled  bool
+	SignupMode      string // open, invite_only or disabled
+
+	SubscriptionCheckInterval time.Duration // how often the scheduler advances subscriptions
+	PaymentGracePeriod        time.Duration // how long a subscription may stay past due
+
+	Currency             string        // ISO 4217 code invoices are issued in
+	InvoicePrefix        string        // prefix of invoice numbers, e.g. INV-2024-00001
+	InvoiceFinalizeDelay time.Duration // how long invoices stay drafts for adjustments
+
+	BudgetCheckInterval time.Duration // how often spend is compared with tenant budgets
+}
+
+type MeteringConfig struct {
+	Enabled  bool
+	Interval time.Duration // length of each metered slot
+}
+
+type PaymentsConfig struct {
+	Provider        string // none, fake or stripe
+	StripeSecretKey string
+	StripeAPIURL    string // a Stripe-compatible API
+	WebhookSecret   string
+}
+
+type AuditConfig struct {
+	SigningKey         string        // base64 Ed25519 seed; checkpoints are disabled without it
+	CheckpointInterval time.Duration // how often audit chain heads are signed
+	CheckpointFile     string        // optional file each checkpoint is appended to as a JSON line
 }
 
+type TracingConfig struct {
+	Exporter     string  // none, stdout or otlp
+	OTLPEndpoint string  // host:port of an OTLP/HTTP collector
+	OTLPInsecure bool    // send to the collector over plain HTTP
+	SampleRatio  float64 // share of new traces recorded; traces started upstream follow the caller
+}
+
+// Signup modes for SaaSConfig.SignupMode
+const (
+	SignupModeOpen       = "open"
+	SignupModeInviteOnly = "invite_only"
+	SignupModeDisabled   = "disabled"
+)
+
 func Load() (*Config, error) {
 	return &Config{
 		Server: ServerConfig{
@@ -60,6 +167,11 @@ func Load() (*Config, error) {
 			LogLevel:    getEnv("LOG_LEVEL", "info"),
 			LogFile:     getEnv("LOG_FILE", ""),
 			CORSOrigins: strings.Split(getEnv("CORS_ORIGINS", "http://localhost:4200,http://localhost:3000"), ","),
+
+			MetricsEnabled: getBoolEnv("METRICS_ENABLED", false),
+			MetricsToken:   getEnv("METRICS_TOKEN", ""),
+
+			ShutdownTimeout: getDurationEnv("SHUTDOWN_TIMEOUT", 30*time.Second),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -75,16 +187,89 @@ func Load() (*Config, error) {
 			RefreshDuration: getDurationEnv("JWT_REFRESH_DURATION", 7*24*time.Hour),
 		},
 		Nomad: NomadConfig{
-			Address:   getEnv("NOMAD_ADDR", "http://127.0.0.1:4646"),
-			JobsPath:  getEnv("NOMAD_JOBS_PATH", "../jobs"),
-			Namespace: getEnv("NOMAD_NAMESPACE", "default"),
-			Token:     getEnv("NOMAD_TOKEN", ""),
+			Address:          getEnv("NOMAD_ADDR", "http://127.0.0.1:4646"),
+			JobsPath:         getEnv("NOMAD_JOBS_PATH", "../jobs"),
+			Namespace:        getEnv("NOMAD_NAMESPACE", "default"),
+			Token:            getEnv("NOMAD_TOKEN", ""),
+			QuotasEnabled:    getBoolEnv("NOMAD_QUOTAS_ENABLED", false),
+			TenantNamespaces: getBoolEnv("NOMAD_TENANT_NAMESPACES", false),
+			TenantTokens:     getBoolEnv("NOMAD_TENANT_ACL_TOKENS", false),
+
+			ReconcileInterval: getDurationEnv("NOMAD_RECONCILE_INTERVAL", 30*time.Second),
 		},
 		SaaS: SaaSConfig{
-			MultiTenant:          getBoolEnv("SAAS_MULTI_TENANT", false),
-			MaxServicesPerTenant: getIntEnv("SAAS_MAX_SERVICES_PER_TENANT", 50),
-			PricingEnabled:       getBoolEnv("SAAS_PRICING_ENABLED", false),
-			BillingEnabled:       getBoolEnv("SAAS_BILLING_ENABLED", false),
+			MultiTenant:               getBoolEnv("SAAS_MULTI_TENANT", false),
+			MaxServicesPerTenant:      getIntEnv("SAAS_MAX_SERVICES_PER_TENANT", 50),
+			PricingEnabled:            getBoolEnv("SAAS_PRICING_ENABLED", false),
+			BillingEnabled:            getBoolEnv("SAAS_BILLING_ENABLED", false),
+			SignupMode:                getEnv("SAAS_SIGNUP_MODE", "open"),
+			SubscriptionCheckInterval: getDurationEnv("SAAS_SUBSCRIPTION_CHECK_INTERVAL", 5*time.Minute),
+			PaymentGracePeriod:        getDurationEnv("SAAS_PAYMENT_GRACE_PERIOD", 72*time.Hour),
+			Currency:                  getEnv("SAAS_CURRENCY", "USD"),
+			InvoicePrefix:             getEnv("SAAS_INVOICE_PREFIX", "INV"),
+			InvoiceFinalizeDelay:      getDurationEnv("SAAS_INVOICE_FINALIZE_DELAY", time.Hour),
+			BudgetCheckInterval:       getDurationEnv("SAAS_BUDGET_CHECK_INTERVAL", 15*time.Minute),
+		},
+		MFA: MFAConfig{
+			Issuer:            getEnv("MFA_ISSUER", "Nomad Services"),
+			ChallengeDuration: getDurationEnv("MFA_CHALLENGE_DURATION", 5*time.Minute),
+		},
+		Auth: AuthConfig{
+			RequireEmailVerification:  getBoolEnv("AUTH_REQUIRE_EMAIL_VERIFICATION", false),
+			EmailVerificationDuration: getDurationEnv("AUTH_EMAIL_VERIFICATION_DURATION", 48*time.Hour),
+			PasswordResetDuration:     getDurationEnv("AUTH_PASSWORD_RESET_DURATION", time.Hour),
+			MaxFailedLogins:           getIntEnv("AUTH_MAX_FAILED_LOGINS", 5),
+			LockoutDuration:           getDurationEnv("AUTH_LOCKOUT_DURATION", 15*time.Minute),
+			LoginBackoffBase:          getDurationEnv("AUTH_LOGIN_BACKOFF_BASE", time.Second),
+			LoginBackoffMax:           getDurationEnv("AUTH_LOGIN_BACKOFF_MAX", 5*time.Minute),
+			InvitationDuration:        getDurationEnv("AUTH_INVITATION_DURATION", 7*24*time.Hour),
+		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "log"),
+			From:         getEnv("MAIL_FROM", "Nomad Services <no-reply@localhost>"),
+			SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+			SMTPPort:     getEnv("SMTP_PORT", "587"),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			FileDir:      getEnv("MAIL_FILE_DIR", "./mail"),
+			AppURL:       getEnv("APP_URL", "http://localhost:4200"),
+		},
+		Password: PasswordConfig{
+			MinLength:            getIntEnv("PASSWORD_MIN_LENGTH", 8),
+			RequireUpper:         getBoolEnv("PASSWORD_REQUIRE_UPPER", false),
+			RequireLower:         getBoolEnv("PASSWORD_REQUIRE_LOWER", false),
+			RequireDigit:         getBoolEnv("PASSWORD_REQUIRE_DIGIT", false),
+			RequireSymbol:        getBoolEnv("PASSWORD_REQUIRE_SYMBOL", false),
+			BreachedPasswordFile: getEnv("PASSWORD_BREACHED_LIST_FILE", ""),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:       getBoolEnv("RATE_LIMIT_ENABLED", true),
+			Backend:       getEnv("RATE_LIMIT_BACKEND", "memory"),
+			RedisAddr:     getEnv("RATE_LIMIT_REDIS_ADDR", "localhost:6379"),
+			RedisPassword: getEnv("RATE_LIMIT_REDIS_PASSWORD", ""),
+			RedisDB:       getIntEnv("RATE_LIMIT_REDIS_DB", 0),
+			DefaultPlan:   getEnv("RATE_LIMIT_DEFAULT_PLAN", "pro"),
+		},
+		Metering: MeteringConfig{
+			Enabled:  getBoolEnv("METERING_ENABLED", true),
+			Interval: getDurationEnv("METERING_INTERVAL", time.Minute),
+		},
+		Payments: PaymentsConfig{
+			Provider:        getEnv("PAYMENTS_PROVIDER", "none"),
+			StripeSecretKey: getEnv("PAYMENTS_STRIPE_SECRET_KEY", ""),
+			StripeAPIURL:    getEnv("PAYMENTS_STRIPE_API_URL", "https://api.stripe.com"),
+			WebhookSecret:   getEnv("PAYMENTS_WEBHOOK_SECRET", ""),
+		},
+		Audit: AuditConfig{
+			SigningKey:         getEnv("AUDIT_SIGNING_KEY", ""),
+			CheckpointInterval: getDurationEnv("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+			CheckpointFile:     getEnv("AUDIT_CHECKPOINT_FILE", ""),
+		},
+		Tracing: TracingConfig{
+			Exporter:     getEnv("TRACING_EXPORTER", "none"),
+			OTLPEndpoint: getEnv("TRACING_OTLP_ENDPOINT", "localhost:4318"),
+			OTLPInsecure: getBoolEnv("TRACING_OTLP_INSECURE", true),
+			SampleRatio:  getFloatEnv("TRACING_SAMPLE_RATIO", 1),
 		},
 	}, nil
 }
@@ -114,6 +299,15 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return defaultValue
+}
+
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
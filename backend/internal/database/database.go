@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -48,15 +49,95 @@ func Initialize(cfg *config.Config) (*gorm.DB, error) {
 	return db, nil
 }
 
+// migratedModels are the models whose tables AutoMigrate keeps up to date
+var migratedModels = []interface{}{
+	&models.User{},
+	&models.Tenant{},
+	&models.Plan{},
+	&models.Role{},
+	&models.TenantMembership{},
+	&models.TenantQuota{},
+	&models.Service{},
+	&models.ServiceDeployment{},
+	&models.ServiceTemplate{},
+	&models.AuditLog{},
+	&models.AuditChainHead{},
+	&models.AuditCheckpoint{},
+	&models.ApiKey{},
+	&models.Subscription{},
+	&models.UsageRecord{},
+	&models.MeteringSlot{},
+	&models.Invoice{},
+	&models.InvoiceLine{},
+	&models.InvoiceCounter{},
+	&models.PaymentEvent{},
+	&models.TenantBudget{},
+	&models.MFARecoveryCode{},
+	&models.UserToken{},
+	&models.Invitation{},
+}
+
 func autoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
-		&models.User{},
-		&models.Tenant{},
-		&models.Service{},
-		&models.ServiceDeployment{},
-		&models.ServiceTemplate{},
-		&models.AuditLog{},
-		&models.ApiKey{},
-		&models.Subscription{},
-	)
+	if err := db.AutoMigrate(migratedModels...); err != nil {
+		return err
+	}
+	if err := dropAuditTenantForeignKey(db); err != nil {
+		return err
+	}
+
+	return backfillTenantMemberships(db)
+}
+
+// dropAuditTenantForeignKey removes the foreign key from audit entries to
+// their tenant that earlier versions created, which kept the entries of a
+// tenant from outliving it
+func dropAuditTenantForeignKey(db *gorm.DB) error {
+	return db.Exec("ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS fk_audit_logs_tenant").Error
+}
+
+// backfillTenantMemberships creates memberships for users that were assigned
+// to a tenant before users could belong to several tenants
+func backfillTenantMemberships(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO tenant_memberships (user_id, tenant_id, role, created_at, updated_at)
+		SELECT id, tenant_id, CASE WHEN role = ? THEN ? ELSE role END, NOW(), NOW()
+		FROM users
+		WHERE tenant_id IS NOT NULL
+		ON CONFLICT (user_id, tenant_id) DO NOTHING`,
+		models.UserRoleAdmin, models.UserRoleTenantAdmin,
+	).Error
+}
+
+// MissingTables returns the tables of migrated models that don't exist in the
+// database's current schema, i.e. the migrations this build expects that
+// have not run
+func MissingTables(ctx context.Context, db *gorm.DB) ([]string, error) {
+	tables := make([]string, 0, len(migratedModels))
+	for _, model := range migratedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("failed to parse model: %w", err)
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+
+	var existing []string
+	if err := db.WithContext(ctx).Raw(`
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = CURRENT_SCHEMA() AND table_name IN ?`, tables).
+		Scan(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	found := make(map[string]bool, len(existing))
+	for _, table := range existing {
+		found[table] = true
+	}
+	missing := []string{}
+	for _, table := range tables {
+		if !found[table] {
+			missing = append(missing, table)
+		}
+	}
+	return missing, nil
 }
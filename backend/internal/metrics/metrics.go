@@ -0,0 +1,132 @@
+// Package metrics holds the Prometheus metrics the API server exposes on
+// /metrics. Metrics are registered with Registry rather than the global
+// registry, so only the server's own metrics and the Go and process
+// collectors are exported.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric name
+const Namespace = "nomad_services"
+
+// Registry holds every exported metric
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	nomadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "nomad_request_duration_seconds",
+		Help:      "Time taken by Nomad API operations, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	nomadErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "nomad_request_errors_total",
+		Help:      "Nomad API operations that failed, by operation.",
+	}, []string{"operation"})
+
+	reconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time taken by passes of the service status reconciler.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+
+	reconcileErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Passes of the service status reconciler that failed.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		nomadDuration,
+		nomadErrors,
+		reconcileDuration,
+		reconcileErrors,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTPRequest records a handled request. route is the route pattern,
+// not the request path, to keep the number of series bounded.
+func ObserveHTTPRequest(method, route, status string, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, status).Inc()
+	httpDuration.WithLabelValues(method, route, status).Observe(duration.Seconds())
+}
+
+// ObserveNomadRequest records a Nomad API operation and whether it failed
+func ObserveNomadRequest(operation string, duration time.Duration, err error) {
+	nomadDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil {
+		nomadErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveReconcile records a pass of the service status reconciler
+func ObserveReconcile(duration time.Duration, err error) {
+	reconcileDuration.Observe(duration.Seconds())
+	if err != nil {
+		reconcileErrors.Inc()
+	}
+}
+
+// RegisterDBStats exports the connection pool statistics of db
+func RegisterDBStats(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterReconcilerLag exports the time since the reconciler last finished
+// a pass, as reported by lastPass. Before the first pass the lag counts from
+// when the server started.
+func RegisterReconcilerLag(lastPass func() time.Time) {
+	started := time.Now()
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "reconciler_lag_seconds",
+		Help:      "Seconds since the service status reconciler last finished a pass.",
+	}, func() float64 {
+		last := lastPass()
+		if last.IsZero() {
+			last = started
+		}
+		return time.Since(last).Seconds()
+	}))
+}
+
+// Register adds a collector, such as one reading counts from the database
+// on each scrape
+func Register(collector prometheus.Collector) {
+	Registry.MustRegister(collector)
+}
@@ -8,18 +8,28 @@ import (
 )
 
 type User struct {
-	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	Email     string     `gorm:"uniqueIndex;not null" json:"email"`
-	Username  string     `gorm:"uniqueIndex;not null" json:"username"`
-	Password  string     `gorm:"not null" json:"-"`
-	FirstName string     `json:"first_name"`
-	LastName  string     `json:"last_name"`
-	Role      UserRole   `gorm:"default:'user'" json:"role"`
-	IsActive  bool       `gorm:"default:true" json:"is_active"`
-	TenantID  *uuid.UUID `gorm:"type:uuid" json:"tenant_id"`
-	Tenant    *Tenant    `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
+	ID                  uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Email               string     `gorm:"uniqueIndex;not null" json:"email"`
+	Username            string     `gorm:"uniqueIndex;not null" json:"username"`
+	Password            string     `gorm:"not null" json:"-"`
+	FirstName           string     `json:"first_name"`
+	LastName            string     `json:"last_name"`
+	Role                UserRole   `gorm:"default:'user'" json:"role"`
+	IsActive            bool       `gorm:"default:true" json:"is_active"`
+	TenantID            *uuid.UUID `gorm:"type:uuid" json:"tenant_id"`
+	Tenant              *Tenant    `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
+	CustomRoleID        *uuid.UUID `gorm:"-" json:"custom_role_id"`
+	CustomRole          *Role      `gorm:"-" json:"custom_role,omitempty"`
+	MFAEnabled          bool       `gorm:"default:false" json:"mfa_enabled"`
+	MFASecret           string     `json:"-"`
+	MFALastStep         int64      `json:"-"`
+	MFAConfirmedAt      *time.Time `json:"mfa_confirmed_at,omitempty"`
+	EmailVerified       bool       `gorm:"default:false" json:"email_verified"`
+	EmailVerifiedAt     *time.Time `json:"email_verified_at,omitempty"`
+	FailedLoginAttempts int        `gorm:"default:0" json:"-"`
+	LockedUntil         *time.Time `json:"locked_until,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
 }
 
 type UserRole string
@@ -30,20 +40,129 @@ const (
 	UserRoleTenantAdmin UserRole = "tenant_admin"
 )
 
+// TenantMembership grants a user access to a tenant with a role and optional
+// custom role in that tenant. User.TenantID is the tenant selected at login;
+// while a request is handled, the user's TenantID, Role and CustomRoleID
+// reflect the membership of the active tenant.
+type TenantMembership struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_tenant_memberships_user_tenant" json:"user_id"`
+	User         *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	TenantID     uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_tenant_memberships_user_tenant;index" json:"tenant_id"`
+	Tenant       *Tenant    `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
+	Role         UserRole   `gorm:"default:'user'" json:"role"`
+	CustomRoleID *uuid.UUID `gorm:"type:uuid" json:"custom_role_id"`
+	CustomRole   *Role      `gorm:"foreignKey:CustomRoleID" json:"custom_role,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Permission is a single action a role can be granted, as "resource:action"
+type Permission string
+
+const (
+	PermissionServiceRead    Permission = "service:read"
+	PermissionServiceCreate  Permission = "service:create"
+	PermissionServiceUpdate  Permission = "service:update"
+	PermissionServiceDelete  Permission = "service:delete"
+	PermissionServiceDeploy  Permission = "service:deploy"
+	PermissionServiceLogs    Permission = "service:logs"
+	PermissionServiceMetrics Permission = "service:metrics"
+	PermissionTemplateRead   Permission = "template:read"
+	PermissionTenantRead     Permission = "tenant:read"
+	PermissionTenantManage   Permission = "tenant:manage"
+	PermissionTenantBilling  Permission = "tenant:billing"
+	PermissionUserInvite     Permission = "user:invite"
+	PermissionUserManage     Permission = "user:manage"
+	PermissionRoleManage     Permission = "role:manage"
+	PermissionAuditRead      Permission = "audit:read"
+	PermissionAdminUsers     Permission = "admin:users"
+	PermissionAdminTenants   Permission = "admin:tenants"
+	PermissionAdminAudit     Permission = "admin:audit"
+	PermissionAdminSystem    Permission = "admin:system"
+)
+
+// Role is a custom role defined by a tenant. Users assigned a custom role get
+// exactly its permissions instead of those of their built-in role.
+type Role struct {
+	ID          uuid.UUID    `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TenantID    uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_roles_tenant_name" json:"tenant_id"`
+	Name        string       `gorm:"not null;uniqueIndex:idx_roles_tenant_name" json:"name"`
+	Description string       `json:"description"`
+	Permissions []Permission `gorm:"serializer:json" json:"permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
 type Tenant struct {
-	ID           uuid.UUID    `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	Name         string       `gorm:"not null" json:"name"`
-	Slug         string       `gorm:"uniqueIndex;not null" json:"slug"`
-	Description  string       `json:"description"`
-	Domain       string       `gorm:"uniqueIndex" json:"domain"`
-	IsActive     bool         `gorm:"default:true" json:"is_active"`
-	Plan         TenantPlan   `gorm:"default:'free'" json:"plan"`
-	MaxServices  int          `gorm:"default:5" json:"max_services"`
-	Users        []User       `gorm:"foreignKey:TenantID" json:"users,omitempty"`
-	Services     []Service    `gorm:"foreignKey:TenantID" json:"services,omitempty"`
-	Subscription *Subscription `gorm:"foreignKey:TenantID" json:"subscription,omitempty"`
-	CreatedAt    time.Time    `json:"created_at"`
-	UpdatedAt    time.Time    `json:"updated_at"`
+	ID                   uuid.UUID     `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name                 string        `gorm:"not null" json:"name"`
+	Slug                 string        `gorm:"uniqueIndex;not null" json:"slug"`
+	Description          string        `json:"description"`
+	Domain               *string       `gorm:"uniqueIndex" json:"domain"`
+	IsActive             bool          `gorm:"default:true" json:"is_active"`
+	Plan                 TenantPlan    `gorm:"default:'free'" json:"plan"`
+	MaxServices          int           `gorm:"default:5" json:"max_services"`
+	RequireMFA           bool          `gorm:"default:false" json:"require_mfa"`
+	NomadNamespace       string        `json:"nomad_namespace"`
+	NomadTokenAccessorID string        `json:"-"`
+	NomadToken           string        `json:"-"`
+	SuspendedAt          *time.Time    `json:"suspended_at"`
+	SuspensionReason     string        `json:"suspension_reason,omitempty"`
+	TaxRate              float64       `json:"tax_rate"` // percent applied to invoices
+	TaxName              string        `json:"tax_name,omitempty"`
+	PaymentCustomerID    string        `json:"-"`
+	PaymentMethodID      string        `json:"-"` // default method invoices are charged to
+	Users                []User        `gorm:"foreignKey:TenantID" json:"users,omitempty"`
+	Services             []Service     `gorm:"foreignKey:TenantID" json:"services,omitempty"`
+	Subscription         *Subscription `gorm:"foreignKey:TenantID" json:"subscription,omitempty"`
+	CreatedAt            time.Time     `json:"created_at"`
+	UpdatedAt            time.Time     `json:"updated_at"`
+}
+
+// TenantQuota overrides a tenant's plan quotas. Nil fields use the plan
+// default; zero means unlimited.
+type TenantQuota struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"tenant_id"`
+	CPU         *int      `json:"cpu"`         // MHz
+	Memory      *int      `json:"memory"`      // MB
+	Disk        *int      `json:"disk"`        // MB
+	Allocations *int      `json:"allocations"` // running instances across all services
+	Instances   *int      `json:"instances"`   // instances per service
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BudgetCapAction is what happens once a tenant's spend reaches its budget
+type BudgetCapAction string
+
+const (
+	BudgetCapNone      BudgetCapAction = "none"       // alerts only
+	BudgetCapBlock     BudgetCapAction = "block"      // no new deployments or scaling up
+	BudgetCapScaleDown BudgetCapAction = "scale_down" // also scale running services to one instance
+)
+
+// TenantBudget is a tenant's monthly budget for metered usage charges.
+// Alerts go out once per billing period for the highest threshold crossed;
+// the cap is lifted when a new period starts or the budget is raised.
+type TenantBudget struct {
+	ID               uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TenantID         uuid.UUID       `gorm:"type:uuid;not null;uniqueIndex" json:"tenant_id"`
+	Amount           float64         `gorm:"not null" json:"amount"`
+	Thresholds       []int           `gorm:"serializer:json" json:"thresholds"` // percent of Amount
+	NotifyEmail      bool            `json:"notify_email"`
+	WebhookURL       string          `json:"webhook_url"`
+	WebhookSecret    string          `json:"-"`
+	CapAction        BudgetCapAction `gorm:"default:'none'" json:"cap_action"`
+	PeriodStart      time.Time       `json:"period_start"`
+	PeriodEnd        time.Time       `json:"period_end"`
+	Spend            float64         `json:"spend"`             // as of CheckedAt
+	AlertedThreshold int             `json:"alerted_threshold"` // highest threshold alerted this period
+	CappedAt         *time.Time      `json:"capped_at"`
+	CheckedAt        *time.Time      `json:"checked_at"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
 }
 
 type TenantPlan string
@@ -55,6 +174,45 @@ const (
 	TenantPlanEnterprise TenantPlan = "enterprise"
 )
 
+// Plan is an entry of the plan catalog. Limits of zero are unlimited; private
+// plans are only assigned by admins.
+type Plan struct {
+	ID            TenantPlan `gorm:"primary_key" json:"id"`
+	Name          string     `gorm:"not null" json:"name"`
+	Description   string     `json:"description"`
+	PricePerMonth float64    `json:"price_per_month"`
+	MaxServices   int        `json:"max_services"`
+	MaxSeats      int        `json:"max_seats"`   // members plus pending invitations
+	CPU           int        `json:"cpu"`         // MHz
+	Memory        int        `json:"memory"`      // MB
+	Disk          int        `json:"disk"`        // MB
+	Allocations   int        `json:"allocations"` // running instances across all services
+	Instances     int        `json:"instances"`   // instances per service
+	// Usage included in the monthly price; usage beyond it is billed per hour
+	IncludedCPUHours    int     `json:"included_cpu_hours"`    // GHz·h
+	IncludedMemoryHours int     `json:"included_memory_hours"` // GB·h
+	CPUHourPrice        float64 `json:"cpu_hour_price"`        // per GHz·h
+	MemoryHourPrice     float64 `json:"memory_hour_price"`     // per GB·h
+	// Per-minute API request limits for each user, tenant and API key on the plan
+	UserRateLimits   RateLimits `gorm:"embedded;embeddedPrefix:user_rate_limit_" json:"user_rate_limits"`
+	TenantRateLimits RateLimits `gorm:"embedded;embeddedPrefix:tenant_rate_limit_" json:"tenant_rate_limits"`
+	APIKeyRateLimits RateLimits `gorm:"embedded;embeddedPrefix:api_key_rate_limit_" json:"api_key_rate_limits"`
+	Features         []string   `gorm:"serializer:json" json:"features"`
+	Public           bool       `json:"public"`
+	SortOrder        int        `json:"sort_order"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// RateLimits are requests per minute for each route class of the API. Classes
+// left at zero use the built-in defaults for the plan.
+type RateLimits struct {
+	Auth   int `json:"auth"`
+	Read   int `json:"read"`
+	Write  int `json:"write"`
+	Deploy int `json:"deploy"`
+}
+
 type Service struct {
 	ID          uuid.UUID           `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	Name        string              `gorm:"not null" json:"name"`
@@ -85,14 +243,16 @@ const (
 type ServiceStatus string
 
 const (
-	ServiceStatusRunning ServiceStatus = "running"
-	ServiceStatusStopped ServiceStatus = "stopped"
-	ServiceStatusError   ServiceStatus = "error"
-	ServiceStatusPending ServiceStatus = "pending"
+	ServiceStatusRunning   ServiceStatus = "running"
+	ServiceStatusStopped   ServiceStatus = "stopped"
+	ServiceStatusError     ServiceStatus = "error"
+	ServiceStatusPending   ServiceStatus = "pending"
+	ServiceStatusSuspended ServiceStatus = "suspended" // stopped while the tenant is suspended
 )
 
 type ServiceConfig struct {
 	Image           string            `json:"image"`
+	Instances       int               `json:"instances"`
 	Ports           []int             `json:"ports"`
 	Environment     map[string]string `json:"environment"`
 	Volumes         []string          `json:"volumes"`
@@ -102,6 +262,14 @@ type ServiceConfig struct {
 	CustomVariables map[string]string `json:"custom_variables"`
 }
 
+// InstanceCount returns the number of instances to run; unset means one
+func (c ServiceConfig) InstanceCount() int {
+	if c.Instances < 1 {
+		return 1
+	}
+	return c.Instances
+}
+
 type ResourceConfig struct {
 	CPU    int `json:"cpu"`    // MHz
 	Memory int `json:"memory"` // MB
@@ -117,18 +285,19 @@ type HealthCheckConfig struct {
 }
 
 type ServiceDeployment struct {
-	ID          uuid.UUID        `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	ServiceID   uuid.UUID        `gorm:"type:uuid;not null" json:"service_id"`
-	Service     Service          `gorm:"foreignKey:ServiceID" json:"service,omitempty"`
-	Status      DeploymentStatus `gorm:"default:'pending'" json:"status"`
-	NomadJobID  string           `json:"nomad_job_id"`
-	StartedAt   *time.Time       `json:"started_at"`
-	CompletedAt *time.Time       `json:"completed_at"`
-	ErrorMsg    string           `json:"error_msg"`
-	DeployedBy  uuid.UUID        `gorm:"type:uuid;not null" json:"deployed_by"`
-	Deployer    User             `gorm:"foreignKey:DeployedBy" json:"deployer,omitempty"`
-	CreatedAt   time.Time        `json:"created_at"`
-	UpdatedAt   time.Time        `json:"updated_at"`
+	ID             uuid.UUID        `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ServiceID      uuid.UUID        `gorm:"type:uuid;not null" json:"service_id"`
+	Service        Service          `gorm:"foreignKey:ServiceID" json:"service,omitempty"`
+	Status         DeploymentStatus `gorm:"default:'pending'" json:"status"`
+	NomadJobID     string           `json:"nomad_job_id"`
+	NomadNamespace string           `json:"nomad_namespace"`
+	StartedAt      *time.Time       `json:"started_at"`
+	CompletedAt    *time.Time       `json:"completed_at"`
+	ErrorMsg       string           `json:"error_msg"`
+	DeployedBy     uuid.UUID        `gorm:"type:uuid;not null" json:"deployed_by"`
+	Deployer       User             `gorm:"foreignKey:DeployedBy" json:"deployer,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
 }
 
 type DeploymentStatus string
@@ -156,24 +325,113 @@ type ServiceTemplate struct {
 	UpdatedAt   time.Time     `json:"updated_at"`
 }
 
+// AuditLog is an entry of the audit log. TenantID is not a foreign key:
+// entries outlive their tenant, including the one recording its deletion.
 type AuditLog struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
-	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	TenantID  *uuid.UUID `gorm:"type:uuid" json:"tenant_id"`
-	Tenant    *Tenant   `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
-	Action    string    `gorm:"not null" json:"action"`
-	Resource  string    `gorm:"not null" json:"resource"`
-	Details   string    `gorm:"type:text" json:"details"`
-	IPAddress string    `json:"ip_address"`
-	UserAgent string    `json:"user_agent"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     *uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	User       *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	TenantID   *uuid.UUID `gorm:"type:uuid;index:idx_audit_logs_tenant_created;index:idx_audit_logs_chain" json:"tenant_id"`
+	Action     string     `gorm:"not null;index" json:"action"`
+	Resource   string     `gorm:"not null" json:"resource"`
+	ResourceID string     `gorm:"index" json:"resource_id,omitempty"`
+	Details    string     `gorm:"type:text" json:"details"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `gorm:"index:idx_audit_logs_tenant_created" json:"created_at"`
+
+	// Entries form one hash chain per tenant, and one for entries without a
+	// tenant. Sequence numbers each chain from 1; entries written before
+	// chaining was introduced have sequence 0 and no hashes.
+	Sequence int64  `gorm:"not null;default:0;index:idx_audit_logs_chain" json:"sequence"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// AuditChainHead is the last entry of an audit chain. Writers lock it while
+// appending, so each chain stays linear across API instances.
+type AuditChainHead struct {
+	TenantID  uuid.UUID `gorm:"type:uuid;primary_key"` // uuid.Nil for entries without a tenant
+	Sequence  int64     `gorm:"not null"`
+	Hash      string
+	UpdatedAt time.Time
 }
 
+// AuditCheckpoint is a signed statement of an audit chain's head at a point
+// in time. Exported checkpoints let the chain be verified against copies kept
+// outside the database.
+type AuditCheckpoint struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TenantID  *uuid.UUID `gorm:"type:uuid;index" json:"tenant_id"`
+	Sequence  int64      `gorm:"not null" json:"sequence"`
+	Hash      string     `gorm:"not null" json:"hash"`
+	KeyID     string     `gorm:"not null" json:"key_id"`
+	Signature string     `gorm:"not null" json:"signature"` // base64 Ed25519
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFARecoveryCode is a single-use fallback for a user's TOTP device. Only the
+// SHA-256 hash of the code is stored.
+type MFARecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// UserToken is a single-use, expiring token sent to a user by email. Only the
+// SHA-256 hash of the token is stored.
+type UserToken struct {
+	ID        uuid.UUID        `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID        `gorm:"type:uuid;not null;index" json:"user_id"`
+	Purpose   UserTokenPurpose `gorm:"not null" json:"purpose"`
+	TokenHash string           `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time        `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time       `json:"used_at"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+type UserTokenPurpose string
+
+const (
+	UserTokenPurposePasswordReset     UserTokenPurpose = "password_reset"
+	UserTokenPurposeEmailVerification UserTokenPurpose = "email_verification"
+)
+
+// Invitation invites an email address to join a tenant. Only the SHA-256
+// hash of the invitation token is stored.
+type Invitation struct {
+	ID           uuid.UUID        `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TenantID     uuid.UUID        `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	Tenant       *Tenant          `gorm:"foreignKey:TenantID" json:"tenant,omitempty"`
+	Email        string           `gorm:"not null;index" json:"email"`
+	Role         UserRole         `gorm:"default:'user'" json:"role"`
+	CustomRoleID *uuid.UUID       `gorm:"type:uuid" json:"custom_role_id"`
+	TokenHash    string           `gorm:"uniqueIndex;not null" json:"-"`
+	Status       InvitationStatus `gorm:"default:'pending'" json:"status"`
+	InvitedBy    uuid.UUID        `gorm:"type:uuid;not null" json:"invited_by"`
+	ExpiresAt    time.Time        `gorm:"not null" json:"expires_at"`
+	AcceptedAt   *time.Time       `json:"accepted_at"`
+	AcceptedBy   *uuid.UUID       `gorm:"type:uuid" json:"accepted_by"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+type InvitationStatus string
+
+const (
+	InvitationStatusPending  InvitationStatus = "pending"
+	InvitationStatusAccepted InvitationStatus = "accepted"
+	InvitationStatusRevoked  InvitationStatus = "revoked"
+	InvitationStatusExpired  InvitationStatus = "expired" // reported only, never stored
+)
+
 type ApiKey struct {
 	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	Name      string    `gorm:"not null" json:"name"`
-	Key       string    `gorm:"uniqueIndex;not null" json:"key"`
+	Key       string    `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the key
+	Prefix    string    `json:"prefix"`
 	UserID    uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
 	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	TenantID  *uuid.UUID `gorm:"type:uuid" json:"tenant_id"`
@@ -197,10 +455,121 @@ type Subscription struct {
 	CurrentPeriodStart time.Time       `json:"current_period_start"`
 	CurrentPeriodEnd   time.Time       `json:"current_period_end"`
 	CancelAtPeriodEnd  bool            `gorm:"default:false" json:"cancel_at_period_end"`
+	CanceledAt         *time.Time      `json:"canceled_at"`
 	CreatedAt       time.Time          `json:"created_at"`
 	UpdatedAt       time.Time          `json:"updated_at"`
 }
 
+// Invoice bills a tenant for a subscription period. Drafts can still be
+// adjusted; open invoices await payment.
+type Invoice struct {
+	ID             uuid.UUID     `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TenantID       uuid.UUID     `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	SubscriptionID *uuid.UUID    `gorm:"type:uuid" json:"subscription_id"`
+	Number         string        `gorm:"uniqueIndex;not null" json:"number"`
+	Status         InvoiceStatus `gorm:"default:'draft'" json:"status"`
+	Currency       string        `gorm:"not null" json:"currency"`
+	PeriodStart    time.Time     `json:"period_start"`
+	PeriodEnd      time.Time     `json:"period_end"`
+	Subtotal       float64       `json:"subtotal"`
+	TaxName        string        `json:"tax_name,omitempty"`
+	TaxRate        float64       `json:"tax_rate"`
+	Tax            float64       `json:"tax"`
+	Total          float64       `json:"total"`
+	IssuedAt       *time.Time    `json:"issued_at"`
+	DueAt          *time.Time    `json:"due_at"`
+	PaidAt         *time.Time    `json:"paid_at"`
+	VoidedAt       *time.Time    `json:"voided_at"`
+	PaymentID      string        `json:"payment_id,omitempty"` // the provider's charge
+	Lines          []InvoiceLine `gorm:"foreignKey:InvoiceID" json:"lines"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+type InvoiceStatus string
+
+const (
+	InvoiceStatusDraft InvoiceStatus = "draft"
+	InvoiceStatusOpen  InvoiceStatus = "open"
+	InvoiceStatusPaid  InvoiceStatus = "paid"
+	InvoiceStatusVoid  InvoiceStatus = "void"
+)
+
+type InvoiceLine struct {
+	ID          uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	InvoiceID   uuid.UUID       `gorm:"type:uuid;not null;index" json:"-"`
+	Type        InvoiceLineType `gorm:"not null" json:"type"`
+	Description string          `json:"description"`
+	Quantity    float64         `json:"quantity"`
+	Unit        string          `json:"unit,omitempty"`
+	UnitPrice   float64         `json:"unit_price"`
+	Amount      float64         `json:"amount"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type InvoiceLineType string
+
+const (
+	InvoiceLineSubscription InvoiceLineType = "subscription"
+	InvoiceLineUsage        InvoiceLineType = "usage"
+	InvoiceLineCredit       InvoiceLineType = "credit"
+	InvoiceLineAdjustment   InvoiceLineType = "adjustment"
+)
+
+// PaymentEvent is a processed payment provider webhook. Providers deliver
+// events at least once; the unique index makes processing idempotent.
+type PaymentEvent struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Provider    string     `gorm:"not null;uniqueIndex:idx_payment_events_provider_event" json:"provider"`
+	EventID     string     `gorm:"not null;uniqueIndex:idx_payment_events_provider_event" json:"event_id"`
+	Type        string     `gorm:"not null" json:"type"`
+	TenantID    *uuid.UUID `gorm:"type:uuid;index" json:"tenant_id"`
+	InvoiceID   *uuid.UUID `gorm:"type:uuid" json:"invoice_id"`
+	ChargeID    string     `json:"charge_id"`
+	Amount      float64    `json:"amount"`
+	Currency    string     `json:"currency"`
+	ProcessedAt time.Time  `json:"processed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// InvoiceCounter holds the last invoice number issued in a year
+type InvoiceCounter struct {
+	Year int `gorm:"primary_key;autoIncrement:false"`
+	Last int `gorm:"not null"`
+}
+
+// UsageRecord is the metered usage of a service in an hourly or daily
+// bucket. Resource-seconds multiply the reserved resources of each running
+// allocation by the seconds it ran.
+type UsageRecord struct {
+	ID                uuid.UUID        `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"-"`
+	TenantID          uuid.UUID        `gorm:"type:uuid;not null;index:idx_usage_records_tenant_bucket" json:"tenant_id"`
+	ServiceID         uuid.UUID        `gorm:"type:uuid;not null;uniqueIndex:idx_usage_records_service_bucket" json:"service_id"`
+	ServiceName       string           `json:"service_name"`
+	Granularity       UsageGranularity `gorm:"not null;uniqueIndex:idx_usage_records_service_bucket;index:idx_usage_records_tenant_bucket" json:"granularity"`
+	BucketStart       time.Time        `gorm:"not null;uniqueIndex:idx_usage_records_service_bucket;index:idx_usage_records_tenant_bucket" json:"bucket_start"`
+	AllocationSeconds int64            `json:"allocation_seconds"`
+	CPUSeconds        int64            `json:"cpu_mhz_seconds"`
+	MemorySeconds     int64            `json:"memory_mb_seconds"`
+	DiskSeconds       int64            `json:"disk_mb_seconds"`
+	CreatedAt         time.Time        `json:"-"`
+	UpdatedAt         time.Time        `json:"-"`
+}
+
+type UsageGranularity string
+
+const (
+	UsageGranularityHour UsageGranularity = "hour"
+	UsageGranularityDay  UsageGranularity = "day"
+)
+
+// MeteringSlot marks a metering interval as recorded, so usage is counted
+// once even when several API instances meter
+type MeteringSlot struct {
+	Start     time.Time `gorm:"primary_key"`
+	CreatedAt time.Time
+}
+
 type SubscriptionStatus string
 
 const (
@@ -208,6 +577,7 @@ const (
 	SubscriptionStatusCanceled SubscriptionStatus = "canceled"
 	SubscriptionStatusExpired  SubscriptionStatus = "expired"
 	SubscriptionStatusPending  SubscriptionStatus = "pending"
+	SubscriptionStatusPastDue  SubscriptionStatus = "past_due"
 )
 
 // BeforeCreate hook to set default values
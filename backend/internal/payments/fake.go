@@ -0,0 +1,152 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payment method IDs with special behaviour in the fake provider, named
+// after Stripe's test cards. Any other ID starting with "pm_" is a card
+// that always succeeds.
+const (
+	FakeCardDeclined = "pm_card_chargeDeclined"
+	FakeCardPending  = "pm_card_pending" // settles when a webhook is posted
+)
+
+// FakeProvider keeps customers and payment methods in memory so the billing
+// flow can be exercised without a payment provider. Webhooks use the same
+// event format and signatures as Stripe; Sign can produce them.
+type FakeProvider struct {
+	mu            sync.Mutex
+	webhookSecret string
+	customers     map[string]*Customer
+	methods       map[string]string // payment method ID -> customer ID
+	charges       map[string]*Charge
+}
+
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{
+		webhookSecret: webhookSecret,
+		customers:     make(map[string]*Customer),
+		methods:       make(map[string]string),
+		charges:       make(map[string]*Charge),
+	}
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeProvider) CreateCustomer(ctx context.Context, email, name string, metadata map[string]string) (*Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	customer := &Customer{ID: fakeID("cus"), Email: email, Name: name}
+	f.customers[customer.ID] = customer
+	copied := *customer
+	return &copied, nil
+}
+
+func (f *FakeProvider) AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) (*PaymentMethod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, fmt.Errorf("%w: customer %s", ErrNotFound, customerID)
+	}
+	if !strings.HasPrefix(paymentMethodID, "pm_") {
+		return nil, fmt.Errorf("%w: payment method %s", ErrNotFound, paymentMethodID)
+	}
+
+	f.methods[paymentMethodID] = customerID
+	return fakePaymentMethod(paymentMethodID), nil
+}
+
+func (f *FakeProvider) ListPaymentMethods(ctx context.Context, customerID string) ([]PaymentMethod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	methods := []PaymentMethod{}
+	for id, owner := range f.methods {
+		if owner == customerID {
+			methods = append(methods, *fakePaymentMethod(id))
+		}
+	}
+	return methods, nil
+}
+
+func (f *FakeProvider) DetachPaymentMethod(ctx context.Context, paymentMethodID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.methods[paymentMethodID]; !ok {
+		return fmt.Errorf("%w: payment method %s", ErrNotFound, paymentMethodID)
+	}
+	delete(f.methods, paymentMethodID)
+	return nil
+}
+
+func (f *FakeProvider) Charge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if charge, ok := f.charges[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		copied := *charge
+		return &copied, nil
+	}
+	if f.methods[req.PaymentMethodID] != req.CustomerID {
+		return nil, fmt.Errorf("%w: payment method %s", ErrNotFound, req.PaymentMethodID)
+	}
+
+	charge := &Charge{
+		ID:       fakeID("pi"),
+		Status:   ChargeSucceeded,
+		Amount:   req.Amount,
+		Currency: strings.ToUpper(req.Currency),
+	}
+	switch req.PaymentMethodID {
+	case FakeCardDeclined:
+		charge.Status = ChargeFailed
+		charge.FailureMessage = "Your card was declined."
+	case FakeCardPending:
+		charge.Status = ChargePending
+	}
+
+	if req.IdempotencyKey != "" {
+		f.charges[req.IdempotencyKey] = charge
+	}
+	copied := *charge
+	return &copied, nil
+}
+
+func (f *FakeProvider) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := verifySignature(payload, header.Get(SignatureHeader), f.webhookSecret, DefaultWebhookTolerance, time.Now()); err != nil {
+		return nil, err
+	}
+	return parseEvent(payload)
+}
+
+// Sign returns a signature header value for a webhook payload
+func (f *FakeProvider) Sign(payload []byte) string {
+	return Sign(payload, f.webhookSecret, time.Now())
+}
+
+func fakePaymentMethod(id string) *PaymentMethod {
+	last4 := "4242"
+	if id == FakeCardDeclined {
+		last4 = "0002"
+	}
+	return &PaymentMethod{ID: id, Brand: "visa", Last4: last4, ExpMonth: 12, ExpYear: time.Now().Year() + 3}
+}
+
+func fakeID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + "_fake_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,107 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature is returned for webhooks whose signature does not
+	// match the payload, or whose timestamp is outside the tolerance
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrNotFound is returned for customers and payment methods the provider
+	// does not know
+	ErrNotFound = errors.New("not found")
+)
+
+// Customer is the provider's record of a tenant
+type Customer struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// PaymentMethod is a card or other instrument saved for a customer. The
+// client collects it with the provider's own UI and only passes its ID.
+type PaymentMethod struct {
+	ID       string `json:"id"`
+	Brand    string `json:"brand"`
+	Last4    string `json:"last4"`
+	ExpMonth int    `json:"exp_month"`
+	ExpYear  int    `json:"exp_year"`
+}
+
+type ChargeStatus string
+
+const (
+	ChargeSucceeded ChargeStatus = "succeeded"
+	ChargePending   ChargeStatus = "pending" // the outcome arrives by webhook
+	ChargeFailed    ChargeStatus = "failed"
+)
+
+// ChargeRequest charges a customer's payment method. Requests with the same
+// IdempotencyKey are only charged once.
+type ChargeRequest struct {
+	CustomerID      string
+	PaymentMethodID string
+	Amount          int64 // in the currency's minor unit
+	Currency        string
+	Description     string
+	IdempotencyKey  string
+	Metadata        map[string]string
+}
+
+// Charge is the outcome of a ChargeRequest
+type Charge struct {
+	ID             string
+	Status         ChargeStatus
+	Amount         int64
+	Currency       string
+	FailureMessage string
+}
+
+type EventType string
+
+const (
+	EventPaymentSucceeded EventType = "payment.succeeded"
+	EventPaymentFailed    EventType = "payment.failed"
+)
+
+// Event is a verified webhook notification. Types other than the ones above
+// are passed through with the provider's own name and can be ignored.
+type Event struct {
+	ID             string
+	Type           EventType
+	ChargeID       string
+	CustomerID     string
+	Amount         int64
+	Currency       string
+	FailureMessage string
+	Metadata       map[string]string
+	Created        time.Time
+}
+
+// Provider collects payments. Implementations must be safe for concurrent
+// use.
+type Provider interface {
+	// Name identifies the provider in stored payment events
+	Name() string
+	CreateCustomer(ctx context.Context, email, name string, metadata map[string]string) (*Customer, error)
+	// AttachPaymentMethod saves a payment method for a customer and makes it
+	// the default
+	AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) (*PaymentMethod, error)
+	ListPaymentMethods(ctx context.Context, customerID string) ([]PaymentMethod, error)
+	DetachPaymentMethod(ctx context.Context, paymentMethodID string) error
+	Charge(ctx context.Context, req ChargeRequest) (*Charge, error)
+	// ParseWebhook verifies the signature of a webhook and decodes its event
+	ParseWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+// MinorUnits converts an amount to the currency's minor unit, e.g. cents
+func MinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
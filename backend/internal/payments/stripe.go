@@ -0,0 +1,266 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStripeURL is the Stripe API; other values point the provider at a
+// Stripe-compatible API
+const DefaultStripeURL = "https://api.stripe.com"
+
+// StripeProvider talks to the Stripe REST API. Charges are off-session
+// payment intents confirmed immediately.
+type StripeProvider struct {
+	baseURL          string
+	secretKey        string
+	webhookSecret    string
+	webhookTolerance time.Duration
+	client           *http.Client
+}
+
+func NewStripeProvider(baseURL, secretKey, webhookSecret string) (*StripeProvider, error) {
+	if secretKey == "" {
+		return nil, fmt.Errorf("stripe secret key is required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultStripeURL
+	}
+
+	return &StripeProvider{
+		baseURL:          strings.TrimRight(baseURL, "/"),
+		secretKey:        secretKey,
+		webhookSecret:    webhookSecret,
+		webhookTolerance: DefaultWebhookTolerance,
+		client:           &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+type stripeCustomer struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type stripePaymentMethod struct {
+	ID   string `json:"id"`
+	Card struct {
+		Brand    string `json:"brand"`
+		Last4    string `json:"last4"`
+		ExpMonth int    `json:"exp_month"`
+		ExpYear  int    `json:"exp_year"`
+	} `json:"card"`
+}
+
+type paymentIntent struct {
+	ID               string            `json:"id"`
+	Status           string            `json:"status"`
+	Amount           int64             `json:"amount"`
+	Currency         string            `json:"currency"`
+	Customer         string            `json:"customer"`
+	Metadata         map[string]string `json:"metadata"`
+	LastPaymentError *struct {
+		Message string `json:"message"`
+	} `json:"last_payment_error"`
+}
+
+// stripeError is the error body of failed requests. Declined cards come back
+// as card errors carrying the payment intent.
+type stripeError struct {
+	Error struct {
+		Type          string         `json:"type"`
+		Code          string         `json:"code"`
+		Message       string         `json:"message"`
+		PaymentIntent *paymentIntent `json:"payment_intent"`
+	} `json:"error"`
+}
+
+func (p *StripeProvider) CreateCustomer(ctx context.Context, email, name string, metadata map[string]string) (*Customer, error) {
+	form := url.Values{"email": {email}, "name": {name}}
+	addMetadata(form, metadata)
+
+	var customer stripeCustomer
+	if err := p.do(ctx, http.MethodPost, "/v1/customers", form, "", &customer); err != nil {
+		return nil, err
+	}
+	return &Customer{ID: customer.ID, Email: customer.Email, Name: customer.Name}, nil
+}
+
+func (p *StripeProvider) AttachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) (*PaymentMethod, error) {
+	var method stripePaymentMethod
+	if err := p.do(ctx, http.MethodPost, "/v1/payment_methods/"+url.PathEscape(paymentMethodID)+"/attach",
+		url.Values{"customer": {customerID}}, "", &method); err != nil {
+		return nil, err
+	}
+
+	if err := p.do(ctx, http.MethodPost, "/v1/customers/"+url.PathEscape(customerID),
+		url.Values{"invoice_settings[default_payment_method]": {method.ID}}, "", nil); err != nil {
+		return nil, err
+	}
+	return method.toPaymentMethod(), nil
+}
+
+func (p *StripeProvider) ListPaymentMethods(ctx context.Context, customerID string) ([]PaymentMethod, error) {
+	var list struct {
+		Data []stripePaymentMethod `json:"data"`
+	}
+	query := url.Values{"customer": {customerID}, "type": {"card"}, "limit": {"100"}}
+	if err := p.do(ctx, http.MethodGet, "/v1/payment_methods?"+query.Encode(), nil, "", &list); err != nil {
+		return nil, err
+	}
+
+	methods := make([]PaymentMethod, 0, len(list.Data))
+	for _, method := range list.Data {
+		methods = append(methods, *method.toPaymentMethod())
+	}
+	return methods, nil
+}
+
+func (p *StripeProvider) DetachPaymentMethod(ctx context.Context, paymentMethodID string) error {
+	return p.do(ctx, http.MethodPost, "/v1/payment_methods/"+url.PathEscape(paymentMethodID)+"/detach", url.Values{}, "", nil)
+}
+
+func (p *StripeProvider) Charge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	form := url.Values{
+		"amount":         {strconv.FormatInt(req.Amount, 10)},
+		"currency":       {strings.ToLower(req.Currency)},
+		"customer":       {req.CustomerID},
+		"payment_method": {req.PaymentMethodID},
+		"description":    {req.Description},
+		"confirm":        {"true"},
+		"off_session":    {"true"},
+	}
+	addMetadata(form, req.Metadata)
+
+	var intent paymentIntent
+	err := p.do(ctx, http.MethodPost, "/v1/payment_intents", form, req.IdempotencyKey, &intent)
+	var declined *declineError
+	if errors.As(err, &declined) {
+		return declined.charge, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return intent.toCharge(), nil
+}
+
+func (p *StripeProvider) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := verifySignature(payload, header.Get(SignatureHeader), p.webhookSecret, p.webhookTolerance, time.Now()); err != nil {
+		return nil, err
+	}
+	return parseEvent(payload)
+}
+
+// declineError carries the failed charge of a declined card
+type declineError struct {
+	charge *Charge
+}
+
+func (e *declineError) Error() string {
+	return "card declined: " + e.charge.FailureMessage
+}
+
+// do sends a form-encoded request and decodes the JSON response into out
+func (p *StripeProvider) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.secretKey, "")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read stripe response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr stripeError
+		if json.Unmarshal(data, &apiErr) != nil {
+			return fmt.Errorf("stripe returned %d", resp.StatusCode)
+		}
+		if apiErr.Error.Type == "card_error" && apiErr.Error.PaymentIntent != nil {
+			charge := apiErr.Error.PaymentIntent.toCharge()
+			charge.Status = ChargeFailed
+			charge.FailureMessage = apiErr.Error.Message
+			return &declineError{charge: charge}
+		}
+		if resp.StatusCode == http.StatusNotFound || apiErr.Error.Code == "resource_missing" {
+			return fmt.Errorf("%w: %s", ErrNotFound, apiErr.Error.Message)
+		}
+		return fmt.Errorf("stripe returned %d: %s", resp.StatusCode, apiErr.Error.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode stripe response: %w", err)
+	}
+	return nil
+}
+
+func (m *stripePaymentMethod) toPaymentMethod() *PaymentMethod {
+	return &PaymentMethod{
+		ID:       m.ID,
+		Brand:    m.Card.Brand,
+		Last4:    m.Card.Last4,
+		ExpMonth: m.Card.ExpMonth,
+		ExpYear:  m.Card.ExpYear,
+	}
+}
+
+func (i *paymentIntent) toCharge() *Charge {
+	charge := &Charge{
+		ID:       i.ID,
+		Amount:   i.Amount,
+		Currency: strings.ToUpper(i.Currency),
+	}
+	switch i.Status {
+	case "succeeded":
+		charge.Status = ChargeSucceeded
+	case "processing":
+		charge.Status = ChargePending
+	default:
+		charge.Status = ChargeFailed
+		charge.FailureMessage = "payment requires action: " + i.Status
+		if i.LastPaymentError != nil {
+			charge.FailureMessage = i.LastPaymentError.Message
+		}
+	}
+	return charge
+}
+
+func addMetadata(form url.Values, metadata map[string]string) {
+	for key, value := range metadata {
+		form.Set("metadata["+key+"]", value)
+	}
+}
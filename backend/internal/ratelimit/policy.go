@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"nomad-services-api/internal/models"
+)
+
+// RouteClass groups routes that share a bucket
+type RouteClass string
+
+const (
+	RouteClassAuth   RouteClass = "auth"
+	RouteClassRead   RouteClass = "read"
+	RouteClassWrite  RouteClass = "write"
+	RouteClassDeploy RouteClass = "deploy"
+)
+
+// Principal is the kind of caller a bucket belongs to
+type Principal string
+
+const (
+	PrincipalAnonymous Principal = "anonymous"
+	PrincipalUser      Principal = "user"
+	PrincipalTenant    Principal = "tenant"
+	PrincipalAPIKey    Principal = "api_key"
+)
+
+// ClassLimits maps each route class to its limit
+type ClassLimits map[RouteClass]Limit
+
+// PlanLimits holds the limits for each authenticated principal on a plan.
+// Tenant limits are shared by all users and API keys of the tenant.
+type PlanLimits struct {
+	User   ClassLimits
+	Tenant ClassLimits
+	APIKey ClassLimits
+}
+
+// AnonymousLimits apply per client IP to unauthenticated requests
+var AnonymousLimits = ClassLimits{
+	RouteClassAuth:   PerMinute(10),
+	RouteClassRead:   PerMinute(60),
+	RouteClassWrite:  PerMinute(10),
+	RouteClassDeploy: PerMinute(5),
+}
+
+// DefaultPlanLimits are the per-minute limits for plans that don't set their
+// own in the catalog
+var DefaultPlanLimits = map[models.TenantPlan]PlanLimits{
+	models.TenantPlanFree: {
+		User:   ClassLimits{RouteClassAuth: PerMinute(20), RouteClassRead: PerMinute(120), RouteClassWrite: PerMinute(30), RouteClassDeploy: PerMinute(10)},
+		Tenant: ClassLimits{RouteClassAuth: PerMinute(60), RouteClassRead: PerMinute(300), RouteClassWrite: PerMinute(60), RouteClassDeploy: PerMinute(20)},
+		APIKey: ClassLimits{RouteClassAuth: PerMinute(20), RouteClassRead: PerMinute(120), RouteClassWrite: PerMinute(30), RouteClassDeploy: PerMinute(10)},
+	},
+	models.TenantPlanStarter: {
+		User:   ClassLimits{RouteClassAuth: PerMinute(30), RouteClassRead: PerMinute(300), RouteClassWrite: PerMinute(60), RouteClassDeploy: PerMinute(30)},
+		Tenant: ClassLimits{RouteClassAuth: PerMinute(120), RouteClassRead: PerMinute(1000), RouteClassWrite: PerMinute(200), RouteClassDeploy: PerMinute(60)},
+		APIKey: ClassLimits{RouteClassAuth: PerMinute(30), RouteClassRead: PerMinute(600), RouteClassWrite: PerMinute(120), RouteClassDeploy: PerMinute(30)},
+	},
+	models.TenantPlanPro: {
+		User:   ClassLimits{RouteClassAuth: PerMinute(60), RouteClassRead: PerMinute(600), RouteClassWrite: PerMinute(120), RouteClassDeploy: PerMinute(60)},
+		Tenant: ClassLimits{RouteClassAuth: PerMinute(300), RouteClassRead: PerMinute(3000), RouteClassWrite: PerMinute(600), RouteClassDeploy: PerMinute(200)},
+		APIKey: ClassLimits{RouteClassAuth: PerMinute(60), RouteClassRead: PerMinute(1200), RouteClassWrite: PerMinute(300), RouteClassDeploy: PerMinute(120)},
+	},
+	models.TenantPlanEnterprise: {
+		User:   ClassLimits{RouteClassAuth: PerMinute(120), RouteClassRead: PerMinute(1200), RouteClassWrite: PerMinute(300), RouteClassDeploy: PerMinute(120)},
+		Tenant: ClassLimits{RouteClassAuth: PerMinute(1000), RouteClassRead: PerMinute(10000), RouteClassWrite: PerMinute(2000), RouteClassDeploy: PerMinute(600)},
+		APIKey: ClassLimits{RouteClassAuth: PerMinute(120), RouteClassRead: PerMinute(3000), RouteClassWrite: PerMinute(600), RouteClassDeploy: PerMinute(300)},
+	},
+}
+
+// LimitsForPlan returns the limits for a tenant on plan id. Limits set on the
+// catalog entry take precedence; classes it leaves at zero, or a plan missing
+// from the catalog, use DefaultPlanLimits, falling back to the free plan.
+func LimitsForPlan(id models.TenantPlan, plan *models.Plan) PlanLimits {
+	defaults, ok := DefaultPlanLimits[id]
+	if !ok {
+		defaults = DefaultPlanLimits[models.TenantPlanFree]
+	}
+	if plan == nil {
+		return defaults
+	}
+
+	return PlanLimits{
+		User:   overrideLimits(defaults.User, plan.UserRateLimits),
+		Tenant: overrideLimits(defaults.Tenant, plan.TenantRateLimits),
+		APIKey: overrideLimits(defaults.APIKey, plan.APIKeyRateLimits),
+	}
+}
+
+// overrideLimits returns a copy of defaults with the classes set in perMinute
+// replaced
+func overrideLimits(defaults ClassLimits, perMinute models.RateLimits) ClassLimits {
+	limits := make(ClassLimits, len(defaults))
+	for class, limit := range defaults {
+		limits[class] = limit
+	}
+	for class, n := range map[RouteClass]int{
+		RouteClassAuth:   perMinute.Auth,
+		RouteClassRead:   perMinute.Read,
+		RouteClassWrite:  perMinute.Write,
+		RouteClassDeploy: perMinute.Deploy,
+	} {
+		if n > 0 {
+			limits[class] = PerMinute(n)
+		}
+	}
+	return limits
+}
@@ -0,0 +1,61 @@
+// Package requestid carries the ID of the API request being handled through
+// context.Context, so the services it calls can log it and pass it on to
+// Nomad.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Header is the HTTP header request IDs are read from and returned in
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+// New returns a new request ID
+func New() string {
+	return uuid.NewString()
+}
+
+// Parse returns the canonical form of a request ID sent by a caller. Only
+// UUIDs, with or without dashes, are accepted, so arbitrary header values
+// don't end up in logs and Nomad jobs.
+func Parse(value string) (string, bool) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	return id.String(), true
+}
+
+// NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID ctx carries, or "" outside a request
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// LogHook adds the request ID of an entry's context to its fields, for
+// entries logged with logrus.WithContext
+type LogHook struct{}
+
+func (LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (LogHook) Fire(entry *logrus.Entry) error {
+	if id := FromContext(entry.Context); id != "" {
+		entry.Data["request_id"] = id
+	}
+	return nil
+}
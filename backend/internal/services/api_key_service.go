@@ -0,0 +1,109 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// apiKeyPrefix makes keys recognizable in logs and secret scanners
+const apiKeyPrefix = "nsk_"
+
+type ApiKeyService struct {
+	db *gorm.DB
+}
+
+func NewApiKeyService(db *gorm.DB) *ApiKeyService {
+	return &ApiKeyService{db: db}
+}
+
+// CreateApiKeyRequest represents an API key creation request
+type CreateApiKeyRequest struct {
+	Name          string `json:"name" binding:"required"`
+	ExpiresInDays int    `json:"expires_in_days"`
+}
+
+// CreateApiKey issues a key for user and returns it with the plaintext key.
+// Only a hash is stored, so the plaintext cannot be retrieved again.
+func (aks *ApiKeyService) CreateApiKey(user *models.User, req *CreateApiKeyRequest) (*models.ApiKey, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(buf)
+
+	apiKey := &models.ApiKey{
+		ID:       uuid.New(),
+		Name:     req.Name,
+		Key:      hashApiKey(plaintext),
+		Prefix:   plaintext[:len(apiKeyPrefix)+8],
+		UserID:   user.ID,
+		TenantID: user.TenantID,
+		IsActive: true,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := aks.db.Create(apiKey).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return apiKey, plaintext, nil
+}
+
+// ListApiKeys returns the keys owned by a user
+func (aks *ApiKeyService) ListApiKeys(userID uuid.UUID) ([]models.ApiKey, error) {
+	var keys []models.ApiKey
+	if err := aks.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeApiKey deactivates one of a user's keys
+func (aks *ApiKeyService) RevokeApiKey(userID, keyID uuid.UUID) error {
+	result := aks.db.Model(&models.ApiKey{}).
+		Where("id = ? AND user_id = ?", keyID, userID).
+		Update("is_active", false)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke API key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("API key not found")
+	}
+	return nil
+}
+
+// Authenticate resolves a plaintext key to an active, unexpired key and its owner
+func (aks *ApiKeyService) Authenticate(plaintext string) (*models.ApiKey, error) {
+	var apiKey models.ApiKey
+	if err := aks.db.Preload("User.Tenant").
+		Where("key = ? AND is_active = ?", hashApiKey(plaintext), true).
+		First(&apiKey).Error; err != nil {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, fmt.Errorf("API key has expired")
+	}
+
+	now := time.Now()
+	aks.db.Model(&models.ApiKey{}).Where("id = ?", apiKey.ID).UpdateColumn("last_used", now)
+	apiKey.LastUsed = &now
+
+	return &apiKey, nil
+}
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
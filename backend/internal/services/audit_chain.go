@@ -0,0 +1,433 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Audit entries are hash chained per tenant: each entry's hash covers its
+// content and the hash of the entry before it, so editing or deleting an
+// entry breaks every later link. Signed checkpoints of each chain's head
+// catch a chain that was rewritten wholesale, as long as copies of them are
+// kept elsewhere.
+
+var ErrAuditCheckpointsDisabled = errors.New("audit checkpoints are disabled: AUDIT_SIGNING_KEY is not set")
+
+// auditCheckpointVersion prefixes signed checkpoint messages
+const auditCheckpointVersion = "audit-checkpoint/v1"
+
+// auditHashInput is the content an entry's hash covers, in a fixed order
+type auditHashInput struct {
+	Sequence   int64      `json:"sequence"`
+	PrevHash   string     `json:"prev_hash"`
+	ID         uuid.UUID  `json:"id"`
+	TenantID   *uuid.UUID `json:"tenant_id"`
+	UserID     *uuid.UUID `json:"user_id"`
+	Action     string     `json:"action"`
+	Resource   string     `json:"resource"`
+	ResourceID string     `json:"resource_id"`
+	Details    string     `json:"details"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  string     `json:"created_at"`
+}
+
+// AuditEntryHash returns the hex SHA-256 of an entry's content and its link
+// to the previous entry of its chain
+func AuditEntryHash(entry *models.AuditLog) string {
+	data, _ := json.Marshal(auditHashInput{
+		Sequence:   entry.Sequence,
+		PrevHash:   entry.PrevHash,
+		ID:         entry.ID,
+		TenantID:   entry.TenantID,
+		UserID:     entry.UserID,
+		Action:     entry.Action,
+		Resource:   entry.Resource,
+		ResourceID: entry.ResourceID,
+		Details:    entry.Details,
+		IPAddress:  entry.IPAddress,
+		UserAgent:  entry.UserAgent,
+		CreatedAt:  entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditCheckpointMessage is the text a checkpoint's signature covers
+func AuditCheckpointMessage(checkpoint *models.AuditCheckpoint) string {
+	chain := "system"
+	if checkpoint.TenantID != nil {
+		chain = checkpoint.TenantID.String()
+	}
+	return fmt.Sprintf("%s\n%s\n%d\n%s\n%s", auditCheckpointVersion, chain,
+		checkpoint.Sequence, checkpoint.Hash, checkpoint.CreatedAt.UTC().Format(time.RFC3339Nano))
+}
+
+// chainKey identifies the chain of a tenant's entries in AuditChainHead
+func chainKey(tenantID *uuid.UUID) uuid.UUID {
+	if tenantID == nil {
+		return uuid.Nil
+	}
+	return *tenantID
+}
+
+func chainTenant(key uuid.UUID) *uuid.UUID {
+	if key == uuid.Nil {
+		return nil
+	}
+	return &key
+}
+
+// inChain narrows a query to one chain
+func inChain(db *gorm.DB, tenantID *uuid.UUID) *gorm.DB {
+	if tenantID == nil {
+		return db.Where("tenant_id IS NULL")
+	}
+	return db.Where("tenant_id = ?", *tenantID)
+}
+
+// append links entries to the heads of their chains and stores them in one
+// transaction
+func (as *AuditService) append(entries []*models.AuditLog) error {
+	return as.db.Transaction(func(tx *gorm.DB) error {
+		chains := map[uuid.UUID][]*models.AuditLog{}
+		var keys []uuid.UUID
+		for _, entry := range entries {
+			key := chainKey(entry.TenantID)
+			if _, ok := chains[key]; !ok {
+				keys = append(keys, key)
+			}
+			chains[key] = append(chains[key], entry)
+		}
+		// Lock heads in a fixed order so concurrent writers can't deadlock
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+		for _, key := range keys {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.AuditChainHead{TenantID: key}).Error; err != nil {
+				return fmt.Errorf("failed to create audit chain: %w", err)
+			}
+			var head models.AuditChainHead
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				First(&head, "tenant_id = ?", key).Error; err != nil {
+				return fmt.Errorf("failed to lock audit chain: %w", err)
+			}
+
+			for _, entry := range chains[key] {
+				head.Sequence++
+				entry.Sequence = head.Sequence
+				entry.PrevHash = head.Hash
+				entry.Hash = AuditEntryHash(entry)
+				head.Hash = entry.Hash
+			}
+
+			if err := tx.Model(&models.AuditChainHead{}).Where("tenant_id = ?", key).Updates(map[string]interface{}{
+				"sequence":   head.Sequence,
+				"hash":       head.Hash,
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return fmt.Errorf("failed to advance audit chain: %w", err)
+			}
+		}
+
+		if err := tx.CreateInBatches(entries, auditBatchSize).Error; err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+		return nil
+	})
+}
+
+// AuditChainBreak is the first point at which a chain fails verification
+type AuditChainBreak struct {
+	Sequence int64      `json:"sequence"`
+	EntryID  *uuid.UUID `json:"entry_id,omitempty"` // nil when the entry is missing
+	Reason   string     `json:"reason"`
+}
+
+// AuditVerification is the result of walking one audit chain
+type AuditVerification struct {
+	TenantID     *uuid.UUID `json:"tenant_id"` // nil for entries without a tenant
+	Valid        bool       `json:"valid"`
+	Entries      int64      `json:"entries"` // chained entries checked
+	HeadSequence int64      `json:"head_sequence"`
+	HeadHash     string     `json:"head_hash,omitempty"`
+	// Checkpoints signed with a key other than the current one are compared
+	// by hash only
+	Checkpoints          int              `json:"checkpoints"`
+	UnverifiedSignatures int              `json:"unverified_signatures"`
+	FirstBreak           *AuditChainBreak `json:"first_break,omitempty"`
+	VerifiedAt           time.Time        `json:"verified_at"`
+}
+
+func (v *AuditVerification) fail(sequence int64, entryID *uuid.UUID, reason string) *AuditVerification {
+	v.Valid = false
+	v.FirstBreak = &AuditChainBreak{Sequence: sequence, EntryID: entryID, Reason: reason}
+	return v
+}
+
+// VerifyChain walks a tenant's audit chain, or the chain of entries without a
+// tenant when tenantID is nil, and reports the first entry that was edited,
+// removed or reordered. Entries appended while it runs are not checked.
+func (as *AuditService) VerifyChain(tenantID *uuid.UUID) (*AuditVerification, error) {
+	result := &AuditVerification{TenantID: tenantID, Valid: true, VerifiedAt: time.Now()}
+
+	var head models.AuditChainHead
+	if err := as.db.First(&head, "tenant_id = ?", chainKey(tenantID)).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		// A chain without a head must not have any entries either
+		var first models.AuditLog
+		err := inChain(as.db, tenantID).Where("sequence > 0").Order("sequence").Take(&first).Error
+		if err == nil {
+			return result.fail(first.Sequence, &first.ID, "chain head is missing"), nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to read audit chain: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load audit chain: %w", err)
+	}
+	result.HeadSequence, result.HeadHash = head.Sequence, head.Hash
+
+	var checkpoints []models.AuditCheckpoint
+	if err := inChain(as.db, tenantID).Order("sequence").Find(&checkpoints).Error; err != nil {
+		return nil, fmt.Errorf("failed to load audit checkpoints: %w", err)
+	}
+	bySequence := map[int64][]models.AuditCheckpoint{}
+	for _, checkpoint := range checkpoints {
+		signed, err := as.verifyCheckpoint(&checkpoint)
+		if err != nil {
+			return result.fail(checkpoint.Sequence, nil, fmt.Sprintf("checkpoint %s: %v", checkpoint.ID, err)), nil
+		}
+		if !signed {
+			result.UnverifiedSignatures++
+		}
+		bySequence[checkpoint.Sequence] = append(bySequence[checkpoint.Sequence], checkpoint)
+	}
+	result.Checkpoints = len(checkpoints)
+
+	rows, err := inChain(as.db, tenantID).Model(&models.AuditLog{}).
+		Where("sequence > 0 AND sequence <= ?", head.Sequence).Order("sequence").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit chain: %w", err)
+	}
+	defer rows.Close()
+
+	expected, prevHash := int64(1), ""
+	for rows.Next() {
+		var entry models.AuditLog
+		if err := as.db.ScanRows(rows, &entry); err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		id := entry.ID
+
+		switch {
+		case entry.Sequence > expected:
+			return result.fail(expected, nil, "entry is missing"), nil
+		case entry.Sequence < expected:
+			return result.fail(entry.Sequence, &id, "sequence number is repeated"), nil
+		case entry.PrevHash != prevHash:
+			return result.fail(entry.Sequence, &id, "previous hash does not match the preceding entry"), nil
+		case AuditEntryHash(&entry) != entry.Hash:
+			return result.fail(entry.Sequence, &id, "content does not match its hash"), nil
+		}
+		for _, checkpoint := range bySequence[entry.Sequence] {
+			if checkpoint.Hash != entry.Hash {
+				return result.fail(entry.Sequence, &id, fmt.Sprintf("hash differs from checkpoint %s", checkpoint.ID)), nil
+			}
+		}
+
+		prevHash = entry.Hash
+		expected++
+		result.Entries++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit chain: %w", err)
+	}
+
+	if last := expected - 1; last < head.Sequence {
+		return result.fail(last+1, nil, "entry is missing"), nil
+	} else if last > 0 && prevHash != head.Hash {
+		return result.fail(last, nil, "chain head does not match the last entry"), nil
+	}
+	// Checkpoints beyond the head mean the chain was cut short and its head
+	// rewound
+	if n := len(checkpoints); n > 0 && checkpoints[n-1].Sequence > head.Sequence {
+		return result.fail(head.Sequence+1, nil, fmt.Sprintf("entries up to checkpoint %s are missing", checkpoints[n-1].ID)), nil
+	}
+	return result, nil
+}
+
+// verifyCheckpoint checks a checkpoint's signature, reporting false when it
+// was signed with a key other than the current one and can't be checked
+func (as *AuditService) verifyCheckpoint(checkpoint *models.AuditCheckpoint) (bool, error) {
+	if as.signer == nil || checkpoint.KeyID != as.keyID {
+		return false, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil || !ed25519.Verify(as.signer.Public().(ed25519.PublicKey), []byte(AuditCheckpointMessage(checkpoint)), signature) {
+		return false, errors.New("signature is invalid")
+	}
+	return true, nil
+}
+
+// CreateCheckpoints signs the head of every chain that has grown since its
+// last checkpoint, and appends the new checkpoints to AUDIT_CHECKPOINT_FILE
+func (as *AuditService) CreateCheckpoints() ([]models.AuditCheckpoint, error) {
+	if as.signer == nil {
+		return nil, ErrAuditCheckpointsDisabled
+	}
+
+	var heads []models.AuditChainHead
+	if err := as.db.Where("sequence > 0").Find(&heads).Error; err != nil {
+		return nil, fmt.Errorf("failed to load audit chains: %w", err)
+	}
+
+	var latest []struct {
+		TenantID *uuid.UUID
+		Sequence int64
+	}
+	if err := as.db.Model(&models.AuditCheckpoint{}).
+		Select("tenant_id, MAX(sequence) AS sequence").Group("tenant_id").Scan(&latest).Error; err != nil {
+		return nil, fmt.Errorf("failed to load audit checkpoints: %w", err)
+	}
+	checkpointed := map[uuid.UUID]int64{}
+	for _, checkpoint := range latest {
+		checkpointed[chainKey(checkpoint.TenantID)] = checkpoint.Sequence
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	created := []models.AuditCheckpoint{}
+	for _, head := range heads {
+		if head.Sequence <= checkpointed[head.TenantID] {
+			continue
+		}
+		checkpoint := models.AuditCheckpoint{
+			ID:        uuid.New(),
+			TenantID:  chainTenant(head.TenantID),
+			Sequence:  head.Sequence,
+			Hash:      head.Hash,
+			KeyID:     as.keyID,
+			CreatedAt: now,
+		}
+		signature := ed25519.Sign(as.signer, []byte(AuditCheckpointMessage(&checkpoint)))
+		checkpoint.Signature = base64.StdEncoding.EncodeToString(signature)
+		created = append(created, checkpoint)
+	}
+	if len(created) == 0 {
+		return created, nil
+	}
+
+	if err := as.db.Create(&created).Error; err != nil {
+		return nil, fmt.Errorf("failed to save audit checkpoints: %w", err)
+	}
+	as.appendCheckpointFile(created)
+	return created, nil
+}
+
+// ListCheckpoints returns checkpoints created since a time, oldest first.
+// A nil tenant lists every chain's checkpoints.
+func (as *AuditService) ListCheckpoints(tenantID *uuid.UUID, since time.Time) ([]models.AuditCheckpoint, error) {
+	db := as.db
+	if tenantID != nil {
+		db = db.Where("tenant_id = ?", *tenantID)
+	}
+	if !since.IsZero() {
+		db = db.Where("created_at >= ?", since)
+	}
+
+	checkpoints := []models.AuditCheckpoint{}
+	if err := db.Order("created_at, sequence").Limit(MaxAuditExport).Find(&checkpoints).Error; err != nil {
+		return nil, fmt.Errorf("failed to list audit checkpoints: %w", err)
+	}
+	return checkpoints, nil
+}
+
+// ExportedCheckpoint is a checkpoint with what is needed to verify it without
+// this service: the signed message and, when signed with the current key, the
+// public key
+type ExportedCheckpoint struct {
+	models.AuditCheckpoint
+	Message   string `json:"message"`
+	PublicKey string `json:"public_key,omitempty"` // base64 Ed25519
+}
+
+func (as *AuditService) ExportCheckpoint(checkpoint models.AuditCheckpoint) ExportedCheckpoint {
+	exported := ExportedCheckpoint{
+		AuditCheckpoint: checkpoint,
+		Message:         AuditCheckpointMessage(&checkpoint),
+	}
+	if as.signer != nil && checkpoint.KeyID == as.keyID {
+		exported.PublicKey = as.PublicKey()
+	}
+	return exported
+}
+
+// PublicKey returns the base64 key checkpoints are verified with, or "" when
+// checkpoints are disabled
+func (as *AuditService) PublicKey() string {
+	if as.signer == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(as.signer.Public().(ed25519.PublicKey))
+}
+
+// appendCheckpointFile writes checkpoints to AUDIT_CHECKPOINT_FILE as JSON lines
+func (as *AuditService) appendCheckpointFile(checkpoints []models.AuditCheckpoint) {
+	if as.config.Audit.CheckpointFile == "" {
+		return
+	}
+
+	file, err := os.OpenFile(as.config.Audit.CheckpointFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to open audit checkpoint file")
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, checkpoint := range checkpoints {
+		if err := encoder.Encode(as.ExportCheckpoint(checkpoint)); err != nil {
+			logrus.WithError(err).Error("Failed to write audit checkpoint file")
+			return
+		}
+	}
+}
+
+func (as *AuditService) runCheckpoints() {
+	ticker := time.NewTicker(as.config.Audit.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			checkpoints, err := as.CreateCheckpoints()
+			if err != nil {
+				logrus.WithError(err).Error("Failed to create audit checkpoints")
+			} else if len(checkpoints) > 0 {
+				logrus.WithField("checkpoints", len(checkpoints)).Info("Signed audit checkpoints")
+			}
+		case <-as.stop:
+			return
+		}
+	}
+}
+
+// auditKeyID names a signing key by the start of its public key's hash
+func auditKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,353 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Audit actions recorded by the services
+const (
+	AuditActionLogin           = "auth.login"
+	AuditActionLoginFailed     = "auth.login_failed"
+	AuditActionLoginThrottled  = "auth.login_throttled"
+	AuditActionAccountLocked   = "auth.account_locked"
+	AuditActionAccountUnlocked = "auth.account_unlocked"
+	AuditActionTenantSwitched  = "auth.tenant_switched"
+
+	AuditActionSubscriptionRenewed         = "subscription.renewed"
+	AuditActionSubscriptionPastDue         = "subscription.past_due"
+	AuditActionSubscriptionExpired         = "subscription.expired"
+	AuditActionSubscriptionCanceled        = "subscription.canceled"
+	AuditActionSubscriptionCancelScheduled = "subscription.cancel_scheduled"
+	AuditActionSubscriptionResumed         = "subscription.resumed"
+	AuditActionSubscriptionPaid            = "subscription.paid"
+	AuditActionTenantSuspended             = "tenant.suspended"
+	AuditActionTenantReactivated           = "tenant.reactivated"
+
+	AuditActionInvoiceIssued   = "invoice.issued"
+	AuditActionInvoiceAdjusted = "invoice.adjusted"
+	AuditActionInvoicePaid     = "invoice.paid"
+	AuditActionInvoiceVoided   = "invoice.voided"
+
+	AuditActionPaymentSucceeded     = "payment.succeeded"
+	AuditActionPaymentFailed        = "payment.failed"
+	AuditActionPaymentMethodAdded   = "payment_method.added"
+	AuditActionPaymentMethodRemoved = "payment_method.removed"
+
+	AuditActionBudgetUpdated = "budget.updated"
+	AuditActionBudgetDeleted = "budget.deleted"
+	AuditActionBudgetAlert   = "budget.threshold_reached"
+	AuditActionBudgetCapped  = "budget.capped"
+)
+
+// ClientInfo identifies the client behind a request, for throttling and auditing
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+// The audit writer batches entries from a buffered queue. When the queue is
+// full, or the writer is not running, entries are written synchronously
+// rather than dropped.
+const (
+	auditQueueSize = 1024
+	auditBatchSize = 100
+)
+
+// Bounds on audit queries and exports
+const (
+	DefaultAuditLimit = 50
+	MaxAuditLimit     = 500
+	MaxAuditExport    = 100000
+)
+
+// AuditQuery filters audit entries. Action matches exactly, or by prefix when
+// it ends in "." (e.g. "service."). Zero times leave the range open.
+type AuditQuery struct {
+	TenantID   *uuid.UUID // nil for every tenant
+	UserID     *uuid.UUID
+	Action     string
+	Resource   string
+	ResourceID string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+type AuditService struct {
+	db      *gorm.DB
+	config  *config.Config
+	signer  ed25519.PrivateKey // nil when checkpoints are disabled
+	keyID   string
+	mu      sync.RWMutex
+	queue   chan *models.AuditLog
+	running bool
+	done    chan struct{}
+	stop    chan struct{}
+}
+
+func NewAuditService(db *gorm.DB, cfg *config.Config) (*AuditService, error) {
+	as := &AuditService{
+		db:     db,
+		config: cfg,
+		queue:  make(chan *models.AuditLog, auditQueueSize),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+
+	if cfg.Audit.SigningKey != "" {
+		seed, err := base64.StdEncoding.DecodeString(cfg.Audit.SigningKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("audit signing key must be a base64 %d-byte Ed25519 seed", ed25519.SeedSize)
+		}
+		as.signer = ed25519.NewKeyFromSeed(seed)
+		as.keyID = auditKeyID(as.signer.Public().(ed25519.PublicKey))
+	}
+	return as, nil
+}
+
+// Start writes queued entries in the background until Stop is called, and
+// signs checkpoints every AUDIT_CHECKPOINT_INTERVAL when a signing key is set
+func (as *AuditService) Start() {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	as.running = true
+	go as.run()
+
+	if as.signer == nil {
+		logrus.Warn("AUDIT_SIGNING_KEY is not set; audit checkpoints are disabled")
+		return
+	}
+	if as.config.Audit.CheckpointInterval > 0 {
+		go as.runCheckpoints()
+	}
+}
+
+// Stop writes the entries still queued and stops the writer. Later entries
+// are written synchronously.
+func (as *AuditService) Stop() {
+	as.mu.Lock()
+	if !as.running {
+		as.mu.Unlock()
+		return
+	}
+	as.running = false
+	close(as.queue)
+	close(as.stop)
+	as.mu.Unlock()
+
+	<-as.done
+}
+
+func (as *AuditService) run() {
+	defer close(as.done)
+
+	for entry := range as.queue {
+		batch := []*models.AuditLog{entry}
+		// Take whatever else is already waiting
+	drain:
+		for len(batch) < auditBatchSize {
+			select {
+			case next, ok := <-as.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		as.write(batch)
+	}
+}
+
+// Record stores an audit entry. Failures are logged rather than returned so
+// auditing never breaks the operation being audited.
+func (as *AuditService) Record(userID, tenantID *uuid.UUID, action, resource string, details map[string]interface{}, client ClientInfo) {
+	entry := &models.AuditLog{
+		UserID:    userID,
+		TenantID:  tenantID,
+		Action:    action,
+		Resource:  resource,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+	}
+
+	if len(details) > 0 {
+		data, err := json.Marshal(details)
+		if err != nil {
+			logrus.WithError(err).WithField("action", action).Error("Failed to encode audit details")
+		} else {
+			entry.Details = string(data)
+		}
+	}
+
+	as.RecordEntry(entry)
+}
+
+// RecordEntry queues a prepared audit entry. The ID and creation time are
+// set here so entries keep the time of the audited operation. The time is
+// kept to the microsecond the database stores, so hashes can be recomputed.
+func (as *AuditService) RecordEntry(entry *models.AuditLog) {
+	if entry.ID == uuid.Nil {
+		entry.ID = uuid.New()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	as.mu.RLock()
+	if as.running {
+		select {
+		case as.queue <- entry:
+			as.mu.RUnlock()
+			return
+		default:
+		}
+	}
+	as.mu.RUnlock()
+
+	as.write([]*models.AuditLog{entry})
+}
+
+// write appends entries to their chains and stores them. When a batch fails,
+// its entries are retried one by one so one bad entry can't lose the rest.
+func (as *AuditService) write(entries []*models.AuditLog) {
+	err := as.append(entries)
+	if err == nil {
+		return
+	}
+	if len(entries) == 1 {
+		logrus.WithError(err).WithField("action", entries[0].Action).Error("Failed to write audit log")
+		return
+	}
+	for _, entry := range entries {
+		as.write([]*models.AuditLog{entry})
+	}
+}
+
+// Query returns a page of matching entries, newest first, and the number of
+// matching entries
+func (as *AuditService) Query(query AuditQuery) ([]models.AuditLog, int64, error) {
+	var total int64
+	if err := as.filter(query).Model(&models.AuditLog{}).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit logs: %w", err)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultAuditLimit
+	}
+	if limit > MaxAuditLimit {
+		limit = MaxAuditLimit
+	}
+
+	entries := []models.AuditLog{}
+	if err := as.filter(query).Order("created_at DESC, id DESC").
+		Limit(limit).Offset(query.Offset).Find(&entries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to query audit logs: %w", err)
+	}
+	return entries, total, nil
+}
+
+// Export streams up to MaxAuditExport matching entries, oldest first, to fn
+// without loading them all at once
+func (as *AuditService) Export(query AuditQuery, fn func(*models.AuditLog) error) error {
+	rows, err := as.filter(query).Model(&models.AuditLog{}).
+		Order("created_at, id").Limit(MaxAuditExport).Rows()
+	if err != nil {
+		return fmt.Errorf("failed to export audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.AuditLog
+		if err := as.db.ScanRows(rows, &entry); err != nil {
+			return fmt.Errorf("failed to read audit log: %w", err)
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (as *AuditService) filter(query AuditQuery) *gorm.DB {
+	db := as.db
+	if query.TenantID != nil {
+		db = db.Where("tenant_id = ?", *query.TenantID)
+	}
+	if query.UserID != nil {
+		db = db.Where("user_id = ?", *query.UserID)
+	}
+	if strings.HasSuffix(query.Action, ".") {
+		db = db.Where("action LIKE ?", strings.NewReplacer("%", "\\%", "_", "\\_").Replace(query.Action)+"%")
+	} else if query.Action != "" {
+		db = db.Where("action = ?", query.Action)
+	}
+	if query.Resource != "" {
+		db = db.Where("resource = ?", query.Resource)
+	}
+	if query.ResourceID != "" {
+		db = db.Where("resource_id = ?", query.ResourceID)
+	}
+	if !query.From.IsZero() {
+		db = db.Where("created_at >= ?", query.From)
+	}
+	if !query.To.IsZero() {
+		db = db.Where("created_at < ?", query.To)
+	}
+	return db
+}
+
+// AuditDiff returns the top-level fields that differ between two versions of
+// a resource, as {"field": {"from": old, "to": new}}, comparing their JSON
+// encodings. Fields hidden from JSON never appear.
+func AuditDiff(before, after interface{}) map[string]interface{} {
+	from, to := auditFields(before), auditFields(after)
+
+	changes := map[string]interface{}{}
+	for key, value := range to {
+		if old, ok := from[key]; !ok || string(old) != string(value) {
+			changes[key] = map[string]json.RawMessage{"from": orNull(old), "to": value}
+		}
+	}
+	for key, old := range from {
+		if _, ok := to[key]; !ok {
+			changes[key] = map[string]json.RawMessage{"from": old, "to": orNull(nil)}
+		}
+	}
+	// Bookkeeping fields change on every update
+	delete(changes, "updated_at")
+	return changes
+}
+
+func auditFields(value interface{}) map[string]json.RawMessage {
+	fields := map[string]json.RawMessage{}
+	if data, err := json.Marshal(value); err == nil {
+		json.Unmarshal(data, &fields)
+	}
+	return fields
+}
+
+func orNull(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
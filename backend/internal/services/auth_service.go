@@ -1,7 +1,9 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"nomad-services-api/internal/config"
@@ -9,27 +11,53 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthService struct {
-	config      *config.Config
-	userService *UserService
+	config         *config.Config
+	userService    *UserService
+	mfaService     *MFAService
+	mailService    *MailService
+	auditService   *AuditService
+	tenantService  *TenantService
+	passwordPolicy *PasswordPolicy
+	throttle       *loginThrottle
 }
 
-func NewAuthService(cfg *config.Config, userService *UserService) *AuthService {
+func NewAuthService(
+	cfg *config.Config,
+	userService *UserService,
+	mfaService *MFAService,
+	mailService *MailService,
+	auditService *AuditService,
+	tenantService *TenantService,
+	passwordPolicy *PasswordPolicy,
+) *AuthService {
 	return &AuthService{
-		config:      cfg,
-		userService: userService,
+		config:         cfg,
+		userService:    userService,
+		mfaService:     mfaService,
+		mailService:    mailService,
+		auditService:   auditService,
+		tenantService:  tenantService,
+		passwordPolicy: passwordPolicy,
+		throttle:       newLoginThrottle(cfg.Auth.LoginBackoffBase, cfg.Auth.LoginBackoffMax),
 	}
 }
 
+// tokenPurposeMFA marks a short-lived token that can only be exchanged for
+// real tokens by completing the MFA challenge
+const tokenPurposeMFA = "mfa_challenge"
+
 // Claims represents JWT claims
 type Claims struct {
 	UserID   uuid.UUID `json:"user_id"`
 	Username string    `json:"username"`
 	Role     string    `json:"role"`
 	TenantID *uuid.UUID `json:"tenant_id,omitempty"`
+	Purpose  string     `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -39,62 +67,326 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-// LoginResponse represents login response
+// LoginResponse represents login response. When MFARequired is set, only
+// MFAToken is populated and must be exchanged through VerifyMFA.
 type LoginResponse struct {
-	Token        string      `json:"token"`
-	RefreshToken string      `json:"refresh_token"`
-	User         models.User `json:"user"`
-	ExpiresAt    time.Time   `json:"expires_at"`
+	Token                 string       `json:"token,omitempty"`
+	RefreshToken          string       `json:"refresh_token,omitempty"`
+	User                  *models.User `json:"user,omitempty"`
+	ExpiresAt             time.Time    `json:"expires_at"`
+	MFARequired           bool         `json:"mfa_required,omitempty"`
+	MFAToken              string       `json:"mfa_token,omitempty"`
+	MFAEnrollmentRequired bool         `json:"mfa_enrollment_required,omitempty"`
+}
+
+// MFAVerifyRequest represents the second step of an MFA login
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
 }
 
 // RegisterRequest represents user registration request
 type RegisterRequest struct {
 	Username  string `json:"username" binding:"required"`
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=8"`
+	Password  string `json:"password" binding:"required"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 }
 
+// SignupRequest represents an organization signup. The slug is generated from
+// the organization name when omitted.
+type SignupRequest struct {
+	OrganizationName string `json:"organization_name" binding:"required"`
+	Slug             string `json:"slug"`
+	RegisterRequest
+}
+
+// SignupResponse is returned after an organization signup
+type SignupResponse struct {
+	Tenant *models.Tenant `json:"tenant"`
+	User   *models.User   `json:"user"`
+}
+
+// ChangePasswordRequest represents a password change by a logged-in user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ResetPasswordRequest represents the second step of the forgot-password flow
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
 // Login authenticates user and returns JWT token
-func (as *AuthService) Login(req *LoginRequest) (*LoginResponse, error) {
+func (as *AuthService) Login(req *LoginRequest, client ClientInfo) (*LoginResponse, error) {
+	// Reject attempts that are still backing off before touching the database
+	if err := as.checkThrottle(req.Username, client); err != nil {
+		return nil, err
+	}
+
 	// Get user by username
 	user, err := as.userService.GetUserByUsername(req.Username)
 	if err != nil {
+		as.recordLoginFailure(nil, req.Username, client, "unknown_user")
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	// Check if user is active
-	if !user.IsActive {
-		return nil, fmt.Errorf("account is inactive")
-	}
-
-	// Verify password
+	// Verify password before telling whether the account is locked or
+	// inactive, so the state of an account is only revealed to its owner
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		as.recordLoginFailure(user, req.Username, client, "invalid_password")
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	// Generate tokens
-	token, expiresAt, err := as.generateToken(user)
+	if err := checkAccountLock(user); err != nil {
+		return nil, err
+	}
+
+	if err := as.applyLoginTenant(user); err != nil {
+		return nil, err
+	}
+
+	// Check if user and tenant are active
+	if err := CheckAccountActive(user); err != nil {
+		return nil, err
+	}
+
+	if as.config.Auth.RequireEmailVerification && !user.EmailVerified {
+		return nil, fmt.Errorf("email address is not verified")
+	}
+
+	// Users with MFA get a challenge token instead of real tokens
+	if user.MFAEnabled {
+		mfaToken, expiresAt, err := as.generateMFAToken(user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate MFA token: %w", err)
+		}
+
+		return &LoginResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresAt:   expiresAt,
+		}, nil
+	}
+
+	as.recordLoginSuccess(user, client)
+	return as.issueTokens(user)
+}
+
+// VerifyMFA completes a login by checking the MFA code against the challenge token
+func (as *AuthService) VerifyMFA(req *MFAVerifyRequest, client ClientInfo) (*LoginResponse, error) {
+	claims, err := as.parseToken(req.MFAToken)
+	if err != nil || claims.Purpose != tokenPurposeMFA {
+		return nil, fmt.Errorf("invalid or expired MFA token")
+	}
+
+	if err := as.checkThrottle(claims.Username, client); err != nil {
+		return nil, err
+	}
+
+	user, err := as.userService.GetUserByID(claims.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, fmt.Errorf("invalid or expired MFA token")
 	}
 
-	refreshToken, _, err := as.generateRefreshToken(user)
+	if err := checkAccountLock(user); err != nil {
+		return nil, err
+	}
+
+	if err := as.applyLoginTenant(user); err != nil {
+		return nil, err
+	}
+
+	if err := CheckAccountActive(user); err != nil {
+		return nil, err
+	}
+
+	// Wrong MFA codes count as failed logins so the code can't be brute-forced
+	if err := as.mfaService.Verify(user, req.Code); err != nil {
+		as.recordLoginFailure(user, user.Username, client, "invalid_mfa_code")
+		return nil, err
+	}
+
+	as.recordLoginSuccess(user, client)
+	return as.issueTokens(user)
+}
+
+// UnlockAccount clears a user's lockout and failed login history
+func (as *AuthService) UnlockAccount(userID uuid.UUID, actor *models.User, client ClientInfo) error {
+	user, err := as.userService.GetUserByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+		return err
 	}
 
-	return &LoginResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
-		User:         *user,
-		ExpiresAt:    expiresAt,
-	}, nil
+	if err := as.userService.ResetFailedLogins(user.ID); err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+	as.throttle.Reset(usernameThrottleKey(user.Username))
+
+	as.auditService.Record(&actor.ID, user.TenantID, AuditActionAccountUnlocked, "user:"+user.ID.String(),
+		map[string]interface{}{"username": user.Username}, client)
+	return nil
+}
+
+// checkThrottle rejects attempts while the username or client IP is backing off
+func (as *AuthService) checkThrottle(username string, client ClientInfo) error {
+	now := time.Now()
+	wait := as.throttle.Wait(usernameThrottleKey(username), now)
+	if ipWait := as.throttle.Wait(ipThrottleKey(client.IPAddress), now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	as.auditService.Record(nil, nil, AuditActionLoginThrottled, "user:"+username,
+		map[string]interface{}{"username": username, "retry_after_seconds": wait.Seconds()}, client)
+	return &LoginThrottledError{Message: "too many failed login attempts, try again later", RetryAfter: wait}
+}
+
+// CheckAccountActive rejects inactive users and users of inactive tenants
+func CheckAccountActive(user *models.User) error {
+	if !user.IsActive {
+		return fmt.Errorf("account is inactive")
+	}
+	if user.Tenant != nil && !user.Tenant.IsActive {
+		return fmt.Errorf("tenant is inactive")
+	}
+	return nil
+}
+
+// checkAccountLock rejects logins for accounts locked after repeated failures
+func checkAccountLock(user *models.User) error {
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return &LoginThrottledError{
+			Message:    "account is temporarily locked",
+			RetryAfter: time.Until(*user.LockedUntil),
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure updates the throttles and the persistent failure counter
+// and writes the audit trail. user is nil when the username does not exist.
+func (as *AuthService) recordLoginFailure(user *models.User, username string, client ClientInfo, reason string) {
+	now := time.Now()
+	as.throttle.Failure(usernameThrottleKey(username), now)
+	as.throttle.Failure(ipThrottleKey(client.IPAddress), now)
+
+	details := map[string]interface{}{"username": username, "reason": reason}
+	if user == nil {
+		as.auditService.Record(nil, nil, AuditActionLoginFailed, "user:"+username, details, client)
+		return
+	}
+
+	attempts, lockedUntil, err := as.userService.RecordFailedLogin(user.ID, as.config.Auth.MaxFailedLogins, as.config.Auth.LockoutDuration)
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Error("Failed to record failed login")
+	}
+	details["failed_attempts"] = attempts
+
+	resource := "user:" + user.ID.String()
+	as.auditService.Record(&user.ID, user.TenantID, AuditActionLoginFailed, resource, details, client)
+
+	if lockedUntil != nil {
+		logrus.WithFields(logrus.Fields{
+			"user_id":      user.ID,
+			"locked_until": lockedUntil,
+		}).Warn("Account locked after repeated failed logins")
+		as.auditService.Record(&user.ID, user.TenantID, AuditActionAccountLocked, resource,
+			map[string]interface{}{"failed_attempts": attempts, "locked_until": lockedUntil}, client)
+	}
+}
+
+// recordLoginSuccess resets the failure state and writes the audit trail
+func (as *AuthService) recordLoginSuccess(user *models.User, client ClientInfo) {
+	as.throttle.Reset(usernameThrottleKey(user.Username))
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := as.userService.ResetFailedLogins(user.ID); err != nil {
+			logrus.WithError(err).WithField("user_id", user.ID).Error("Failed to reset failed logins")
+		}
+	}
+
+	as.auditService.Record(&user.ID, user.TenantID, AuditActionLogin, "user:"+user.ID.String(), nil, client)
 }
 
 // Register creates a new user account
 func (as *AuthService) Register(req *RegisterRequest) (*models.User, error) {
+	if err := as.checkSignupAllowed(); err != nil {
+		return nil, err
+	}
+
+	user, err := as.newUser(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if as.config.SaaS.MultiTenant {
+		// Every account needs a tenant so tenant limits apply; individuals get a personal one
+		if _, err := as.tenantService.ProvisionTenant(req.Username, "", user); err != nil {
+			return nil, err
+		}
+	} else if err := as.userService.CreateUser(user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	if err := as.SendEmailVerification(user); err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Error("Failed to send verification email")
+	}
+
+	// Don't return password
+	user.Password = ""
+	return user, nil
+}
+
+// Signup creates an organization: a tenant on the free plan, its first
+// tenant admin and a subscription
+func (as *AuthService) Signup(req *SignupRequest) (*SignupResponse, error) {
+	if err := as.checkSignupAllowed(); err != nil {
+		return nil, err
+	}
+
+	user, err := as.newUser(&req.RegisterRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := as.tenantService.ProvisionTenant(req.OrganizationName, strings.ToLower(strings.TrimSpace(req.Slug)), user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.SendEmailVerification(user); err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Error("Failed to send verification email")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"tenant_id":   tenant.ID,
+		"tenant_slug": tenant.Slug,
+		"user_id":     user.ID,
+	}).Info("Organization signed up")
+
+	user.Password = ""
+	return &SignupResponse{Tenant: tenant, User: user}, nil
+}
+
+// checkSignupAllowed rejects self-service account creation unless signup is open
+func (as *AuthService) checkSignupAllowed() error {
+	switch as.config.SaaS.SignupMode {
+	case config.SignupModeOpen, "":
+		return nil
+	case config.SignupModeInviteOnly:
+		return fmt.Errorf("signup is by invitation only")
+	default:
+		return fmt.Errorf("signup is disabled")
+	}
+}
+
+// newUser validates a registration and builds the user with a hashed password
+func (as *AuthService) newUser(req *RegisterRequest) (*models.User, error) {
 	// Check if username already exists
 	if _, err := as.userService.GetUserByUsername(req.Username); err == nil {
 		return nil, fmt.Errorf("username already exists")
@@ -105,14 +397,18 @@ func (as *AuthService) Register(req *RegisterRequest) (*models.User, error) {
 		return nil, fmt.Errorf("email already exists")
 	}
 
+	if err := as.passwordPolicy.Validate(req.Password, passwordIdentifiers(req.Username, req.Email)...); err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// Create user
-	user := &models.User{
+	return &models.User{
+		ID:        uuid.New(),
 		Username:  req.Username,
 		Email:     req.Email,
 		Password:  string(hashedPassword),
@@ -120,16 +416,157 @@ func (as *AuthService) Register(req *RegisterRequest) (*models.User, error) {
 		LastName:  req.LastName,
 		Role:      models.UserRoleUser,
 		IsActive:  true,
+	}, nil
+}
+
+// ChangePassword replaces the password of a logged-in user after checking the current one
+func (as *AuthService) ChangePassword(user *models.User, req *ChangePasswordRequest) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		return fmt.Errorf("current password is incorrect")
 	}
 
-	// Create user in database
-	if err := as.userService.CreateUser(user); err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+	if err := as.setPassword(user, req.NewPassword); err != nil {
+		return err
 	}
 
-	// Don't return password
-	user.Password = ""
-	return user, nil
+	as.sendPasswordChangedNotice(user)
+	return nil
+}
+
+// RequestPasswordReset emails a reset link if the address belongs to an active user.
+// It does not report whether the address exists.
+func (as *AuthService) RequestPasswordReset(email string) error {
+	user, err := as.userService.GetUserByEmail(email)
+	if err != nil || !user.IsActive {
+		return nil
+	}
+
+	token, err := as.userService.CreateUserToken(user.ID, models.UserTokenPurposePasswordReset, as.config.Auth.PasswordResetDuration)
+	if err != nil {
+		return err
+	}
+
+	return as.mailService.SendTemplate([]string{user.Email}, MailTemplatePasswordReset, map[string]interface{}{
+		"Name":      displayName(user),
+		"Link":      as.mailService.AppLink("/auth/reset-password?token=" + token),
+		"ExpiresIn": as.config.Auth.PasswordResetDuration.String(),
+	})
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset
+func (as *AuthService) ResetPassword(req *ResetPasswordRequest) error {
+	userID, err := as.userService.ConsumeUserToken(req.Token, models.UserTokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	user, err := as.userService.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("invalid or expired token")
+	}
+
+	if err := as.setPassword(user, req.NewPassword); err != nil {
+		return err
+	}
+
+	// The reset link was delivered to this address, so it is verified as well
+	if !user.EmailVerified {
+		if err := as.userService.MarkEmailVerified(user.ID); err != nil {
+			logrus.WithError(err).WithField("user_id", user.ID).Error("Failed to mark email verified")
+		}
+	}
+
+	as.sendPasswordChangedNotice(user)
+	return nil
+}
+
+// SendEmailVerification emails a verification link to the user
+func (as *AuthService) SendEmailVerification(user *models.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	token, err := as.userService.CreateUserToken(user.ID, models.UserTokenPurposeEmailVerification, as.config.Auth.EmailVerificationDuration)
+	if err != nil {
+		return err
+	}
+
+	return as.mailService.SendTemplate([]string{user.Email}, MailTemplateEmailVerification, map[string]interface{}{
+		"Name":      displayName(user),
+		"Link":      as.mailService.AppLink("/auth/verify-email?token=" + token),
+		"ExpiresIn": as.config.Auth.EmailVerificationDuration.String(),
+	})
+}
+
+// ResendEmailVerification sends a new verification link if the address belongs
+// to an unverified user. It does not report whether the address exists.
+func (as *AuthService) ResendEmailVerification(email string) error {
+	user, err := as.userService.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	return as.SendEmailVerification(user)
+}
+
+// VerifyEmail confirms a user's email address using a token from SendEmailVerification
+func (as *AuthService) VerifyEmail(token string) error {
+	userID, err := as.userService.ConsumeUserToken(token, models.UserTokenPurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	if err := as.userService.MarkEmailVerified(userID); err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	return nil
+}
+
+// setPassword validates, hashes and stores a new password
+func (as *AuthService) setPassword(user *models.User, password string) error {
+	if err := as.passwordPolicy.Validate(password, passwordIdentifiers(user.Username, user.Email)...); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := as.userService.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	user.Password = string(hashedPassword)
+	return nil
+}
+
+// sendPasswordChangedNotice tells the user their password changed; failures are only logged
+func (as *AuthService) sendPasswordChangedNotice(user *models.User) {
+	err := as.mailService.SendTemplate([]string{user.Email}, MailTemplatePasswordChanged, map[string]interface{}{
+		"Name": displayName(user),
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Error("Failed to send password changed notice")
+	}
+}
+
+// passwordIdentifiers returns the account identifiers a password must not contain
+func passwordIdentifiers(username, email string) []string {
+	localPart := email
+	if i := strings.Index(email, "@"); i >= 0 {
+		localPart = email[:i]
+	}
+	return []string{username, localPart}
+}
+
+// displayName returns the name used to greet a user in emails
+func displayName(user *models.User) string {
+	if user.FirstName != "" {
+		return user.FirstName
+	}
+	return user.Username
 }
 
 // RefreshToken generates a new token from refresh token
@@ -139,6 +576,9 @@ func (as *AuthService) RefreshToken(refreshToken string) (*LoginResponse, error)
 	if err != nil {
 		return nil, fmt.Errorf("invalid refresh token: %w", err)
 	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
 
 	// Get user
 	user, err := as.userService.GetUserByID(claims.UserID)
@@ -146,35 +586,92 @@ func (as *AuthService) RefreshToken(refreshToken string) (*LoginResponse, error)
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
 
-	// Check if user is active
-	if !user.IsActive {
-		return nil, fmt.Errorf("account is inactive")
+	// Stay in the tenant the token was issued for unless the user left it
+	if err := as.tenantService.ResolveActiveTenant(user, claims.TenantID); err != nil {
+		if !errors.Is(err, ErrNotTenantMember) {
+			return nil, err
+		}
+		if err := as.applyLoginTenant(user); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check if user and tenant are active
+	if err := CheckAccountActive(user); err != nil {
+		return nil, err
+	}
+
+	return as.issueTokens(user)
+}
+
+// SwitchTenant issues tokens scoped to another tenant the user belongs to and
+// remembers it as the tenant to log into next time
+func (as *AuthService) SwitchTenant(user *models.User, tenantID uuid.UUID, client ClientInfo) (*LoginResponse, error) {
+	membership, err := as.tenantService.GetMembership(user.ID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	ApplyMembership(user, membership)
+	if err := CheckAccountActive(user); err != nil {
+		return nil, err
 	}
 
-	// Generate new tokens
+	if err := as.tenantService.SelectTenant(user.ID, tenantID); err != nil {
+		return nil, err
+	}
+
+	as.auditService.Record(&user.ID, user.TenantID, AuditActionTenantSwitched, "tenant:"+tenantID.String(), nil, client)
+	return as.issueTokens(user)
+}
+
+// applyLoginTenant makes the tenant chosen by LoginMembership the user's
+// active tenant
+func (as *AuthService) applyLoginTenant(user *models.User) error {
+	membership, err := as.tenantService.LoginMembership(user)
+	if err != nil {
+		return err
+	}
+	ApplyMembership(user, membership)
+	return nil
+}
+
+// ValidateToken validates JWT token and returns claims
+func (as *AuthService) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := as.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// MFA challenge tokens must not grant API access
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
+
+// issueTokens generates an access and refresh token pair for user
+func (as *AuthService) issueTokens(user *models.User) (*LoginResponse, error) {
 	token, expiresAt, err := as.generateToken(user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	newRefreshToken, _, err := as.generateRefreshToken(user)
+	refreshToken, _, err := as.generateRefreshToken(user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
 	return &LoginResponse{
-		Token:        token,
-		RefreshToken: newRefreshToken,
-		User:         *user,
-		ExpiresAt:    expiresAt,
+		Token:                 token,
+		RefreshToken:          refreshToken,
+		User:                  user,
+		ExpiresAt:             expiresAt,
+		MFAEnrollmentRequired: as.mfaService.IsRequired(user) && !user.MFAEnabled,
 	}, nil
 }
 
-// ValidateToken validates JWT token and returns claims
-func (as *AuthService) ValidateToken(tokenString string) (*Claims, error) {
-	return as.parseToken(tokenString)
-}
-
 // generateToken generates JWT token for user
 func (as *AuthService) generateToken(user *models.User) (string, time.Time, error) {
 	expiresAt := time.Now().Add(as.config.JWT.TokenDuration)
@@ -229,6 +726,32 @@ func (as *AuthService) generateRefreshToken(user *models.User) (string, time.Tim
 	return tokenString, expiresAt, nil
 }
 
+// generateMFAToken generates the short-lived challenge token for the second login step
+func (as *AuthService) generateMFAToken(user *models.User) (string, time.Time, error) {
+	expiresAt := time.Now().Add(as.config.MFA.ChallengeDuration)
+
+	claims := &Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Purpose:  tokenPurposeMFA,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "nomad-services-api",
+			Subject:   user.ID.String(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(as.config.JWT.Secret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expiresAt, nil
+}
+
 // parseToken parses JWT token and returns claims
 func (as *AuthService) parseToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
@@ -0,0 +1,468 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/payments"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrPaymentsDisabled is returned when no payment provider is configured
+	ErrPaymentsDisabled = errors.New("payments are not enabled")
+
+	// ErrNoPaymentMethod is returned when charging a tenant without a saved
+	// payment method
+	ErrNoPaymentMethod = errors.New("no payment method on file")
+)
+
+// PaymentFailedError is returned when the provider declines a charge
+type PaymentFailedError struct {
+	Message string
+}
+
+func (e *PaymentFailedError) Error() string {
+	return "payment failed: " + e.Message
+}
+
+// PaymentMethodRequest saves a payment method collected by the provider's
+// client-side UI
+type PaymentMethodRequest struct {
+	PaymentMethodID string `json:"payment_method_id" binding:"required"`
+}
+
+// PaymentMethods lists a tenant's saved payment methods
+type PaymentMethods struct {
+	Methods []payments.PaymentMethod `json:"payment_methods"`
+	Default string                   `json:"default_payment_method_id"`
+}
+
+// BillingService collects invoice payments through a payment provider.
+//
+// Issued invoices are charged to the tenant's default payment method. The
+// outcome of a charge is applied straight away or, for charges that settle
+// later, when the provider's webhook arrives. Paying the invoice of a past
+// due or expired subscription reactivates it.
+type BillingService struct {
+	db                  *gorm.DB
+	provider            payments.Provider
+	invoiceService      *InvoiceService
+	subscriptionService *SubscriptionService
+	auditService        *AuditService
+}
+
+// NewBillingService creates the service. provider may be nil, which disables
+// payments.
+func NewBillingService(db *gorm.DB, provider payments.Provider, invoiceService *InvoiceService, subscriptionService *SubscriptionService, auditService *AuditService) *BillingService {
+	return &BillingService{
+		db:                  db,
+		provider:            provider,
+		invoiceService:      invoiceService,
+		subscriptionService: subscriptionService,
+		auditService:        auditService,
+	}
+}
+
+// Enabled reports whether a payment provider is configured
+func (bs *BillingService) Enabled() bool {
+	return bs.provider != nil
+}
+
+// ListPaymentMethods returns the tenant's saved payment methods
+func (bs *BillingService) ListPaymentMethods(tenantID uuid.UUID) (*PaymentMethods, error) {
+	if !bs.Enabled() {
+		return nil, ErrPaymentsDisabled
+	}
+
+	tenant, err := bs.getTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.PaymentCustomerID == "" {
+		return &PaymentMethods{Methods: []payments.PaymentMethod{}}, nil
+	}
+
+	methods, err := bs.provider.ListPaymentMethods(context.Background(), tenant.PaymentCustomerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payment methods: %w", err)
+	}
+	return &PaymentMethods{Methods: methods, Default: tenant.PaymentMethodID}, nil
+}
+
+// AddPaymentMethod saves a payment method for the tenant and makes it the
+// default, creating the provider's customer on first use
+func (bs *BillingService) AddPaymentMethod(tenantID uuid.UUID, req *PaymentMethodRequest, userID uuid.UUID, client ClientInfo) (*payments.PaymentMethod, error) {
+	if !bs.Enabled() {
+		return nil, ErrPaymentsDisabled
+	}
+
+	tenant, err := bs.getTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := bs.ensureCustomer(tenant); err != nil {
+		return nil, err
+	}
+
+	method, err := bs.provider.AttachPaymentMethod(context.Background(), tenant.PaymentCustomerID, req.PaymentMethodID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment method: %w", err)
+	}
+	if err := bs.db.Model(tenant).Update("payment_method_id", method.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to update tenant: %w", err)
+	}
+
+	bs.auditService.Record(&userID, &tenantID, AuditActionPaymentMethodAdded, "payment_method", map[string]interface{}{
+		"payment_method_id": method.ID,
+		"brand":             method.Brand,
+		"last4":             method.Last4,
+	}, client)
+	return method, nil
+}
+
+// RemovePaymentMethod deletes one of the tenant's payment methods
+func (bs *BillingService) RemovePaymentMethod(tenantID uuid.UUID, paymentMethodID string, userID uuid.UUID, client ClientInfo) error {
+	methods, err := bs.ListPaymentMethods(tenantID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, method := range methods.Methods {
+		found = found || method.ID == paymentMethodID
+	}
+	if !found {
+		return fmt.Errorf("%w: payment method %s", payments.ErrNotFound, paymentMethodID)
+	}
+
+	if err := bs.provider.DetachPaymentMethod(context.Background(), paymentMethodID); err != nil {
+		return fmt.Errorf("failed to remove payment method: %w", err)
+	}
+	if methods.Default == paymentMethodID {
+		if err := bs.db.Model(&models.Tenant{}).Where("id = ?", tenantID).
+			Update("payment_method_id", "").Error; err != nil {
+			return fmt.Errorf("failed to update tenant: %w", err)
+		}
+	}
+
+	bs.auditService.Record(&userID, &tenantID, AuditActionPaymentMethodRemoved, "payment_method", map[string]interface{}{
+		"payment_method_id": paymentMethodID,
+	}, client)
+	return nil
+}
+
+// PayInvoice charges an open invoice to the tenant's default payment method.
+// A non-nil tenantID restricts it to that tenant's invoices. Charges that
+// settle later leave the invoice open until the webhook arrives.
+func (bs *BillingService) PayInvoice(tenantID *uuid.UUID, invoiceID uuid.UUID, actorID *uuid.UUID, client ClientInfo) (*models.Invoice, error) {
+	if !bs.Enabled() {
+		return nil, ErrPaymentsDisabled
+	}
+
+	invoice, err := bs.invoiceService.GetInvoice(tenantID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Status != models.InvoiceStatusOpen {
+		return nil, fmt.Errorf("invoice is %s", invoice.Status)
+	}
+
+	tenant, err := bs.getTenant(invoice.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.PaymentCustomerID == "" || tenant.PaymentMethodID == "" {
+		return nil, ErrNoPaymentMethod
+	}
+
+	// The key includes the payment method so a declined invoice can be
+	// retried with another card
+	charge, err := bs.provider.Charge(context.Background(), payments.ChargeRequest{
+		CustomerID:      tenant.PaymentCustomerID,
+		PaymentMethodID: tenant.PaymentMethodID,
+		Amount:          payments.MinorUnits(invoice.Total),
+		Currency:        invoice.Currency,
+		Description:     "Invoice " + invoice.Number,
+		IdempotencyKey:  "invoice-" + invoice.ID.String() + "-" + tenant.PaymentMethodID,
+		Metadata: map[string]string{
+			"invoice_id": invoice.ID.String(),
+			"tenant_id":  tenant.ID.String(),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to charge invoice: %w", err)
+	}
+
+	switch charge.Status {
+	case payments.ChargeSucceeded:
+		settled, err := bs.settle(bs.db, invoice, charge.ID)
+		if err != nil {
+			return nil, err
+		}
+		bs.settled(settled, actorID, client)
+		return settled.invoice, nil
+	case payments.ChargePending:
+		if err := bs.db.Model(invoice).Update("payment_id", charge.ID).Error; err != nil {
+			return nil, fmt.Errorf("failed to update invoice: %w", err)
+		}
+		return invoice, nil
+	default:
+		bs.auditPayment(actorID, invoice, AuditActionPaymentFailed, charge.ID, charge.FailureMessage, client)
+		return nil, &PaymentFailedError{Message: charge.FailureMessage}
+	}
+}
+
+// Collect charges an issued invoice if the tenant has a payment method. It is
+// registered with InvoiceService.SetCollector; failures are logged and the
+// invoice stays open.
+func (bs *BillingService) Collect(invoice *models.Invoice) {
+	if !bs.Enabled() {
+		return
+	}
+
+	_, err := bs.PayInvoice(nil, invoice.ID, nil, ClientInfo{})
+	if errors.Is(err, ErrNoPaymentMethod) {
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("invoice_id", invoice.ID).Warn("Failed to collect invoice payment")
+	}
+}
+
+// HandleWebhook verifies and applies a payment provider event. Each event is
+// processed once; redeliveries are acknowledged without doing anything. An
+// error leaves the event unrecorded so the provider retries it.
+func (bs *BillingService) HandleWebhook(payload []byte, header http.Header) error {
+	if !bs.Enabled() {
+		return ErrPaymentsDisabled
+	}
+
+	event, err := bs.provider.ParseWebhook(payload, header)
+	if err != nil {
+		return err
+	}
+
+	record := models.PaymentEvent{
+		ID:          uuid.New(),
+		Provider:    bs.provider.Name(),
+		EventID:     event.ID,
+		Type:        string(event.Type),
+		TenantID:    metadataID(event.Metadata, "tenant_id"),
+		InvoiceID:   metadataID(event.Metadata, "invoice_id"),
+		ChargeID:    event.ChargeID,
+		Amount:      float64(event.Amount) / 100,
+		Currency:    event.Currency,
+		ProcessedAt: time.Now(),
+	}
+
+	var settled *settlement
+	err = bs.db.Transaction(func(tx *gorm.DB) error {
+		// Concurrent deliveries of the same event wait on the unique index
+		// until this transaction ends
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record payment event: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		settled, err = bs.apply(tx, event, record.InvoiceID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if settled != nil {
+		bs.settled(settled, nil, ClientInfo{})
+	}
+	return nil
+}
+
+// apply updates the invoice an event is about with tx. Events for invoices
+// this API did not charge are ignored, and so are payments that don't match
+// the invoice. It returns the settlement of an invoice the event paid.
+func (bs *BillingService) apply(tx *gorm.DB, event *payments.Event, invoiceID *uuid.UUID) (*settlement, error) {
+	if invoiceID == nil {
+		return nil, nil
+	}
+
+	invoice, err := bs.invoiceService.getInvoice(tx, nil, *invoiceID)
+	if errors.Is(err, ErrInvoiceNotFound) {
+		logrus.WithField("invoice_id", *invoiceID).Warn("Payment event for unknown invoice")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch event.Type {
+	case payments.EventPaymentSucceeded:
+		if invoice.Status != models.InvoiceStatusOpen {
+			return nil, nil
+		}
+		if mismatch := paymentMismatch(event, invoice); mismatch != "" {
+			logrus.WithFields(logrus.Fields{
+				"invoice_id": invoice.ID,
+				"event_id":   event.ID,
+				"charge_id":  event.ChargeID,
+			}).Errorf("Payment does not match invoice: %s", mismatch)
+			bs.auditPayment(nil, invoice, AuditActionPaymentFailed, event.ChargeID, mismatch, ClientInfo{})
+			return nil, nil
+		}
+		return bs.settle(tx, invoice, event.ChargeID)
+	case payments.EventPaymentFailed:
+		bs.auditPayment(nil, invoice, AuditActionPaymentFailed, event.ChargeID, event.FailureMessage, ClientInfo{})
+	}
+	return nil, nil
+}
+
+// paymentMismatch describes how a successful payment differs from the
+// invoice it claims to pay: another tenant, amount or currency, or another
+// charge than the one the invoice is waiting for. It is empty if it matches.
+func paymentMismatch(event *payments.Event, invoice *models.Invoice) string {
+	if tenantID := metadataID(event.Metadata, "tenant_id"); tenantID != nil && *tenantID != invoice.TenantID {
+		return fmt.Sprintf("paid for tenant %s, invoice belongs to %s", *tenantID, invoice.TenantID)
+	}
+	if event.Amount != payments.MinorUnits(invoice.Total) || !strings.EqualFold(event.Currency, invoice.Currency) {
+		return fmt.Sprintf("paid %.2f %s, invoice total is %.2f %s",
+			float64(event.Amount)/100, strings.ToUpper(event.Currency), invoice.Total, invoice.Currency)
+	}
+	if invoice.PaymentID != "" && event.ChargeID != invoice.PaymentID {
+		return fmt.Sprintf("charge %s, invoice is awaiting charge %s", event.ChargeID, invoice.PaymentID)
+	}
+	return ""
+}
+
+// settlement is the outcome of settle
+type settlement struct {
+	invoice  *models.Invoice
+	chargeID string
+	paid     bool                 // false if the invoice was paid concurrently
+	payment  *subscriptionPayment // set if the subscription was settled too
+}
+
+// settle marks an invoice paid with db, which may be a transaction, and, if
+// it bills the period of a past due or expired subscription, settles the
+// subscription too. The caller passes the result to settled once the change
+// is committed.
+func (bs *BillingService) settle(db *gorm.DB, invoice *models.Invoice, chargeID string) (*settlement, error) {
+	paid, err := bs.invoiceService.markPaid(db, invoice.ID, chargeID)
+	if err != nil {
+		// Settled concurrently, e.g. by the webhook of this charge
+		if current, getErr := bs.invoiceService.getInvoice(db, nil, invoice.ID); getErr == nil && current.Status == models.InvoiceStatusPaid {
+			return &settlement{invoice: current}, nil
+		}
+		return nil, err
+	}
+	settled := &settlement{invoice: paid, chargeID: chargeID, paid: true}
+
+	if paid.SubscriptionID == nil || !billsPeriod(paid) {
+		return settled, nil
+	}
+
+	var subscription models.Subscription
+	if err := db.Where("tenant_id = ?", paid.TenantID).Order("created_at DESC").
+		First(&subscription).Error; err != nil {
+		return settled, nil
+	}
+	if subscription.ID != *paid.SubscriptionID ||
+		(subscription.Status != models.SubscriptionStatusPastDue && subscription.Status != models.SubscriptionStatusExpired) {
+		return settled, nil
+	}
+
+	settled.payment, err = bs.subscriptionService.settlePayment(db, paid.TenantID, false)
+	if err != nil {
+		logrus.WithError(err).WithField("tenant_id", paid.TenantID).Error("Failed to settle subscription")
+	}
+	return settled, nil
+}
+
+// settled records a committed settlement and, if it settled the
+// subscription, notifies and reactivates the tenant
+func (bs *BillingService) settled(s *settlement, actorID *uuid.UUID, client ClientInfo) {
+	if !s.paid {
+		return
+	}
+
+	bs.invoiceService.auditPaid(actorID, s.invoice, client)
+	bs.auditPayment(actorID, s.invoice, AuditActionPaymentSucceeded, s.chargeID, "", client)
+	if s.payment != nil {
+		bs.subscriptionService.paymentSettled(s.payment, actorID, client)
+	}
+}
+
+// ensureCustomer creates the provider's customer for tenant if it has none
+func (bs *BillingService) ensureCustomer(tenant *models.Tenant) error {
+	if tenant.PaymentCustomerID != "" {
+		return nil
+	}
+
+	email := ""
+	if emails, err := billingContacts(bs.db, tenant.ID); err == nil && len(emails) > 0 {
+		email = emails[0]
+	}
+
+	customer, err := bs.provider.CreateCustomer(context.Background(), email, tenant.Name, map[string]string{
+		"tenant_id": tenant.ID.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create payment customer: %w", err)
+	}
+
+	tenant.PaymentCustomerID = customer.ID
+	if err := bs.db.Model(tenant).Update("payment_customer_id", customer.ID).Error; err != nil {
+		return fmt.Errorf("failed to update tenant: %w", err)
+	}
+	return nil
+}
+
+func (bs *BillingService) auditPayment(actorID *uuid.UUID, invoice *models.Invoice, action, chargeID, failure string, client ClientInfo) {
+	details := map[string]interface{}{
+		"invoice_id": invoice.ID,
+		"number":     invoice.Number,
+		"amount":     invoice.Total,
+		"currency":   invoice.Currency,
+		"charge_id":  chargeID,
+	}
+	if failure != "" {
+		details["failure"] = failure
+	}
+	bs.auditService.Record(actorID, &invoice.TenantID, action, "payment", details, client)
+}
+
+func (bs *BillingService) getTenant(tenantID uuid.UUID) (*models.Tenant, error) {
+	var tenant models.Tenant
+	if err := bs.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, ErrTenantNotFound
+	}
+	return &tenant, nil
+}
+
+// billsPeriod reports whether an invoice charges for a subscription period
+func billsPeriod(invoice *models.Invoice) bool {
+	for _, line := range invoice.Lines {
+		if line.Type == models.InvoiceLineSubscription {
+			return true
+		}
+	}
+	return false
+}
+
+func metadataID(metadata map[string]string, key string) *uuid.UUID {
+	id, err := uuid.Parse(metadata[key])
+	if err != nil {
+		return nil
+	}
+	return &id
+}
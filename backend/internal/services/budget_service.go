@@ -0,0 +1,450 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/payments"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrBudgetNotFound is returned for tenants without a budget
+	ErrBudgetNotFound = errors.New("budget not found")
+
+	// ErrBudgetExceeded is returned when a capped budget blocks deploying or
+	// scaling up services
+	ErrBudgetExceeded = errors.New("spend budget reached")
+)
+
+// BudgetSignatureHeader carries the signature of budget webhooks, in the
+// same format as payment provider webhooks
+const BudgetSignatureHeader = "X-Webhook-Signature"
+
+// DefaultBudgetThresholds are the alert thresholds, in percent of the
+// budget, used when a request names none
+var DefaultBudgetThresholds = []int{50, 80, 100}
+
+// BudgetRequest replaces a tenant's budget. NotifyEmail defaults to true.
+type BudgetRequest struct {
+	Amount        float64                `json:"amount" binding:"required"`
+	Thresholds    []int                  `json:"thresholds"`
+	NotifyEmail   *bool                  `json:"notify_email"`
+	WebhookURL    string                 `json:"webhook_url"`
+	WebhookSecret string                 `json:"webhook_secret"`
+	CapAction     models.BudgetCapAction `json:"cap_action"`
+}
+
+// BudgetAlert is the body of budget webhooks
+type BudgetAlert struct {
+	Event       string                 `json:"event"`
+	TenantID    uuid.UUID              `json:"tenant_id"`
+	Threshold   int                    `json:"threshold"`
+	Amount      float64                `json:"amount"`
+	Spend       float64                `json:"spend"`
+	Currency    string                 `json:"currency"`
+	PeriodStart time.Time              `json:"period_start"`
+	PeriodEnd   time.Time              `json:"period_end"`
+	Capped      bool                   `json:"capped"`
+	CapAction   models.BudgetCapAction `json:"cap_action"`
+	SentAt      time.Time              `json:"sent_at"`
+}
+
+// BudgetService compares the metered usage charges of each tenant's current
+// billing period with its budget. The usage is priced as it will be on the
+// period's invoice, i.e. only usage beyond the plan's included hours costs
+// anything.
+//
+// Crossing a threshold notifies the tenant admins by email and/or a signed
+// webhook. Reaching the full budget with a cap blocks new deployments and
+// optionally scales running services down to one instance.
+type BudgetService struct {
+	db             *gorm.DB
+	serviceManager *ServiceManager
+	auditService   *AuditService
+	mailService    *MailService
+	config         *config.Config
+	client         *http.Client
+	stop           chan struct{}
+}
+
+func NewBudgetService(db *gorm.DB, serviceManager *ServiceManager, auditService *AuditService, mailService *MailService, cfg *config.Config) *BudgetService {
+	return &BudgetService{
+		db:             db,
+		serviceManager: serviceManager,
+		auditService:   auditService,
+		mailService:    mailService,
+		config:         cfg,
+		client:         newWebhookClient(10 * time.Second),
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start checks the budgets in the background until Stop is called
+func (bs *BudgetService) Start() {
+	go bs.run(bs.config.SaaS.BudgetCheckInterval)
+}
+
+// Stop stops the budget checks
+func (bs *BudgetService) Stop() {
+	close(bs.stop)
+}
+
+func (bs *BudgetService) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bs.stop:
+			return
+		case now := <-ticker.C:
+			bs.CheckAll(now)
+		}
+	}
+}
+
+// CheckAll checks the budget of every tenant that has one
+func (bs *BudgetService) CheckAll(now time.Time) {
+	var tenantIDs []uuid.UUID
+	if err := bs.db.Model(&models.TenantBudget{}).Pluck("tenant_id", &tenantIDs).Error; err != nil {
+		logrus.WithError(err).Error("Failed to load budgets")
+		return
+	}
+
+	for _, tenantID := range tenantIDs {
+		if _, err := bs.check(tenantID, now); err != nil {
+			logrus.WithError(err).WithField("tenant_id", tenantID).Error("Failed to check budget")
+		}
+	}
+}
+
+// GetBudget returns a tenant's budget as of its last check
+func (bs *BudgetService) GetBudget(tenantID uuid.UUID) (*models.TenantBudget, error) {
+	var budget models.TenantBudget
+	err := bs.db.Where("tenant_id = ?", tenantID).First(&budget).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBudgetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget: %w", err)
+	}
+	return &budget, nil
+}
+
+// SetBudget creates or replaces a tenant's budget and checks it right away,
+// so a lower budget alerts and a higher one lifts the cap immediately
+func (bs *BudgetService) SetBudget(tenantID uuid.UUID, req *BudgetRequest, actorID uuid.UUID, client ClientInfo) (*models.TenantBudget, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	thresholds := req.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = DefaultBudgetThresholds
+	}
+	thresholds = append([]int(nil), thresholds...)
+	sort.Ints(thresholds)
+	for i, threshold := range thresholds {
+		if threshold < 1 || threshold > 1000 {
+			return nil, fmt.Errorf("thresholds must be between 1 and 1000 percent")
+		}
+		if i > 0 && threshold == thresholds[i-1] {
+			return nil, fmt.Errorf("duplicate threshold %d", threshold)
+		}
+	}
+
+	capAction := req.CapAction
+	switch capAction {
+	case "":
+		capAction = models.BudgetCapNone
+	case models.BudgetCapNone, models.BudgetCapBlock, models.BudgetCapScaleDown:
+	default:
+		return nil, fmt.Errorf("cap_action must be none, block or scale_down")
+	}
+
+	if req.WebhookURL != "" {
+		if err := validateWebhookURL(req.WebhookURL); err != nil {
+			return nil, err
+		}
+	}
+
+	notifyEmail := true
+	if req.NotifyEmail != nil {
+		notifyEmail = *req.NotifyEmail
+	}
+
+	budget := &models.TenantBudget{
+		ID:            uuid.New(),
+		TenantID:      tenantID,
+		Amount:        roundCents(req.Amount),
+		Thresholds:    thresholds,
+		NotifyEmail:   notifyEmail,
+		WebhookURL:    req.WebhookURL,
+		WebhookSecret: req.WebhookSecret,
+		CapAction:     capAction,
+	}
+	if err := bs.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "tenant_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"amount":         budget.Amount,
+			"thresholds":     gorm.Expr("EXCLUDED.thresholds"),
+			"notify_email":   budget.NotifyEmail,
+			"webhook_url":    budget.WebhookURL,
+			"webhook_secret": budget.WebhookSecret,
+			"cap_action":     budget.CapAction,
+			"updated_at":     time.Now(),
+			// Thresholds of a new amount are alerted afresh
+			"alerted_threshold": gorm.Expr("CASE WHEN tenant_budgets.amount = EXCLUDED.amount THEN tenant_budgets.alerted_threshold ELSE 0 END"),
+		}),
+	}).Create(budget).Error; err != nil {
+		return nil, fmt.Errorf("failed to save budget: %w", err)
+	}
+
+	bs.auditService.Record(&actorID, &tenantID, AuditActionBudgetUpdated, "budget", map[string]interface{}{
+		"amount":     budget.Amount,
+		"thresholds": thresholds,
+		"cap_action": capAction,
+	}, client)
+
+	return bs.check(tenantID, time.Now())
+}
+
+// DeleteBudget removes a tenant's budget, and with it any cap
+func (bs *BudgetService) DeleteBudget(tenantID, actorID uuid.UUID, client ClientInfo) error {
+	result := bs.db.Where("tenant_id = ?", tenantID).Delete(&models.TenantBudget{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete budget: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrBudgetNotFound
+	}
+
+	bs.auditService.Record(&actorID, &tenantID, AuditActionBudgetDeleted, "budget", nil, client)
+	return nil
+}
+
+// check recomputes a tenant's spend and applies thresholds and the cap. The
+// budget is locked while it changes, so several API instances never alert
+// twice; notifications and scaling happen after the change is committed.
+func (bs *BudgetService) check(tenantID uuid.UUID, now time.Time) (*models.TenantBudget, error) {
+	var budget models.TenantBudget
+	var alert *BudgetAlert
+	capped := false
+
+	err := bs.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("tenant_id = ?", tenantID).First(&budget).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrBudgetNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get budget: %w", err)
+		}
+
+		start, end, spend, err := meteredSpend(tx, tenantID, now)
+		if err != nil {
+			return err
+		}
+
+		// A new period starts over
+		if !budget.PeriodStart.Equal(start) {
+			budget.PeriodStart = start
+			budget.AlertedThreshold = 0
+			budget.CappedAt = nil
+		}
+		budget.PeriodEnd = end
+		budget.Spend = spend
+		budget.CheckedAt = &now
+
+		// One alert for the highest threshold crossed since the last one
+		crossed := 0
+		for _, threshold := range budget.Thresholds {
+			if threshold > budget.AlertedThreshold && spend >= budget.Amount*float64(threshold)/100 {
+				crossed = threshold
+			}
+		}
+
+		reached := spend >= budget.Amount
+		switch {
+		case reached && budget.CapAction != models.BudgetCapNone && budget.CappedAt == nil:
+			budget.CappedAt = &now
+			capped = true
+		case !reached && budget.CappedAt != nil:
+			budget.CappedAt = nil // The budget was raised
+		case budget.CapAction == models.BudgetCapNone:
+			budget.CappedAt = nil
+		}
+
+		if crossed > 0 {
+			budget.AlertedThreshold = crossed
+			alert = &BudgetAlert{
+				Event:       AuditActionBudgetAlert,
+				TenantID:    tenantID,
+				Threshold:   crossed,
+				Amount:      budget.Amount,
+				Spend:       spend,
+				Currency:    bs.config.SaaS.Currency,
+				PeriodStart: start,
+				PeriodEnd:   end,
+				Capped:      budget.CappedAt != nil,
+				CapAction:   budget.CapAction,
+				SentAt:      now,
+			}
+		}
+
+		return tx.Save(&budget).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if capped {
+		bs.applyCap(&budget)
+	}
+	if alert != nil {
+		bs.auditService.Record(nil, &tenantID, AuditActionBudgetAlert, "budget", map[string]interface{}{
+			"threshold": alert.Threshold,
+			"spend":     alert.Spend,
+			"amount":    alert.Amount,
+		}, ClientInfo{})
+		bs.notify(&budget, alert)
+	}
+
+	return &budget, nil
+}
+
+// applyCap scales the tenant's services down when the budget asks for it.
+// Blocking deployments needs nothing here; ServiceManager checks CappedAt.
+func (bs *BudgetService) applyCap(budget *models.TenantBudget) {
+	details := map[string]interface{}{
+		"cap_action": budget.CapAction,
+		"spend":      budget.Spend,
+		"amount":     budget.Amount,
+	}
+
+	if budget.CapAction == models.BudgetCapScaleDown {
+		scaled, err := bs.serviceManager.ScaleDownServices(context.Background(), budget.TenantID)
+		if err != nil {
+			logrus.WithError(err).WithField("tenant_id", budget.TenantID).Error("Failed to scale down services of capped tenant")
+		}
+		details["services_scaled"] = scaled
+	}
+
+	bs.auditService.Record(nil, &budget.TenantID, AuditActionBudgetCapped, "budget", details, ClientInfo{})
+}
+
+// notify emails the tenant admins and posts the webhook. Failures are logged;
+// the threshold is not alerted again.
+func (bs *BudgetService) notify(budget *models.TenantBudget, alert *BudgetAlert) {
+	log := logrus.WithFields(logrus.Fields{"tenant_id": budget.TenantID, "threshold": alert.Threshold})
+
+	if budget.NotifyEmail {
+		var tenant models.Tenant
+		emails, err := billingContacts(bs.db, budget.TenantID)
+		if err == nil {
+			err = bs.db.Select("name").First(&tenant, "id = ?", budget.TenantID).Error
+		}
+		if err != nil {
+			log.WithError(err).Error("Failed to load budget alert recipients")
+		} else if len(emails) > 0 {
+			if err := bs.mailService.SendTemplate(emails, MailTemplateBudgetThreshold, map[string]interface{}{
+				"TenantName": tenant.Name,
+				"Threshold":  alert.Threshold,
+				"Spend":      formatMoney(alert.Spend, alert.Currency),
+				"Amount":     formatMoney(alert.Amount, alert.Currency),
+				"PeriodEnd":  formatDate(alert.PeriodEnd),
+				"Capped":     alert.Capped,
+				"CapAction":  string(alert.CapAction),
+				"Link":       bs.mailService.AppLink("/billing/usage"),
+			}); err != nil {
+				log.WithError(err).Error("Failed to send budget alert")
+			}
+		}
+	}
+
+	if budget.WebhookURL != "" {
+		if err := bs.postWebhook(budget, alert); err != nil {
+			log.WithError(err).Warn("Failed to deliver budget webhook")
+		}
+	}
+}
+
+func (bs *BudgetService) postWebhook(budget *models.TenantBudget, alert *BudgetAlert) error {
+	// Budgets saved before URLs were restricted may still hold internal ones
+	if err := validateWebhookURL(budget.WebhookURL); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, budget.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if budget.WebhookSecret != "" {
+		req.Header.Set(BudgetSignatureHeader, payments.Sign(payload, budget.WebhookSecret, alert.SentAt))
+	}
+
+	resp, err := bs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// meteredSpend returns the tenant's current billing period and the usage
+// charges accrued in it so far. Monthly subscriptions use their period;
+// other tenants use the calendar month with the plan's monthly allowance.
+func meteredSpend(tx *gorm.DB, tenantID uuid.UUID, now time.Time) (time.Time, time.Time, float64, error) {
+	var tenant models.Tenant
+	if err := tx.Select("id", "plan").First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	planID := tenant.Plan
+
+	var subscription models.Subscription
+	if err := tx.Where("tenant_id = ?", tenantID).Order("created_at DESC").First(&subscription).Error; err == nil {
+		planID = subscription.Plan
+		if subscription.BillingCycle != "yearly" && now.Before(subscription.CurrentPeriodEnd) {
+			start, end = subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd
+		}
+	}
+
+	plan, err := getPlan(tx, planID)
+	if err != nil {
+		return start, end, 0, err
+	}
+	lines, err := usageOverage(tx, tenantID, plan, "monthly", start, now)
+	if err != nil {
+		return start, end, 0, err
+	}
+
+	spend := 0.0
+	for _, line := range lines {
+		spend += line.Amount
+	}
+	return start, end, roundCents(spend), nil
+}
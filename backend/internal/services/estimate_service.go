@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// hoursPerMonth is the length of an average month (365 * 24 / 12), which
+// estimates assume a service runs for
+const hoursPerMonth = 730
+
+// ResourceEstimate is the monthly usage of one billed resource
+type ResourceEstimate struct {
+	Unit      string  `json:"unit"`
+	Hours     float64 `json:"hours"`    // used by the estimated service
+	Included  float64 `json:"included"` // included in the plan price
+	Existing  float64 `json:"existing"` // used by the tenant's other running services
+	Overage   float64 `json:"overage"`  // billable hours the service adds
+	UnitPrice float64 `json:"unit_price"`
+	Cost      float64 `json:"cost"`
+}
+
+// ServiceEstimate is what running a service for a month would cost the
+// tenant. Included usage is consumed by the tenant's running services first,
+// so Cost is only the overage this service adds on top of them.
+type ServiceEstimate struct {
+	Plan        models.TenantPlan `json:"plan,omitempty"`
+	Currency    string            `json:"currency"`
+	Hours       int               `json:"hours"`
+	TaskGroups  map[string]int    `json:"task_groups"` // allocations per task group
+	Allocations int               `json:"allocations"`
+	Reserved    ResourceUsage     `json:"reserved"` // across all allocations
+	CPU         ResourceEstimate  `json:"cpu"`
+	Memory      ResourceEstimate  `json:"memory"`
+	// MonthlyCost is the usage charges of the service; TenantMonthlyTotal
+	// adds the plan price and the usage charges of the other services
+	MonthlyCost        float64 `json:"monthly_cost"`
+	PlanPrice          float64 `json:"plan_price"`
+	TenantMonthlyTotal float64 `json:"tenant_monthly_total"`
+}
+
+// EstimateService prices services before they are deployed, from their
+// resources, the task groups of their rendered job and the tenant's plan
+type EstimateService struct {
+	db           *gorm.DB
+	nomadService *NomadService
+	quotaService *QuotaService
+	config       *config.Config
+}
+
+func NewEstimateService(db *gorm.DB, nomadService *NomadService, quotaService *QuotaService, cfg *config.Config) *EstimateService {
+	return &EstimateService{
+		db:           db,
+		nomadService: nomadService,
+		quotaService: quotaService,
+		config:       cfg,
+	}
+}
+
+// EstimateRequest prices a service that does not exist yet
+func (es *EstimateService) EstimateRequest(ctx context.Context, tenantID *uuid.UUID, req *CreateServiceRequest) (*ServiceEstimate, error) {
+	return es.Estimate(ctx, tenantID, &models.Service{
+		Name:        req.Name,
+		Type:        req.Type,
+		Description: req.Description,
+		Config:      req.Config,
+	})
+}
+
+// Estimate prices running service for a month. Without a tenant only the
+// reserved resources are estimated.
+func (es *EstimateService) Estimate(ctx context.Context, tenantID *uuid.UUID, service *models.Service) (*ServiceEstimate, error) {
+	groups, err := es.nomadService.TaskGroupCounts(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every allocation reserves the service's resources, as metered
+	perAllocation := demand(models.ServiceConfig{Resources: service.Config.Resources})
+	estimate := &ServiceEstimate{
+		Currency:   es.config.SaaS.Currency,
+		Hours:      hoursPerMonth,
+		TaskGroups: groups,
+	}
+	for _, count := range groups {
+		estimate.Allocations += count
+	}
+	estimate.Reserved = ResourceUsage{
+		CPU:         perAllocation.CPU * estimate.Allocations,
+		Memory:      perAllocation.Memory * estimate.Allocations,
+		Disk:        perAllocation.Disk * estimate.Allocations,
+		Allocations: estimate.Allocations,
+	}
+	estimate.CPU = ResourceEstimate{Unit: "GHz·h", Hours: cpuHours(estimate.Reserved.CPU)}
+	estimate.Memory = ResourceEstimate{Unit: "GB·h", Hours: memoryHours(estimate.Reserved.Memory)}
+
+	if tenantID == nil {
+		return estimate, nil
+	}
+
+	tenant, err := es.quotaService.getTenant(*tenantID)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := getPlan(es.db, tenant.Plan)
+	if err != nil {
+		return nil, err
+	}
+	// Services already running keep running alongside this one
+	existing, err := es.quotaService.Usage(tenant.ID, service.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate.Plan = plan.ID
+	estimate.PlanPrice = plan.PricePerMonth
+	estimate.CPU.price(float64(plan.IncludedCPUHours), cpuHours(existing.CPU), plan.CPUHourPrice)
+	estimate.Memory.price(float64(plan.IncludedMemoryHours), memoryHours(existing.Memory), plan.MemoryHourPrice)
+
+	estimate.MonthlyCost = roundCents(estimate.CPU.Cost + estimate.Memory.Cost)
+	estimate.TenantMonthlyTotal = roundCents(plan.PricePerMonth +
+		overageCost(estimate.CPU.Existing+estimate.CPU.Hours, estimate.CPU.Included, estimate.CPU.UnitPrice) +
+		overageCost(estimate.Memory.Existing+estimate.Memory.Hours, estimate.Memory.Included, estimate.Memory.UnitPrice))
+	return estimate, nil
+}
+
+// PricedTemplate is a service template with the estimate of running it
+// with its default configuration
+type PricedTemplate struct {
+	models.ServiceTemplate
+	Estimate *ServiceEstimate `json:"estimate,omitempty"`
+}
+
+// EstimateTemplates prices each template. Templates that cannot be priced,
+// e.g. because Nomad cannot parse their job, are returned without estimate.
+func (es *EstimateService) EstimateTemplates(ctx context.Context, tenantID *uuid.UUID, templates []models.ServiceTemplate) []PricedTemplate {
+	priced := make([]PricedTemplate, 0, len(templates))
+	for _, template := range templates {
+		estimate, err := es.Estimate(ctx, tenantID, &models.Service{
+			Name:   template.Name,
+			Type:   template.Type,
+			Config: template.Config,
+		})
+		if err != nil {
+			logrus.WithContext(ctx).WithError(err).WithField("template", template.Name).Warn("Failed to estimate template")
+		}
+		priced = append(priced, PricedTemplate{ServiceTemplate: template, Estimate: estimate})
+	}
+	return priced
+}
+
+// price fills in the overage the estimated hours add to existing usage
+func (r *ResourceEstimate) price(included, existing, unitPrice float64) {
+	r.Included = included
+	r.Existing = existing
+	r.UnitPrice = unitPrice
+
+	// Only the part of the service's usage beyond what is left of the
+	// included hours is billed
+	before := existing - included
+	if before < 0 {
+		before = 0
+	}
+	after := existing + r.Hours - included
+	if after < 0 {
+		after = 0
+	}
+	r.Overage = roundCents(after - before)
+	r.Cost = roundCents(r.Overage * unitPrice)
+}
+
+// overageCost prices usage beyond included hours
+func overageCost(used, included, unitPrice float64) float64 {
+	if used <= included {
+		return 0
+	}
+	return roundCents(used-included) * unitPrice
+}
+
+// cpuHours converts a reservation in MHz to GHz·h per month
+func cpuHours(mhz int) float64 {
+	return roundCents(float64(mhz) / 1000 * hoursPerMonth)
+}
+
+// memoryHours converts a reservation in MB to GB·h per month
+func memoryHours(mb int) float64 {
+	return roundCents(float64(mb) / 1024 * hoursPerMonth)
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/database"
+	"nomad-services-api/internal/version"
+
+	"gorm.io/gorm"
+)
+
+// Component statuses in a HealthReport
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// healthCheckTimeout bounds each dependency check, so a hung dependency
+// fails readiness instead of the probe timing out
+const healthCheckTimeout = 2 * time.Second
+
+// reconcilerGrace is how many reconcile intervals may pass without a
+// finished pass before the reconciler counts as stuck. A single slow or
+// failed pass doesn't fail readiness.
+const reconcilerGrace = 3
+
+// ComponentHealth is the outcome of checking one dependency
+type ComponentHealth struct {
+	Status    string                 `json:"status"`
+	LatencyMS float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// HealthReport is the outcome of a readiness check. Its status is ok only
+// when every component's is.
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Version    string                     `json:"version"`
+	Commit     string                     `json:"commit"`
+	Components map[string]ComponentHealth `json:"components"`
+	CheckedAt  time.Time                  `json:"checked_at"`
+}
+
+// HealthService checks the dependencies the API needs to serve requests
+type HealthService struct {
+	db             *gorm.DB
+	nomadService   *NomadService
+	serviceManager *ServiceManager
+	config         *config.Config
+	startedAt      time.Time
+}
+
+func NewHealthService(db *gorm.DB, nomadService *NomadService, serviceManager *ServiceManager, cfg *config.Config) *HealthService {
+	return &HealthService{
+		db:             db,
+		nomadService:   nomadService,
+		serviceManager: serviceManager,
+		config:         cfg,
+		startedAt:      time.Now(),
+	}
+}
+
+// StartedAt returns when the server started
+func (hs *HealthService) StartedAt() time.Time {
+	return hs.startedAt
+}
+
+// Ready checks the database, its migrations, the Nomad leader and the
+// service status reconciler concurrently
+func (hs *HealthService) Ready(ctx context.Context) *HealthReport {
+	checks := map[string]func(context.Context) (map[string]interface{}, error){
+		"database":   hs.checkDatabase,
+		"migrations": hs.checkMigrations,
+		"nomad":      hs.checkNomad,
+		"reconciler": hs.checkReconciler,
+	}
+
+	report := &HealthReport{
+		Status:     HealthOK,
+		Version:    version.Version,
+		Commit:     version.Commit,
+		Components: make(map[string]ComponentHealth, len(checks)),
+		CheckedAt:  time.Now().UTC(),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) (map[string]interface{}, error)) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := check(ctx)
+			result := ComponentHealth{
+				Status:    HealthOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Details:   details,
+			}
+			if err != nil {
+				result.Status = HealthFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = result
+			if err != nil {
+				report.Status = HealthFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (hs *HealthService) checkDatabase(ctx context.Context) (map[string]interface{}, error) {
+	sqlDB, err := hs.db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database instance: %w", err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	stats := sqlDB.Stats()
+	return map[string]interface{}{
+		"open_connections": stats.OpenConnections,
+		"in_use":           stats.InUse,
+	}, nil
+}
+
+func (hs *HealthService) checkMigrations(ctx context.Context) (map[string]interface{}, error) {
+	missing, err := database.MissingTables(ctx, hs.db)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return map[string]interface{}{"missing_tables": missing},
+			fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	return nil, nil
+}
+
+func (hs *HealthService) checkNomad(ctx context.Context) (map[string]interface{}, error) {
+	leader, err := hs.nomadService.Leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"leader": leader}, nil
+}
+
+// checkReconciler fails when no reconcile pass has finished for
+// reconcilerGrace intervals. Before the first pass the time counts from
+// when the server started.
+func (hs *HealthService) checkReconciler(ctx context.Context) (map[string]interface{}, error) {
+	interval := hs.config.Nomad.ReconcileInterval
+	details := map[string]interface{}{"interval": interval.String()}
+
+	last := hs.serviceManager.LastReconciled()
+	since := hs.startedAt
+	if !last.IsZero() {
+		since = last
+		details["last_pass"] = last.UTC()
+	}
+
+	if lag := time.Since(since); lag > reconcilerGrace*interval {
+		return details, fmt.Errorf("no reconcile pass finished in %s", lag.Round(time.Second))
+	}
+	return details, nil
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvalidInvitation  = errors.New("invalid or expired invitation")
+)
+
+// InviteRequest represents an invitation to join a tenant
+type InviteRequest struct {
+	Email        string          `json:"email" binding:"required,email"`
+	Role         models.UserRole `json:"role"`
+	CustomRoleID *uuid.UUID      `json:"custom_role_id"`
+}
+
+// AcceptInvitationRequest accepts an invitation by creating a new account
+type AcceptInvitationRequest struct {
+	Token     string `json:"token" binding:"required"`
+	Username  string `json:"username" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// InvitationPreview is the public view of an invitation shown before accepting
+type InvitationPreview struct {
+	TenantName   string    `json:"tenant_name"`
+	Email        string    `json:"email"`
+	Role         string    `json:"role"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	ExistingUser bool      `json:"existing_user"`
+}
+
+type InvitationService struct {
+	db          *gorm.DB
+	config      *config.Config
+	mailService *MailService
+	authService *AuthService
+	rbacService *RBACService
+}
+
+func NewInvitationService(db *gorm.DB, cfg *config.Config, mailService *MailService, authService *AuthService, rbacService *RBACService) *InvitationService {
+	return &InvitationService{
+		db:          db,
+		config:      cfg,
+		mailService: mailService,
+		authService: authService,
+		rbacService: rbacService,
+	}
+}
+
+// Invite creates an invitation and emails it. The inviter can only grant
+// permissions they hold, and pending invitations count against the seat limit.
+func (is *InvitationService) Invite(tenantID uuid.UUID, req *InviteRequest, inviter *models.User) (*models.Invitation, error) {
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	role := req.Role
+	if role == "" {
+		role = models.UserRoleUser
+	}
+
+	if err := is.rbacService.CheckAssignable(tenantID, inviter, role, req.CustomRoleID); err != nil {
+		return nil, err
+	}
+
+	var tenant models.Tenant
+	if err := is.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, ErrTenantNotFound
+	}
+
+	var members int64
+	if err := is.db.Model(&models.TenantMembership{}).
+		Joins("JOIN users ON users.id = tenant_memberships.user_id").
+		Where("tenant_memberships.tenant_id = ? AND LOWER(users.email) = ?", tenantID, email).
+		Count(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to check membership: %w", err)
+	}
+	if members > 0 {
+		return nil, fmt.Errorf("%s is already a member of this tenant", email)
+	}
+
+	var pending int64
+	if err := is.pendingQuery(tenantID).Where("email = ?", email).Count(&pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to check invitations: %w", err)
+	}
+	if pending > 0 {
+		return nil, fmt.Errorf("%s already has a pending invitation", email)
+	}
+
+	if err := is.checkSeats(&tenant, 1); err != nil {
+		return nil, err
+	}
+
+	token, tokenHash, err := newInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &models.Invitation{
+		ID:           uuid.New(),
+		TenantID:     tenantID,
+		Email:        email,
+		Role:         role,
+		CustomRoleID: req.CustomRoleID,
+		TokenHash:    tokenHash,
+		Status:       models.InvitationStatusPending,
+		InvitedBy:    inviter.ID,
+		ExpiresAt:    time.Now().Add(is.config.Auth.InvitationDuration),
+	}
+	if err := is.db.Create(invitation).Error; err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	if err := is.send(invitation, &tenant, inviter, token); err != nil {
+		logrus.WithError(err).WithField("invitation_id", invitation.ID).Error("Failed to send invitation email")
+	}
+
+	return invitation, nil
+}
+
+// ListInvitations returns a tenant's invitations, newest first
+func (is *InvitationService) ListInvitations(tenantID uuid.UUID) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	if err := is.db.Where("tenant_id = ?", tenantID).Order("created_at DESC").Find(&invitations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+
+	now := time.Now()
+	for i := range invitations {
+		if invitations[i].Status == models.InvitationStatusPending && now.After(invitations[i].ExpiresAt) {
+			invitations[i].Status = models.InvitationStatusExpired
+		}
+	}
+	return invitations, nil
+}
+
+// Resend issues a new token for a pending or expired invitation, extends its
+// expiry and emails it again. The previous link stops working.
+func (is *InvitationService) Resend(tenantID, invitationID uuid.UUID, inviter *models.User) (*models.Invitation, error) {
+	invitation, err := is.getInvitation(tenantID, invitationID)
+	if err != nil {
+		return nil, err
+	}
+	if invitation.Status != models.InvitationStatusPending {
+		return nil, fmt.Errorf("invitation is %s", invitation.Status)
+	}
+
+	var tenant models.Tenant
+	if err := is.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, ErrTenantNotFound
+	}
+
+	// An expired invitation no longer holds a seat, so it needs one again
+	if time.Now().After(invitation.ExpiresAt) {
+		if err := is.checkSeats(&tenant, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	token, tokenHash, err := newInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.TokenHash = tokenHash
+	invitation.ExpiresAt = time.Now().Add(is.config.Auth.InvitationDuration)
+	if err := is.db.Save(invitation).Error; err != nil {
+		return nil, fmt.Errorf("failed to update invitation: %w", err)
+	}
+
+	if err := is.send(invitation, &tenant, inviter, token); err != nil {
+		return nil, fmt.Errorf("failed to send invitation email: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// Revoke cancels a pending invitation
+func (is *InvitationService) Revoke(tenantID, invitationID uuid.UUID) error {
+	invitation, err := is.getInvitation(tenantID, invitationID)
+	if err != nil {
+		return err
+	}
+	if invitation.Status != models.InvitationStatusPending {
+		return fmt.Errorf("invitation is %s", invitation.Status)
+	}
+
+	return is.db.Model(invitation).Update("status", models.InvitationStatusRevoked).Error
+}
+
+// Preview returns the public details of a pending invitation
+func (is *InvitationService) Preview(token string) (*InvitationPreview, error) {
+	invitation, err := is.findPending(is.db, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenant models.Tenant
+	if err := is.db.First(&tenant, "id = ?", invitation.TenantID).Error; err != nil {
+		return nil, ErrInvalidInvitation
+	}
+
+	var users int64
+	is.db.Model(&models.User{}).Where("LOWER(email) = ?", invitation.Email).Count(&users)
+
+	return &InvitationPreview{
+		TenantName:   tenant.Name,
+		Email:        invitation.Email,
+		Role:         string(invitation.Role),
+		ExpiresAt:    invitation.ExpiresAt,
+		ExistingUser: users > 0,
+	}, nil
+}
+
+// AcceptWithSignup accepts an invitation by creating a new account for the
+// invited address. The address counts as verified since the link was sent to it.
+func (is *InvitationService) AcceptWithSignup(req *AcceptInvitationRequest) (*models.User, error) {
+	if is.config.SaaS.SignupMode == config.SignupModeDisabled {
+		return nil, fmt.Errorf("signup is disabled")
+	}
+
+	var user *models.User
+	err := is.db.Transaction(func(tx *gorm.DB) error {
+		invitation, err := is.findPending(tx.Clauses(clause.Locking{Strength: "UPDATE"}), req.Token)
+		if err != nil {
+			return err
+		}
+
+		if _, err := is.authService.userService.GetUserByEmail(invitation.Email); err == nil {
+			return fmt.Errorf("an account already exists for %s; log in to accept the invitation", invitation.Email)
+		}
+
+		user, err = is.authService.newUser(&RegisterRequest{
+			Username:  req.Username,
+			Email:     invitation.Email,
+			Password:  req.Password,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+		})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		membership, err := is.join(tx, invitation, user.ID)
+		if err != nil {
+			return err
+		}
+		ApplyMembership(user, membership)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// AcceptAsUser accepts an invitation for an existing, logged-in user whose
+// email matches the invitation. The user keeps their other memberships and
+// can switch to the new tenant.
+func (is *InvitationService) AcceptAsUser(token string, user *models.User) (*models.TenantMembership, error) {
+	if user.Role == models.UserRoleAdmin {
+		return nil, fmt.Errorf("system admins cannot join tenants through invitations")
+	}
+
+	var membership *models.TenantMembership
+	err := is.db.Transaction(func(tx *gorm.DB) error {
+		invitation, err := is.findPending(tx.Clauses(clause.Locking{Strength: "UPDATE"}), token)
+		if err != nil {
+			return err
+		}
+
+		if !strings.EqualFold(invitation.Email, user.Email) {
+			return fmt.Errorf("this invitation was sent to a different email address")
+		}
+
+		var existing int64
+		if err := tx.Model(&models.TenantMembership{}).
+			Where("user_id = ? AND tenant_id = ?", user.ID, invitation.TenantID).
+			Count(&existing).Error; err != nil {
+			return fmt.Errorf("failed to check membership: %w", err)
+		}
+		if existing > 0 {
+			return fmt.Errorf("you are already a member of this tenant")
+		}
+
+		membership, err = is.join(tx, invitation, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return membership, nil
+}
+
+// join adds the invited user to the tenant and marks the invitation accepted
+func (is *InvitationService) join(tx *gorm.DB, invitation *models.Invitation, userID uuid.UUID) (*models.TenantMembership, error) {
+	var tenant models.Tenant
+	if err := tx.First(&tenant, "id = ?", invitation.TenantID).Error; err != nil {
+		return nil, ErrInvalidInvitation
+	}
+	if !tenant.IsActive {
+		return nil, fmt.Errorf("tenant is inactive")
+	}
+
+	plan, err := getPlan(tx, tenant.Plan)
+	if err != nil {
+		return nil, err
+	}
+
+	// The accepted invitation already held a seat; only members count here in
+	// case the plan changed since the invitation was sent
+	if limit := plan.MaxSeats; limit > 0 {
+		var members int64
+		if err := tx.Model(&models.TenantMembership{}).Where("tenant_id = ? AND user_id <> ?", tenant.ID, userID).
+			Count(&members).Error; err != nil {
+			return nil, fmt.Errorf("failed to count members: %w", err)
+		}
+		if int(members) >= limit {
+			return nil, fmt.Errorf("tenant has reached its seat limit (%d)", limit)
+		}
+	}
+
+	membership := &models.TenantMembership{
+		UserID:       userID,
+		TenantID:     tenant.ID,
+		Role:         invitation.Role,
+		CustomRoleID: invitation.CustomRoleID,
+	}
+	if err := addMembership(tx, membership); err != nil {
+		return nil, err
+	}
+	membership.Tenant = &tenant
+
+	now := time.Now()
+	if err := tx.Model(invitation).Updates(map[string]interface{}{
+		"status":      models.InvitationStatusAccepted,
+		"accepted_at": now,
+		"accepted_by": userID,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to accept invitation: %w", err)
+	}
+	return membership, nil
+}
+
+// checkSeats ensures the tenant has room for additional members under its
+// plan's seat limit. Zero means unlimited.
+func (is *InvitationService) checkSeats(tenant *models.Tenant, additional int) error {
+	plan, err := getPlan(is.db, tenant.Plan)
+	if err != nil {
+		return err
+	}
+	limit := plan.MaxSeats
+	if limit == 0 {
+		return nil
+	}
+
+	seats, err := countSeats(is.db, tenant.ID)
+	if err != nil {
+		return err
+	}
+
+	if seats+additional > limit {
+		return fmt.Errorf("tenant has reached its seat limit (%d) for the %s plan", limit, tenant.Plan)
+	}
+	return nil
+}
+
+func (is *InvitationService) pendingQuery(tenantID uuid.UUID) *gorm.DB {
+	return pendingInvitations(is.db, tenantID)
+}
+
+// countSeats returns the seats a tenant uses: its members plus pending
+// invitations
+func countSeats(db *gorm.DB, tenantID uuid.UUID) (int, error) {
+	var members, pending int64
+	if err := db.Model(&models.TenantMembership{}).Where("tenant_id = ?", tenantID).Count(&members).Error; err != nil {
+		return 0, fmt.Errorf("failed to count members: %w", err)
+	}
+	if err := pendingInvitations(db, tenantID).Count(&pending).Error; err != nil {
+		return 0, fmt.Errorf("failed to count invitations: %w", err)
+	}
+	return int(members + pending), nil
+}
+
+func pendingInvitations(db *gorm.DB, tenantID uuid.UUID) *gorm.DB {
+	return db.Model(&models.Invitation{}).
+		Where("tenant_id = ? AND status = ? AND expires_at > ?", tenantID, models.InvitationStatusPending, time.Now())
+}
+
+func (is *InvitationService) getInvitation(tenantID, invitationID uuid.UUID) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := is.db.Where("id = ? AND tenant_id = ?", invitationID, tenantID).First(&invitation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	return &invitation, nil
+}
+
+func (is *InvitationService) findPending(db *gorm.DB, token string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := db.Where("token_hash = ? AND status = ?", hashUserToken(token), models.InvitationStatusPending).
+		First(&invitation).Error; err != nil {
+		return nil, ErrInvalidInvitation
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrInvalidInvitation
+	}
+	return &invitation, nil
+}
+
+func (is *InvitationService) send(invitation *models.Invitation, tenant *models.Tenant, inviter *models.User, token string) error {
+	return is.mailService.SendTemplate([]string{invitation.Email}, MailTemplateInvitation, map[string]interface{}{
+		"InviterName": displayName(inviter),
+		"TenantName":  tenant.Name,
+		"Link":        is.mailService.AppLink("/auth/accept-invitation?token=" + token),
+		"ExpiresIn":   is.config.Auth.InvitationDuration.String(),
+	})
+}
+
+func newInvitationToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate invitation token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	return token, hashUserToken(token), nil
+}
@@ -0,0 +1,574 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"strings"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvoiceNotFound is returned for invoices that don't exist or belong to
+// another tenant
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// InvoiceLineRequest adds a credit or adjustment to an invoice. Credits are
+// given as positive amounts and reduce the invoice; adjustments may be
+// negative.
+type InvoiceLineRequest struct {
+	Type        models.InvoiceLineType `json:"type" binding:"required"`
+	Description string                 `json:"description" binding:"required"`
+	Amount      float64                `json:"amount" binding:"required"`
+}
+
+// InvoiceService bills tenants for their subscription and for metered usage
+// beyond what their plan includes.
+//
+// An invoice is generated as a draft whenever a subscription period renews
+// or a canceled subscription ends, if billing is enabled. Drafts are issued
+// (opened) after SAAS_INVOICE_FINALIZE_DELAY so admins can add credits
+// first, and are paid when a payment is recorded for the subscription.
+type InvoiceService struct {
+	db           *gorm.DB
+	auditService *AuditService
+	mailService  *MailService
+	config       *config.Config
+	collect      func(invoice *models.Invoice)
+}
+
+func NewInvoiceService(db *gorm.DB, auditService *AuditService, mailService *MailService, cfg *config.Config) *InvoiceService {
+	return &InvoiceService{
+		db:           db,
+		auditService: auditService,
+		mailService:  mailService,
+		config:       cfg,
+	}
+}
+
+// SetCollector sets the function that collects payment for invoices when
+// they are issued
+func (is *InvoiceService) SetCollector(collect func(invoice *models.Invoice)) {
+	is.collect = collect
+}
+
+// generate creates a draft invoice for subscription with usage overage
+// between usageStart and usageEnd and, if chargePeriod is set, the price of
+// the current period. No invoice is created when there is nothing to bill.
+func (is *InvoiceService) generate(tx *gorm.DB, tenant *models.Tenant, subscription *models.Subscription, usageStart, usageEnd time.Time, chargePeriod bool) (*models.Invoice, error) {
+	if !is.config.SaaS.BillingEnabled {
+		return nil, nil
+	}
+
+	plan, err := getPlan(tx, subscription.Plan)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []models.InvoiceLine
+	if amount := periodAmount(subscription); chargePeriod && amount > 0 {
+		lines = append(lines, models.InvoiceLine{
+			Type: models.InvoiceLineSubscription,
+			Description: fmt.Sprintf("%s plan, %s – %s", plan.Name,
+				formatDate(subscription.CurrentPeriodStart), formatDate(subscription.CurrentPeriodEnd)),
+			Quantity:  1,
+			UnitPrice: amount,
+			Amount:    amount,
+		})
+	}
+
+	overage, err := usageOverage(tx, tenant.ID, plan, subscription.BillingCycle, usageStart, usageEnd)
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines, overage...)
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	number, err := is.nextNumber(tx, usageEnd.Year())
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := &models.Invoice{
+		ID:             uuid.New(),
+		TenantID:       tenant.ID,
+		SubscriptionID: &subscription.ID,
+		Number:         number,
+		Status:         models.InvoiceStatusDraft,
+		Currency:       is.config.SaaS.Currency,
+		PeriodStart:    usageStart,
+		PeriodEnd:      usageEnd,
+		TaxName:        tenant.TaxName,
+		TaxRate:        tenant.TaxRate,
+		Lines:          lines,
+	}
+	if chargePeriod {
+		invoice.PeriodEnd = subscription.CurrentPeriodEnd
+	}
+	if err := computeTotals(invoice); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(invoice).Error; err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %w", err)
+	}
+	return invoice, nil
+}
+
+// ListInvoices returns a tenant's invoices, newest first. Drafts are only
+// included for admins.
+func (is *InvoiceService) ListInvoices(tenantID uuid.UUID, includeDrafts bool) ([]models.Invoice, error) {
+	query := is.db.Preload("Lines", invoiceLineOrder).Where("tenant_id = ?", tenantID)
+	if !includeDrafts {
+		query = query.Where("status <> ?", models.InvoiceStatusDraft)
+	}
+
+	var invoices []models.Invoice
+	if err := query.Order("created_at DESC").Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("failed to list invoices: %w", err)
+	}
+	return invoices, nil
+}
+
+// GetInvoice returns an invoice. A non-nil tenantID restricts the lookup to
+// that tenant's issued invoices.
+func (is *InvoiceService) GetInvoice(tenantID *uuid.UUID, id uuid.UUID) (*models.Invoice, error) {
+	return is.getInvoice(is.db, tenantID, id)
+}
+
+func (is *InvoiceService) getInvoice(db *gorm.DB, tenantID *uuid.UUID, id uuid.UUID) (*models.Invoice, error) {
+	query := db.Preload("Lines", invoiceLineOrder).Where("id = ?", id)
+	if tenantID != nil {
+		query = query.Where("tenant_id = ? AND status <> ?", *tenantID, models.InvoiceStatusDraft)
+	}
+
+	var invoice models.Invoice
+	if err := query.First(&invoice).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, fmt.Errorf("failed to get invoice: %w", err)
+	}
+	return &invoice, nil
+}
+
+// AddLine adds a credit or adjustment to a draft or open invoice
+func (is *InvoiceService) AddLine(id uuid.UUID, req *InvoiceLineRequest, actorID uuid.UUID, client ClientInfo) (*models.Invoice, error) {
+	description := strings.TrimSpace(req.Description)
+	amount := roundCents(req.Amount)
+	switch req.Type {
+	case models.InvoiceLineCredit:
+		if amount <= 0 {
+			return nil, fmt.Errorf("credit amount must be positive")
+		}
+		amount = -amount
+	case models.InvoiceLineAdjustment:
+		if amount == 0 {
+			return nil, fmt.Errorf("adjustment amount cannot be zero")
+		}
+	default:
+		return nil, fmt.Errorf("line type must be credit or adjustment")
+	}
+	if description == "" {
+		return nil, fmt.Errorf("description is required")
+	}
+
+	line := models.InvoiceLine{
+		ID:          uuid.New(),
+		Type:        req.Type,
+		Description: description,
+		Quantity:    1,
+		UnitPrice:   amount,
+		Amount:      amount,
+	}
+	invoice, err := is.update(is.db, id, func(tx *gorm.DB, invoice *models.Invoice) error {
+		if invoice.Status != models.InvoiceStatusDraft && invoice.Status != models.InvoiceStatusOpen {
+			return fmt.Errorf("invoice is %s", invoice.Status)
+		}
+
+		line.InvoiceID = invoice.ID
+		if err := tx.Create(&line).Error; err != nil {
+			return fmt.Errorf("failed to add invoice line: %w", err)
+		}
+		invoice.Lines = append(invoice.Lines, line)
+		return computeTotals(invoice)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	is.audit(&actorID, invoice, AuditActionInvoiceAdjusted, map[string]interface{}{
+		"type":        line.Type,
+		"description": line.Description,
+		"amount":      line.Amount,
+	}, client)
+	return invoice, nil
+}
+
+// Finalize issues a draft invoice. Invoices with nothing to pay are marked
+// paid right away.
+func (is *InvoiceService) Finalize(id uuid.UUID, actorID *uuid.UUID, client ClientInfo) (*models.Invoice, error) {
+	invoice, err := is.update(is.db, id, func(tx *gorm.DB, invoice *models.Invoice) error {
+		if invoice.Status != models.InvoiceStatusDraft {
+			return fmt.Errorf("invoice is %s", invoice.Status)
+		}
+
+		now := time.Now()
+		due := now.Add(is.config.SaaS.PaymentGracePeriod)
+		invoice.Status = models.InvoiceStatusOpen
+		invoice.IssuedAt = &now
+		invoice.DueAt = &due
+		if invoice.Total == 0 {
+			invoice.Status = models.InvoiceStatusPaid
+			invoice.PaidAt = &now
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	is.audit(actorID, invoice, AuditActionInvoiceIssued, nil, client)
+	is.notify(invoice)
+	if is.collect != nil && invoice.Status == models.InvoiceStatusOpen {
+		is.collect(invoice)
+	}
+	return invoice, nil
+}
+
+// FinalizeDue issues the drafts that have been open for adjustments long
+// enough by now
+func (is *InvoiceService) FinalizeDue(now time.Time) {
+	var ids []uuid.UUID
+	if err := is.db.Model(&models.Invoice{}).
+		Where("status = ? AND created_at <= ?", models.InvoiceStatusDraft, now.Add(-is.config.SaaS.InvoiceFinalizeDelay)).
+		Pluck("id", &ids).Error; err != nil {
+		logrus.WithError(err).Error("Failed to load draft invoices")
+		return
+	}
+
+	for _, id := range ids {
+		if _, err := is.Finalize(id, nil, ClientInfo{}); err != nil {
+			logrus.WithError(err).WithField("invoice_id", id).Error("Failed to finalize invoice")
+		}
+	}
+}
+
+// MarkPaid settles a single open invoice without touching the subscription.
+// paymentID is the provider's charge, if it was paid through one.
+func (is *InvoiceService) MarkPaid(id uuid.UUID, paymentID string, actorID *uuid.UUID, client ClientInfo) (*models.Invoice, error) {
+	invoice, err := is.markPaid(is.db, id, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	is.auditPaid(actorID, invoice, client)
+	return invoice, nil
+}
+
+// markPaid marks an open invoice paid with db, which may be a transaction.
+// The caller records it with auditPaid once the change is committed.
+func (is *InvoiceService) markPaid(db *gorm.DB, id uuid.UUID, paymentID string) (*models.Invoice, error) {
+	return is.update(db, id, func(tx *gorm.DB, invoice *models.Invoice) error {
+		if invoice.Status != models.InvoiceStatusOpen {
+			return fmt.Errorf("invoice is %s", invoice.Status)
+		}
+
+		now := time.Now()
+		invoice.Status = models.InvoiceStatusPaid
+		invoice.PaidAt = &now
+		if paymentID != "" {
+			invoice.PaymentID = paymentID
+		}
+		return nil
+	})
+}
+
+func (is *InvoiceService) auditPaid(actorID *uuid.UUID, invoice *models.Invoice, client ClientInfo) {
+	is.audit(actorID, invoice, AuditActionInvoicePaid, map[string]interface{}{
+		"payment_id": invoice.PaymentID,
+	}, client)
+}
+
+// Void cancels a draft or open invoice. Its number stays reserved.
+func (is *InvoiceService) Void(id, actorID uuid.UUID, client ClientInfo) (*models.Invoice, error) {
+	invoice, err := is.update(is.db, id, func(tx *gorm.DB, invoice *models.Invoice) error {
+		if invoice.Status != models.InvoiceStatusDraft && invoice.Status != models.InvoiceStatusOpen {
+			return fmt.Errorf("invoice is %s", invoice.Status)
+		}
+
+		now := time.Now()
+		invoice.Status = models.InvoiceStatusVoid
+		invoice.VoidedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	is.audit(&actorID, invoice, AuditActionInvoiceVoided, nil, client)
+	return invoice, nil
+}
+
+// RenderHTML renders an invoice as a printable HTML document
+func (is *InvoiceService) RenderHTML(invoice *models.Invoice) ([]byte, error) {
+	var tenant models.Tenant
+	if err := is.db.First(&tenant, "id = ?", invoice.TenantID).Error; err != nil {
+		return nil, ErrTenantNotFound
+	}
+
+	var buf bytes.Buffer
+	if err := invoiceTemplate.Execute(&buf, map[string]interface{}{
+		"Invoice": invoice,
+		"Tenant":  &tenant,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to render invoice: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// update locks an invoice, applies change and saves it
+func (is *InvoiceService) update(db *gorm.DB, id uuid.UUID, change func(tx *gorm.DB, invoice *models.Invoice) error) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&invoice, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvoiceNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get invoice: %w", err)
+		}
+		if err := invoiceLineOrder(tx).Where("invoice_id = ?", id).Find(&invoice.Lines).Error; err != nil {
+			return fmt.Errorf("failed to load invoice lines: %w", err)
+		}
+
+		if err := change(tx, &invoice); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(&invoice).Error; err != nil {
+			return fmt.Errorf("failed to update invoice: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// nextNumber reserves the next invoice number of year
+func (is *InvoiceService) nextNumber(tx *gorm.DB, year int) (string, error) {
+	counter := models.InvoiceCounter{Year: year, Last: 1}
+	if err := tx.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "year"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"last": gorm.Expr("invoice_counters.last + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "last"}}},
+	).Create(&counter).Error; err != nil {
+		return "", fmt.Errorf("failed to number invoice: %w", err)
+	}
+	return fmt.Sprintf("%s-%d-%05d", is.config.SaaS.InvoicePrefix, year, counter.Last), nil
+}
+
+func (is *InvoiceService) audit(userID *uuid.UUID, invoice *models.Invoice, action string, details map[string]interface{}, client ClientInfo) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["invoice_id"] = invoice.ID
+	details["number"] = invoice.Number
+	details["status"] = invoice.Status
+	details["total"] = invoice.Total
+
+	is.auditService.Record(userID, &invoice.TenantID, action, "invoice", details, client)
+}
+
+// notify emails an issued invoice to the tenant admins
+func (is *InvoiceService) notify(invoice *models.Invoice) {
+	var tenant models.Tenant
+	if err := is.db.First(&tenant, "id = ?", invoice.TenantID).Error; err != nil {
+		logrus.WithError(err).WithField("tenant_id", invoice.TenantID).Error("Failed to load tenant for invoice")
+		return
+	}
+
+	emails, err := billingContacts(is.db, tenant.ID)
+	if err != nil {
+		logrus.WithError(err).WithField("tenant_id", tenant.ID).Error("Failed to load billing contacts")
+		return
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	if err := is.mailService.SendTemplate(emails, MailTemplateInvoiceIssued, map[string]interface{}{
+		"TenantName": tenant.Name,
+		"Number":     invoice.Number,
+		"Amount":     formatMoney(invoice.Total, invoice.Currency),
+		"Status":     invoice.Status,
+		"Link":       is.mailService.AppLink("/billing/invoices/" + invoice.ID.String()),
+	}); err != nil {
+		logrus.WithError(err).WithField("invoice_id", invoice.ID).Error("Failed to send invoice")
+	}
+}
+
+// markInvoicesPaid settles a tenant's outstanding invoices, including drafts
+// that were not issued yet
+func markInvoicesPaid(tx *gorm.DB, tenantID uuid.UUID, now time.Time) error {
+	if err := tx.Model(&models.Invoice{}).
+		Where("tenant_id = ? AND status IN (?)", tenantID,
+			[]models.InvoiceStatus{models.InvoiceStatusDraft, models.InvoiceStatusOpen}).
+		Updates(map[string]interface{}{
+			"status":    models.InvoiceStatusPaid,
+			"paid_at":   now,
+			"issued_at": gorm.Expr("COALESCE(issued_at, ?)", now),
+		}).Error; err != nil {
+		return fmt.Errorf("failed to update invoices: %w", err)
+	}
+	return nil
+}
+
+// usageOverage returns invoice lines for the CPU and memory a tenant used
+// between start and end beyond what plan includes. The allowance is per
+// month.
+func usageOverage(tx *gorm.DB, tenantID uuid.UUID, plan *models.Plan, cycle string, start, end time.Time) ([]models.InvoiceLine, error) {
+	if plan.CPUHourPrice == 0 && plan.MemoryHourPrice == 0 {
+		return nil, nil
+	}
+
+	var used UsageTotals
+	if err := tx.Model(&models.UsageRecord{}).
+		Select("COALESCE(SUM(cpu_seconds), 0) AS cpu_seconds, COALESCE(SUM(memory_seconds), 0) AS memory_seconds").
+		Where("tenant_id = ? AND granularity = ? AND bucket_start >= ? AND bucket_start < ?",
+			tenantID, models.UsageGranularityHour, start, end).
+		Scan(&used).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum usage: %w", err)
+	}
+
+	months := 1
+	if cycle == "yearly" {
+		months = 12
+	}
+
+	var lines []models.InvoiceLine
+	for _, resource := range []struct {
+		name     string
+		unit     string
+		used     float64
+		included int
+		price    float64
+	}{
+		{"CPU", "GHz·h", float64(used.CPUSeconds) / 1000 / 3600, plan.IncludedCPUHours, plan.CPUHourPrice},
+		{"Memory", "GB·h", float64(used.MemorySeconds) / 1024 / 3600, plan.IncludedMemoryHours, plan.MemoryHourPrice},
+	} {
+		over := roundCents(resource.used - float64(resource.included*months))
+		amount := roundCents(over * resource.price)
+		if over <= 0 || amount == 0 {
+			continue
+		}
+		lines = append(lines, models.InvoiceLine{
+			Type: models.InvoiceLineUsage,
+			Description: fmt.Sprintf("%s usage beyond %d %s included, %s – %s", resource.name,
+				resource.included*months, resource.unit, formatDate(start), formatDate(end)),
+			Quantity:  over,
+			Unit:      resource.unit,
+			UnitPrice: resource.price,
+			Amount:    amount,
+		})
+	}
+	return lines, nil
+}
+
+// computeTotals sums an invoice's lines and applies its tax rate. Credits
+// cannot take an invoice below zero.
+func computeTotals(invoice *models.Invoice) error {
+	subtotal := 0.0
+	for _, line := range invoice.Lines {
+		subtotal += line.Amount
+	}
+	subtotal = roundCents(subtotal)
+	if subtotal < 0 {
+		return fmt.Errorf("credits cannot exceed the invoice subtotal")
+	}
+
+	invoice.Subtotal = subtotal
+	invoice.Tax = roundCents(subtotal * invoice.TaxRate / 100)
+	invoice.Total = roundCents(invoice.Subtotal + invoice.Tax)
+	return nil
+}
+
+// billingContacts returns the emails of a tenant's active admins
+func billingContacts(db *gorm.DB, tenantID uuid.UUID) ([]string, error) {
+	var emails []string
+	err := db.Model(&models.User{}).
+		Joins("JOIN tenant_memberships ON tenant_memberships.user_id = users.id").
+		Where("tenant_memberships.tenant_id = ? AND tenant_memberships.role = ? AND users.is_active = ?",
+			tenantID, models.UserRoleTenantAdmin, true).
+		Pluck("users.email", &emails).Error
+	return emails, err
+}
+
+func invoiceLineOrder(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at, id")
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func formatMoney(amount float64, currency string) string {
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
+
+var invoiceTemplate = htmltemplate.Must(htmltemplate.New("invoice").Funcs(htmltemplate.FuncMap{
+	"money": formatMoney,
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return formatDate(*t)
+	},
+	"day": formatDate,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Invoice.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { width: 100%; border-collapse: collapse; margin-top: 1.5em; }
+th, td { padding: 0.4em; border-bottom: 1px solid #ddd; text-align: left; }
+td.amount, th.amount { text-align: right; }
+.status { text-transform: uppercase; font-weight: bold; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Invoice.Number}}</h1>
+<p class="status">{{.Invoice.Status}}</p>
+<p>
+<strong>{{.Tenant.Name}}</strong><br>
+Period: {{day .Invoice.PeriodStart}} – {{day .Invoice.PeriodEnd}}<br>
+{{with .Invoice.IssuedAt}}Issued: {{date .}}<br>{{end}}
+{{with .Invoice.DueAt}}Due: {{date .}}<br>{{end}}
+{{with .Invoice.PaidAt}}Paid: {{date .}}<br>{{end}}
+</p>
+<table>
+<tr><th>Description</th><th class="amount">Quantity</th><th class="amount">Unit price</th><th class="amount">Amount</th></tr>
+{{range .Invoice.Lines}}<tr><td>{{.Description}}</td><td class="amount">{{.Quantity}} {{.Unit}}</td><td class="amount">{{printf "%.4g" .UnitPrice}}</td><td class="amount">{{money .Amount $.Invoice.Currency}}</td></tr>
+{{end}}<tr><td colspan="3" class="amount">Subtotal</td><td class="amount">{{money .Invoice.Subtotal .Invoice.Currency}}</td></tr>
+<tr><td colspan="3" class="amount">{{if .Invoice.TaxName}}{{.Invoice.TaxName}}{{else}}Tax{{end}} ({{.Invoice.TaxRate}}%)</td><td class="amount">{{money .Invoice.Tax .Invoice.Currency}}</td></tr>
+<tr><th colspan="3" class="amount">Total</th><th class="amount">{{money .Invoice.Total .Invoice.Currency}}</th></tr>
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LoginThrottledError is returned when a login attempt is rejected before the
+// password is checked
+type LoginThrottledError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return e.Message
+}
+
+// maxThrottleEntries bounds memory use. When a new key arrives at the limit
+// the key that failed least recently is evicted, so a flood of fresh
+// usernames or IPs cannot grow the throttle without bound.
+const maxThrottleEntries = 10000
+
+type throttleEntry struct {
+	key          string
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// loginThrottle applies exponential backoff to failed logins per key
+// (username or client IP). State is per process; the persistent account
+// lockout lives on the user record.
+type loginThrottle struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // values are *throttleEntry
+	recent  *list.List               // most recently failed first
+	base    time.Duration
+	max     time.Duration
+}
+
+func newLoginThrottle(base, max time.Duration) *loginThrottle {
+	return &loginThrottle{
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+		base:    base,
+		max:     max,
+	}
+}
+
+// Wait returns how long the key must wait before the next attempt
+func (t *loginThrottle) Wait(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	element, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	entry := element.Value.(*throttleEntry)
+	if !now.Before(entry.blockedUntil) {
+		return 0
+	}
+	return entry.blockedUntil.Sub(now)
+}
+
+// Failure records a failed attempt and returns the new failure count
+func (t *loginThrottle) Failure(key string, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entry *throttleEntry
+	if element, ok := t.entries[key]; ok {
+		entry = element.Value.(*throttleEntry)
+		t.recent.MoveToFront(element)
+	} else {
+		if len(t.entries) >= maxThrottleEntries {
+			oldest := t.recent.Back()
+			t.recent.Remove(oldest)
+			delete(t.entries, oldest.Value.(*throttleEntry).key)
+		}
+		entry = &throttleEntry{key: key}
+		t.entries[key] = t.recent.PushFront(entry)
+	}
+
+	if now.Sub(entry.lastFailure) > t.max {
+		// Start over once the key has been quiet for longer than the maximum backoff
+		entry.failures = 0
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+	entry.blockedUntil = now.Add(t.backoff(entry.failures))
+	return entry.failures
+}
+
+// Reset clears the failures recorded for key
+func (t *loginThrottle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if element, ok := t.entries[key]; ok {
+		t.recent.Remove(element)
+		delete(t.entries, key)
+	}
+}
+
+// backoff returns base * 2^(failures-1), capped at max
+func (t *loginThrottle) backoff(failures int) time.Duration {
+	delay := t.base
+	for i := 1; i < failures && delay < t.max; i++ {
+		delay *= 2
+	}
+	if delay > t.max {
+		delay = t.max
+	}
+	return delay
+}
+
+func usernameThrottleKey(username string) string {
+	return fmt.Sprintf("user:%s", username)
+}
+
+func ipThrottleKey(ip string) string {
+	return fmt.Sprintf("ip:%s", ip)
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"nomad-services-api/internal/config"
+)
+
+// mailTemplate holds the subject, plain text and HTML variants of an email
+type mailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Mail template names
+const (
+	MailTemplateEmailVerification = "email_verification"
+	MailTemplatePasswordReset     = "password_reset"
+	MailTemplatePasswordChanged   = "password_changed"
+	MailTemplateInvitation        = "invitation"
+
+	MailTemplateSubscriptionRenewed         = "subscription_renewed"
+	MailTemplateSubscriptionPaymentDue      = "subscription_payment_due"
+	MailTemplateSubscriptionCancelScheduled = "subscription_cancel_scheduled"
+	MailTemplateTenantSuspended             = "tenant_suspended"
+	MailTemplateTenantReactivated           = "tenant_reactivated"
+	MailTemplateInvoiceIssued               = "invoice_issued"
+	MailTemplateBudgetThreshold             = "budget_threshold"
+)
+
+var mailTemplateSources = map[string][3]string{
+	MailTemplateEmailVerification: {
+		`Verify your email address`,
+		`Hi {{.Name}},
+
+Please confirm your email address by opening the link below:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you did not create an account, you can ignore this email.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>Please confirm your email address by clicking the link below:</p>
+<p><a href="{{.Link}}">Verify email address</a></p>
+<p>The link expires in {{.ExpiresIn}}. If you did not create an account, you can ignore this email.</p>
+`,
+	},
+	MailTemplatePasswordReset: {
+		`Reset your password`,
+		`Hi {{.Name}},
+
+We received a request to reset your password. Open the link below to choose a new one:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}} and can only be used once. If you did not request a reset, you can ignore this email.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>We received a request to reset your password. Click the link below to choose a new one:</p>
+<p><a href="{{.Link}}">Reset password</a></p>
+<p>The link expires in {{.ExpiresIn}} and can only be used once. If you did not request a reset, you can ignore this email.</p>
+`,
+	},
+	MailTemplatePasswordChanged: {
+		`Your password was changed`,
+		`Hi {{.Name}},
+
+The password for your account was just changed. If this was not you, reset your password immediately and contact your administrator.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>The password for your account was just changed. If this was not you, reset your password immediately and contact your administrator.</p>
+`,
+	},
+	MailTemplateInvitation: {
+		`{{.InviterName}} invited you to join {{.TenantName}}`,
+		`Hi,
+
+{{.InviterName}} invited you to join {{.TenantName}} on Nomad Services. Open the link below to accept:
+
+{{.Link}}
+
+The invitation expires in {{.ExpiresIn}}. If you were not expecting it, you can ignore this email.
+`,
+		`<p>Hi,</p>
+<p>{{.InviterName}} invited you to join <strong>{{.TenantName}}</strong> on Nomad Services. Click the link below to accept:</p>
+<p><a href="{{.Link}}">Accept invitation</a></p>
+<p>The invitation expires in {{.ExpiresIn}}. If you were not expecting it, you can ignore this email.</p>
+`,
+	},
+	MailTemplateSubscriptionRenewed: {
+		`Your {{.PlanName}} subscription for {{.TenantName}} was renewed`,
+		`Hi,
+
+The {{.PlanName}} subscription of {{.TenantName}} was renewed until {{.PeriodEnd}}.
+
+{{.Link}}
+`,
+		`<p>Hi,</p>
+<p>The {{.PlanName}} subscription of <strong>{{.TenantName}}</strong> was renewed until {{.PeriodEnd}}.</p>
+<p><a href="{{.Link}}">View subscription</a></p>
+`,
+	},
+	MailTemplateSubscriptionPaymentDue: {
+		`Payment due for {{.TenantName}}`,
+		`Hi,
+
+A payment of {{.Amount}} is due for the {{.PlanName}} subscription of {{.TenantName}}, which renewed until {{.PeriodEnd}}.
+
+If we do not receive it by {{.GraceEnd}}, the tenant will be suspended and its services stopped.
+
+{{.Link}}
+`,
+		`<p>Hi,</p>
+<p>A payment of {{.Amount}} is due for the {{.PlanName}} subscription of <strong>{{.TenantName}}</strong>, which renewed until {{.PeriodEnd}}.</p>
+<p>If we do not receive it by {{.GraceEnd}}, the tenant will be suspended and its services stopped.</p>
+<p><a href="{{.Link}}">Pay now</a></p>
+`,
+	},
+	MailTemplateSubscriptionCancelScheduled: {
+		`Your {{.PlanName}} subscription for {{.TenantName}} will end on {{.PeriodEnd}}`,
+		`Hi,
+
+The {{.PlanName}} subscription of {{.TenantName}} was canceled and ends on {{.PeriodEnd}}. After that the tenant is suspended and its services are stopped.
+
+You can resume the subscription until then:
+
+{{.Link}}
+`,
+		`<p>Hi,</p>
+<p>The {{.PlanName}} subscription of <strong>{{.TenantName}}</strong> was canceled and ends on {{.PeriodEnd}}. After that the tenant is suspended and its services are stopped.</p>
+<p><a href="{{.Link}}">Resume subscription</a></p>
+`,
+	},
+	MailTemplateTenantSuspended: {
+		`{{.TenantName}} has been suspended`,
+		`Hi,
+
+{{.TenantName}} has been suspended because {{.Reason}}. Its services were stopped but not deleted, and no services can be created or started.
+
+Renew the subscription to reactivate the tenant and restart its services:
+
+{{.Link}}
+`,
+		`<p>Hi,</p>
+<p><strong>{{.TenantName}}</strong> has been suspended because {{.Reason}}. Its services were stopped but not deleted, and no services can be created or started.</p>
+<p>Renew the subscription to reactivate the tenant and restart its services.</p>
+<p><a href="{{.Link}}">Renew subscription</a></p>
+`,
+	},
+	MailTemplateTenantReactivated: {
+		`{{.TenantName}} has been reactivated`,
+		`Hi,
+
+Thanks for your payment. {{.TenantName}} is active again on the {{.PlanName}} plan until {{.PeriodEnd}}, and its suspended services are being restarted.
+
+{{.Link}}
+`,
+		`<p>Hi,</p>
+<p>Thanks for your payment. <strong>{{.TenantName}}</strong> is active again on the {{.PlanName}} plan until {{.PeriodEnd}}, and its suspended services are being restarted.</p>
+<p><a href="{{.Link}}">Open dashboard</a></p>
+`,
+	},
+	MailTemplateInvoiceIssued: {
+		`Invoice {{.Number}} for {{.TenantName}}`,
+		`Hi,
+
+Invoice {{.Number}} of {{.Amount}} was issued to {{.TenantName}}{{if eq .Status "paid"}} and is already settled{{end}}.
+
+{{.Link}}
+`,
+		`<p>Hi,</p>
+<p>Invoice {{.Number}} of {{.Amount}} was issued to <strong>{{.TenantName}}</strong>{{if eq .Status "paid"}} and is already settled{{end}}.</p>
+<p><a href="{{.Link}}">View invoice</a></p>
+`,
+	},
+	MailTemplateBudgetThreshold: {
+		`{{.TenantName}} reached {{.Threshold}}% of its budget`,
+		`Hi,
+
+Usage charges of {{.TenantName}} are {{.Spend}} this billing period, {{.Threshold}}% of the {{.Amount}} budget. The period ends on {{.PeriodEnd}}.
+{{if .Capped}}
+The budget is capped: {{if eq .CapAction "scale_down"}}services were scaled down to one instance and {{end}}new deployments are blocked until the next period or until the budget is raised.
+{{end}}
+{{.Link}}
+`,
+		`<p>Hi,</p>
+<p>Usage charges of <strong>{{.TenantName}}</strong> are {{.Spend}} this billing period, {{.Threshold}}% of the {{.Amount}} budget. The period ends on {{.PeriodEnd}}.</p>
+{{if .Capped}}<p>The budget is capped: {{if eq .CapAction "scale_down"}}services were scaled down to one instance and {{end}}new deployments are blocked until the next period or until the budget is raised.</p>
+{{end}}<p><a href="{{.Link}}">Review usage</a></p>
+`,
+	},
+}
+
+type MailService struct {
+	mailer    Mailer
+	config    *config.Config
+	templates map[string]*mailTemplate
+}
+
+func NewMailService(mailer Mailer, cfg *config.Config) *MailService {
+	templates := make(map[string]*mailTemplate, len(mailTemplateSources))
+	for name, src := range mailTemplateSources {
+		templates[name] = &mailTemplate{
+			subject: texttemplate.Must(texttemplate.New(name + "_subject").Parse(src[0])),
+			text:    texttemplate.Must(texttemplate.New(name + "_text").Parse(src[1])),
+			html:    htmltemplate.Must(htmltemplate.New(name + "_html").Parse(src[2])),
+		}
+	}
+
+	return &MailService{
+		mailer:    mailer,
+		config:    cfg,
+		templates: templates,
+	}
+}
+
+// SendTemplate renders the named template with data and sends it to the recipients
+func (ms *MailService) SendTemplate(to []string, name string, data interface{}) error {
+	tmpl, ok := ms.templates[name]
+	if !ok {
+		return fmt.Errorf("unknown mail template: %s", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return fmt.Errorf("failed to render subject: %w", err)
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return fmt.Errorf("failed to render text body: %w", err)
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return fmt.Errorf("failed to render HTML body: %w", err)
+	}
+
+	return ms.mailer.Send(&MailMessage{
+		To:       to,
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: text.String(),
+		HTMLBody: html.String(),
+	})
+}
+
+// AppLink builds an absolute link into the frontend
+func (ms *MailService) AppLink(path string) string {
+	return strings.TrimRight(ms.config.Mail.AppURL, "/") + path
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nomad-services-api/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MailMessage represents an outgoing email
+type MailMessage struct {
+	To       []string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg *MailMessage) error
+}
+
+// NewMailer returns the mailer selected by MAIL_DRIVER (smtp, file or log)
+func NewMailer(cfg *config.Config) (Mailer, error) {
+	switch cfg.Mail.Driver {
+	case "smtp":
+		return &SMTPMailer{config: cfg}, nil
+	case "file":
+		if err := os.MkdirAll(cfg.Mail.FileDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create mail directory: %w", err)
+		}
+		return &FileMailer{config: cfg}, nil
+	case "log", "":
+		return &LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %s", cfg.Mail.Driver)
+	}
+}
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	config *config.Config
+}
+
+func (m *SMTPMailer) Send(msg *MailMessage) error {
+	body, err := buildMIMEMessage(m.config.Mail.From, msg)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	var auth smtp.Auth
+	if m.config.Mail.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", m.config.Mail.SMTPUsername, m.config.Mail.SMTPPassword, m.config.Mail.SMTPHost)
+	}
+
+	addr := m.config.Mail.SMTPHost + ":" + m.config.Mail.SMTPPort
+	if err := smtp.SendMail(addr, auth, envelopeAddress(m.config.Mail.From), msg.To, body); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return nil
+}
+
+// FileMailer writes each message as an .eml file, for development
+type FileMailer struct {
+	config *config.Config
+}
+
+func (m *FileMailer) Send(msg *MailMessage) error {
+	body, err := buildMIMEMessage(m.config.Mail.From, msg)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(suffix))
+	if err := os.WriteFile(filepath.Join(m.config.Mail.FileDir, name), body, 0644); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+
+	return nil
+}
+
+// LogMailer logs messages instead of sending them, for development
+type LogMailer struct{}
+
+func (m *LogMailer) Send(msg *MailMessage) error {
+	logrus.WithFields(logrus.Fields{
+		"to":      strings.Join(msg.To, ", "),
+		"subject": msg.Subject,
+	}).Info("Email (log driver):\n" + msg.TextBody)
+	return nil
+}
+
+// buildMIMEMessage renders a multipart/alternative message with text and HTML parts
+func buildMIMEMessage(from string, msg *MailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", msg.TextBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	}
+	for _, p := range parts {
+		if p.body == "" {
+			continue
+		}
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write([]byte(p.body)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// envelopeAddress extracts the bare address from "Name <address>"
+func envelopeAddress(from string) string {
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		if end := strings.LastIndex(from, ">"); end > start {
+			return from[start+1 : end]
+		}
+	}
+	return from
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limits on the range of a usage report, so one request cannot read years of
+// hourly buckets. Claimed metering slots are kept for a week; intervals whose
+// usage failed to commit are retried for a day.
+const (
+	maxHourlyReportRange  = 31 * 24 * time.Hour
+	maxDailyReportRange   = 366 * 24 * time.Hour
+	meteringSlotRetention = 7 * 24 * time.Hour
+	meteringRetryWindow   = 24 * time.Hour
+)
+
+// UsageTotals sums resource-seconds over a report
+type UsageTotals struct {
+	AllocationSeconds int64 `json:"allocation_seconds"`
+	CPUSeconds        int64 `json:"cpu_mhz_seconds"`
+	MemorySeconds     int64 `json:"memory_mb_seconds"`
+	DiskSeconds       int64 `json:"disk_mb_seconds"`
+}
+
+// ServiceUsage is the usage of one service over a report
+type ServiceUsage struct {
+	ServiceID   uuid.UUID `json:"service_id"`
+	ServiceName string    `json:"service_name"`
+	UsageTotals
+}
+
+// UsageReport is the metered usage of a tenant, or one of its services,
+// between From and To
+type UsageReport struct {
+	TenantID    uuid.UUID               `json:"tenant_id"`
+	ServiceID   *uuid.UUID              `json:"service_id,omitempty"`
+	From        time.Time               `json:"from"`
+	To          time.Time               `json:"to"`
+	Granularity models.UsageGranularity `json:"granularity"`
+	Totals      UsageTotals             `json:"totals"`
+	Services    []ServiceUsage          `json:"services"`
+	Buckets     []models.UsageRecord    `json:"buckets"`
+}
+
+// UsageQuery selects the usage to report. Zero times default to the start
+// of the current month and now.
+type UsageQuery struct {
+	ServiceID   *uuid.UUID
+	From        time.Time
+	To          time.Time
+	Granularity models.UsageGranularity
+}
+
+// MeteringService samples the running allocations of tenant services once
+// per interval and accumulates the resources they reserve into hourly and
+// daily usage buckets.
+//
+// Each interval is claimed through a MeteringSlot row in the transaction that
+// records its usage, so several API instances can meter at once without
+// counting usage twice. An interval whose usage failed to commit is retried
+// with the sample taken when it ended. Intervals in which no instance was
+// running are not metered; the gap is logged when metering resumes.
+type MeteringService struct {
+	db               *gorm.DB
+	nomadService     *NomadService
+	namespaceService *NamespaceService
+	config           *config.Config
+	retries          []unrecordedSlot // only used by run
+	gapChecked       bool             // only used by run
+	stop             chan struct{}
+}
+
+// usageSample is the number of allocations of a service running when usage
+// was sampled
+type usageSample struct {
+	service *models.Service
+	running int
+}
+
+// unrecordedSlot is an interval whose usage failed to commit, with the
+// sample taken when it ended
+type unrecordedSlot struct {
+	start   time.Time
+	samples []usageSample
+}
+
+func NewMeteringService(db *gorm.DB, nomadService *NomadService, namespaceService *NamespaceService, cfg *config.Config) *MeteringService {
+	return &MeteringService{
+		db:               db,
+		nomadService:     nomadService,
+		namespaceService: namespaceService,
+		config:           cfg,
+		stop:             make(chan struct{}),
+	}
+}
+
+// Start meters usage in the background until Stop is called
+func (ms *MeteringService) Start() {
+	go ms.run(ms.config.Metering.Interval)
+}
+
+// Stop stops metering
+func (ms *MeteringService) Stop() {
+	close(ms.stop)
+}
+
+func (ms *MeteringService) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ms.stop:
+			return
+		case now := <-ticker.C:
+			ms.recordPending(now, interval)
+		}
+	}
+}
+
+// recordPending meters the interval that just ended from a sample of what
+// is running now, and retries the intervals whose usage failed to commit
+func (ms *MeteringService) recordPending(now time.Time, interval time.Duration) {
+	last := now.UTC().Truncate(interval).Add(-interval)
+
+	samples, err := ms.sample()
+	if err != nil {
+		logrus.WithError(err).WithField("slot", last).Error("Failed to sample usage; the interval is not metered")
+	} else {
+		ms.retries = append(ms.retries, unrecordedSlot{start: last, samples: samples})
+	}
+
+	seconds := int64(interval / time.Second)
+	var failed []unrecordedSlot
+	for _, pending := range ms.retries {
+		log := logrus.WithField("slot", pending.start)
+		if pending.start.Before(last.Add(-meteringRetryWindow)) {
+			log.Error("Giving up recording usage; the interval is not metered")
+			continue
+		}
+		if err := ms.record(pending.start, seconds, pending.samples); err != nil {
+			log.WithError(err).Error("Failed to record usage; retrying next interval")
+			failed = append(failed, pending)
+		}
+	}
+	ms.retries = failed
+
+	if !ms.gapChecked {
+		ms.gapChecked = ms.logGap(last, interval)
+	}
+
+	if err := ms.db.Where("start < ?", last.Add(-meteringSlotRetention)).Delete(&models.MeteringSlot{}).Error; err != nil {
+		logrus.WithError(err).Warn("Failed to prune metering slots")
+	}
+}
+
+// logGap logs the intervals before last that were not metered since the
+// latest one that was, such as while no instance was running. Usage is only
+// known when it is sampled, so they are not metered after the fact. It
+// reports whether the check was made.
+func (ms *MeteringService) logGap(last time.Time, interval time.Duration) bool {
+	var latest []time.Time
+	if err := ms.db.Model(&models.MeteringSlot{}).Where("start < ?", last).
+		Order("start DESC").Limit(1).Pluck("start", &latest).Error; err != nil {
+		logrus.WithError(err).Warn("Failed to check for unmetered intervals")
+		return false
+	}
+
+	if len(latest) > 0 && latest[0].Before(last.Add(-interval)) {
+		logrus.WithFields(logrus.Fields{
+			"from": latest[0].Add(interval),
+			"to":   last,
+		}).Warn("Usage was not metered between these times")
+	}
+	return true
+}
+
+// sample counts the running allocations of the active tenant services. When
+// Nomad can't be reached a service counts with the instances of its last
+// known status, so an outage doesn't drop its usage.
+func (ms *MeteringService) sample() ([]usageSample, error) {
+	var services []models.Service
+	if err := ms.db.Where("tenant_id IS NOT NULL AND status IN (?)",
+		[]models.ServiceStatus{models.ServiceStatusPending, models.ServiceStatusRunning}).
+		Find(&services).Error; err != nil {
+		return nil, fmt.Errorf("failed to load services for metering: %w", err)
+	}
+
+	var samples []usageSample
+	for i := range services {
+		service := &services[i]
+
+		var deployment models.ServiceDeployment
+		if err := ms.db.Where("service_id = ? AND status IN (?)", service.ID,
+			[]models.DeploymentStatus{models.DeploymentStatusPending, models.DeploymentStatusRunning, models.DeploymentStatusCompleted}).
+			Order("created_at DESC").First(&deployment).Error; err != nil {
+			continue // Never deployed
+		}
+
+		running, err := ms.nomadService.RunningAllocations(context.Background(), ms.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID)
+		if err != nil {
+			running = 0
+			if service.Status == models.ServiceStatusRunning {
+				running = service.Config.InstanceCount()
+			}
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"service_id": service.ID,
+				"running":    running,
+			}).Warn("Failed to get running allocations; metering from the last known status")
+		}
+		if running > 0 {
+			samples = append(samples, usageSample{service: service, running: running})
+		}
+	}
+	return samples, nil
+}
+
+// record claims the interval starting at slot and adds seconds of the
+// sampled usage to its buckets, in one transaction. An interval another
+// instance already claimed is left alone.
+func (ms *MeteringService) record(slot time.Time, seconds int64, samples []usageSample) error {
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		claim := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MeteringSlot{Start: slot})
+		if claim.Error != nil {
+			return fmt.Errorf("failed to claim metering slot: %w", claim.Error)
+		}
+		if claim.RowsAffected == 0 {
+			return nil
+		}
+
+		for _, sample := range samples {
+			if err := meter(tx, sample, slot, seconds); err != nil {
+				return fmt.Errorf("failed to meter service %s: %w", sample.service.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// meter adds seconds of usage by the sampled allocations to the buckets
+// containing slot
+func meter(tx *gorm.DB, sample usageSample, slot time.Time, seconds int64) error {
+	service := sample.service
+
+	// Reserved resources of all instances of the service
+	reserved := demand(service.Config)
+	instances := int64(service.Config.InstanceCount())
+	allocations := int64(sample.running) * seconds
+
+	for _, bucket := range []struct {
+		granularity models.UsageGranularity
+		start       time.Time
+	}{
+		{models.UsageGranularityHour, slot.Truncate(time.Hour)},
+		{models.UsageGranularityDay, time.Date(slot.Year(), slot.Month(), slot.Day(), 0, 0, 0, 0, time.UTC)},
+	} {
+		record := &models.UsageRecord{
+			ID:                uuid.New(),
+			TenantID:          *service.TenantID,
+			ServiceID:         service.ID,
+			ServiceName:       service.Name,
+			Granularity:       bucket.granularity,
+			BucketStart:       bucket.start,
+			AllocationSeconds: allocations,
+			CPUSeconds:        int64(reserved.CPU) * allocations / instances,
+			MemorySeconds:     int64(reserved.Memory) * allocations / instances,
+			DiskSeconds:       int64(reserved.Disk) * allocations / instances,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "service_id"}, {Name: "granularity"}, {Name: "bucket_start"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"service_name":       record.ServiceName,
+				"allocation_seconds": gorm.Expr("usage_records.allocation_seconds + EXCLUDED.allocation_seconds"),
+				"cpu_seconds":        gorm.Expr("usage_records.cpu_seconds + EXCLUDED.cpu_seconds"),
+				"memory_seconds":     gorm.Expr("usage_records.memory_seconds + EXCLUDED.memory_seconds"),
+				"disk_seconds":       gorm.Expr("usage_records.disk_seconds + EXCLUDED.disk_seconds"),
+				"updated_at":         time.Now(),
+			}),
+		}).Create(record).Error; err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+	}
+	return nil
+}
+
+// Report returns a tenant's usage in the buckets starting between query.From
+// and query.To
+func (ms *MeteringService) Report(tenantID uuid.UUID, query UsageQuery) (*UsageReport, error) {
+	now := time.Now().UTC()
+	if query.To.IsZero() {
+		query.To = now
+	}
+	if query.From.IsZero() {
+		query.From = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	if query.Granularity == "" {
+		query.Granularity = models.UsageGranularityDay
+	}
+
+	var maxRange time.Duration
+	switch query.Granularity {
+	case models.UsageGranularityHour:
+		maxRange = maxHourlyReportRange
+	case models.UsageGranularityDay:
+		maxRange = maxDailyReportRange
+	default:
+		return nil, fmt.Errorf("granularity must be hour or day")
+	}
+	if !query.To.After(query.From) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	if query.To.Sub(query.From) > maxRange {
+		return nil, fmt.Errorf("%s usage can be reported for at most %d days", query.Granularity, int(maxRange/(24*time.Hour)))
+	}
+
+	db := ms.db.Where("tenant_id = ? AND granularity = ? AND bucket_start >= ? AND bucket_start < ?",
+		tenantID, query.Granularity, query.From, query.To)
+	if query.ServiceID != nil {
+		db = db.Where("service_id = ?", *query.ServiceID)
+	}
+
+	var buckets []models.UsageRecord
+	if err := db.Order("bucket_start, service_name").Find(&buckets).Error; err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	report := &UsageReport{
+		TenantID:    tenantID,
+		ServiceID:   query.ServiceID,
+		From:        query.From,
+		To:          query.To,
+		Granularity: query.Granularity,
+		Services:    []ServiceUsage{},
+		Buckets:     buckets,
+	}
+
+	index := map[uuid.UUID]int{}
+	for _, bucket := range buckets {
+		i, ok := index[bucket.ServiceID]
+		if !ok {
+			i = len(report.Services)
+			index[bucket.ServiceID] = i
+			report.Services = append(report.Services, ServiceUsage{ServiceID: bucket.ServiceID})
+		}
+		report.Services[i].ServiceName = bucket.ServiceName
+		report.Services[i].add(bucket)
+		report.Totals.add(bucket)
+	}
+	return report, nil
+}
+
+func (t *UsageTotals) add(record models.UsageRecord) {
+	t.AllocationSeconds += record.AllocationSeconds
+	t.CPUSeconds += record.CPUSeconds
+	t.MemorySeconds += record.MemorySeconds
+	t.DiskSeconds += record.DiskSeconds
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+type MFAService struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+func NewMFAService(db *gorm.DB, cfg *config.Config) *MFAService {
+	return &MFAService{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// MFAEnrollment represents a pending TOTP enrollment
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// MFACodeRequest represents a request carrying a TOTP or recovery code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// IsRequired reports whether the user's tenant enforces MFA
+func (ms *MFAService) IsRequired(user *models.User) bool {
+	return user.Tenant != nil && user.Tenant.RequireMFA
+}
+
+// BeginEnrollment generates a new TOTP secret for the user. The secret is not
+// active until ConfirmEnrollment succeeds with a code generated from it.
+func (ms *MFAService) BeginEnrollment(user *models.User) (*MFAEnrollment, error) {
+	if user.MFAEnabled {
+		return nil, fmt.Errorf("MFA is already enabled")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate MFA secret: %w", err)
+	}
+
+	if err := ms.db.Model(&models.User{}).Where("id = ?", user.ID).
+		Updates(map[string]interface{}{"mfa_secret": secret, "mfa_last_step": 0}).Error; err != nil {
+		return nil, fmt.Errorf("failed to save MFA secret: %w", err)
+	}
+	user.MFASecret = secret
+	user.MFALastStep = 0
+
+	return &MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totpURI(ms.config.MFA.Issuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmEnrollment activates MFA and returns the plaintext recovery codes.
+// The codes are only ever returned here and by RegenerateRecoveryCodes.
+func (ms *MFAService) ConfirmEnrollment(user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, fmt.Errorf("MFA is already enabled")
+	}
+	if user.MFASecret == "" {
+		return nil, fmt.Errorf("MFA enrollment has not been started")
+	}
+
+	step, ok := validateTOTP(user.MFASecret, code, time.Now(), user.MFALastStep)
+	if !ok {
+		return nil, fmt.Errorf("invalid MFA code")
+	}
+
+	var codes []string
+	now := time.Now()
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"mfa_enabled":      true,
+			"mfa_last_step":    step,
+			"mfa_confirmed_at": now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable MFA: %w", err)
+	}
+
+	user.MFAEnabled = true
+	user.MFALastStep = step
+	user.MFAConfirmedAt = &now
+	return codes, nil
+}
+
+// Verify checks a TOTP code, falling back to a single-use recovery code
+func (ms *MFAService) Verify(user *models.User, code string) error {
+	if !user.MFAEnabled {
+		return fmt.Errorf("MFA is not enabled")
+	}
+
+	if step, ok := validateTOTP(user.MFASecret, code, time.Now(), user.MFALastStep); ok {
+		// Only advance the step if nobody else used it concurrently
+		result := ms.db.Model(&models.User{}).
+			Where("id = ? AND mfa_last_step < ?", user.ID, step).
+			Update("mfa_last_step", step)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record MFA code: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("invalid MFA code")
+		}
+		user.MFALastStep = step
+		return nil
+	}
+
+	result := ms.db.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to check recovery code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("invalid MFA code")
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes and issues new ones
+func (ms *MFAService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if err := ms.Verify(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to regenerate recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// Disable turns off MFA for a user after verifying a current code
+func (ms *MFAService) Disable(user *models.User, code string) error {
+	if ms.IsRequired(user) {
+		return fmt.Errorf("MFA is required by your organization")
+	}
+	if err := ms.Verify(user, code); err != nil {
+		return err
+	}
+
+	return ms.Reset(user.ID)
+}
+
+// Reset removes a user's MFA secret and recovery codes. Used by admins when a
+// user has lost access to their device.
+func (ms *MFAService) Reset(userID uuid.UUID) error {
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"mfa_enabled":      false,
+			"mfa_secret":       "",
+			"mfa_last_step":    0,
+			"mfa_confirmed_at": nil,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to reset MFA: %w", err)
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// SetTenantRequirement enables or disables MFA enforcement for a tenant
+func (ms *MFAService) SetTenantRequirement(tenantID uuid.UUID, required bool) error {
+	result := ms.db.Model(&models.Tenant{}).Where("id = ?", tenantID).Update("require_mfa", required)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update tenant MFA policy: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("tenant not found")
+	}
+	return nil
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores a fresh set
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.MFARecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, models.MFARecoveryCode{
+			ID:       uuid.New(),
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(code),
+		})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code formatted as xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(buf)
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. Recovery codes are
+// random, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
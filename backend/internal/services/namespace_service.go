@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// NamespaceService gives every tenant its own Nomad namespace and, when
+// NOMAD_TENANT_ACL_TOKENS is set, an ACL token that can only reach it
+type NamespaceService struct {
+	db           *gorm.DB
+	nomadService *NomadService
+	quotaService *QuotaService
+	config       *config.Config
+}
+
+func NewNamespaceService(db *gorm.DB, nomadService *NomadService, quotaService *QuotaService, cfg *config.Config) *NamespaceService {
+	return &NamespaceService{
+		db:           db,
+		nomadService: nomadService,
+		quotaService: quotaService,
+		config:       cfg,
+	}
+}
+
+// Provision creates the tenant's namespace, bound to its quota spec when
+// quotas are mirrored, and its ACL token. It is safe to call repeatedly.
+func (ns *NamespaceService) Provision(ctx context.Context, tenant *models.Tenant) error {
+	if !ns.config.Nomad.TenantNamespaces {
+		return nil
+	}
+
+	name := tenant.NomadNamespace
+	if name == "" {
+		name = NamespaceName(tenant)
+	}
+
+	quota := ""
+	if ns.config.Nomad.QuotasEnabled {
+		ns.quotaService.SyncNomadQuota(tenant)
+		quota = NomadQuotaName(tenant)
+	}
+	if err := ns.nomadService.RegisterNamespace(ctx, name, "Tenant "+tenant.Name, quota); err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"nomad_namespace": name}
+	if ns.config.Nomad.TenantTokens && tenant.NomadToken == "" {
+		token, err := ns.nomadService.CreateNamespaceToken(ctx, name)
+		if err != nil {
+			return err
+		}
+		updates["nomad_token_accessor_id"] = token.AccessorID
+		updates["nomad_token"] = token.SecretID
+		tenant.NomadTokenAccessorID = token.AccessorID
+		tenant.NomadToken = token.SecretID
+	}
+
+	if err := ns.db.WithContext(ctx).Model(&models.Tenant{}).Where("id = ?", tenant.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to save tenant namespace: %w", err)
+	}
+	tenant.NomadNamespace = name
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"tenant_id": tenant.ID,
+		"namespace": name,
+	}).Info("Nomad namespace provisioned")
+	return nil
+}
+
+// TryProvision provisions a tenant's namespace without failing the caller;
+// Target retries tenants whose namespace is missing
+func (ns *NamespaceService) TryProvision(tenant *models.Tenant) {
+	if err := ns.Provision(context.Background(), tenant); err != nil {
+		logrus.WithError(err).WithField("tenant_id", tenant.ID).Warn("Failed to provision Nomad namespace")
+	}
+}
+
+// Deprovision removes a deleted tenant's ACL token, namespace and quota spec.
+// Failures are logged so tenant deletion does not depend on Nomad.
+func (ns *NamespaceService) Deprovision(tenant *models.Tenant) {
+	ctx := context.Background()
+	log := logrus.WithField("tenant_id", tenant.ID)
+
+	if tenant.NomadToken != "" {
+		if err := ns.nomadService.DeleteNamespaceToken(ctx, tenant.NomadNamespace, tenant.NomadTokenAccessorID); err != nil {
+			log.WithError(err).Warn("Failed to delete Nomad ACL token")
+		}
+	}
+	if tenant.NomadNamespace != "" {
+		if err := ns.nomadService.DeleteNamespace(ctx, tenant.NomadNamespace); err != nil {
+			log.WithError(err).Warn("Failed to delete Nomad namespace")
+		}
+	}
+	if ns.config.Nomad.QuotasEnabled {
+		if err := ns.nomadService.DeleteQuota(ctx, NomadQuotaName(tenant)); err != nil {
+			log.WithError(err).Warn("Failed to delete Nomad quota")
+		}
+	}
+}
+
+// Target returns where new jobs of a tenant are registered. System services
+// (nil tenantID) use the configured namespace and token. Tenants created
+// before namespaces were enabled are provisioned on first use.
+func (ns *NamespaceService) Target(ctx context.Context, tenantID *uuid.UUID) (NomadTarget, error) {
+	if tenantID == nil || !ns.config.Nomad.TenantNamespaces {
+		return NomadTarget{}, nil
+	}
+
+	var tenant models.Tenant
+	if err := ns.db.WithContext(ctx).First(&tenant, "id = ?", *tenantID).Error; err != nil {
+		return NomadTarget{}, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if tenant.NomadNamespace == "" || (ns.config.Nomad.TenantTokens && tenant.NomadToken == "") {
+		if err := ns.Provision(ctx, &tenant); err != nil {
+			return NomadTarget{}, err
+		}
+	}
+
+	return NomadTarget{Namespace: tenant.NomadNamespace, Token: tenant.NomadToken}, nil
+}
+
+// DeploymentTarget returns where an existing deployment's job lives. Jobs
+// deployed before tenant namespaces keep using the configured namespace and
+// token.
+func (ns *NamespaceService) DeploymentTarget(service *models.Service, deployment *models.ServiceDeployment) NomadTarget {
+	target := NomadTarget{Namespace: deployment.NomadNamespace}
+	if deployment.NomadNamespace == "" || service.TenantID == nil {
+		return target
+	}
+
+	var tenant models.Tenant
+	if err := ns.db.Select("nomad_namespace", "nomad_token").First(&tenant, "id = ?", *service.TenantID).Error; err == nil &&
+		tenant.NomadNamespace == deployment.NomadNamespace {
+		target.Token = tenant.NomadToken
+	}
+	return target
+}
+
+// NamespaceName is the Nomad namespace a tenant gets when it is provisioned
+func NamespaceName(tenant *models.Tenant) string {
+	return "tenant-" + tenant.Slug
+}
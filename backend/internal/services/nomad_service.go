@@ -1,17 +1,25 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/metrics"
 	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/requestid"
+	"nomad-services-api/internal/tracing"
 
 	"github.com/hashicorp/nomad/api"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type NomadService struct {
@@ -19,6 +27,48 @@ type NomadService struct {
 	config *config.Config
 }
 
+// NomadTarget is the namespace a job lives in and the ACL token used to manage
+// it. Empty fields use the configured namespace and token.
+type NomadTarget struct {
+	Namespace string
+	Token     string
+}
+
+// startOperation starts the span of a Nomad operation. The returned function
+// ends it and records the operation's latency and outcome in the metrics;
+// defer it with a pointer to the operation's named error result.
+func startOperation(ctx context.Context, operation string) (context.Context, func(*error)) {
+	start := time.Now()
+	ctx, span := tracing.Tracer.Start(ctx, "nomad."+operation, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, func(err *error) {
+		metrics.ObserveNomadRequest(operation, time.Since(start), *err)
+		if *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
+
+// queryOptions and writeOptions carry ctx, so Nomad requests are cancelled
+// with the API request that made them and send its request ID
+func (t NomadTarget) queryOptions(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{Namespace: t.Namespace, AuthToken: t.Token, Headers: requestHeaders(ctx)}).WithContext(ctx)
+}
+
+func (t NomadTarget) writeOptions(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{Namespace: t.Namespace, AuthToken: t.Token, Headers: requestHeaders(ctx)}).WithContext(ctx)
+}
+
+// requestHeaders forwards the ID of the API request behind a Nomad call, for
+// proxies in front of Nomad to log
+func requestHeaders(ctx context.Context) map[string]string {
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		return map[string]string{requestid.Header: requestID}
+	}
+	return nil
+}
+
 func NewNomadService(cfg *config.Config) *NomadService {
 	nomadConfig := api.DefaultConfig()
 	nomadConfig.Address = cfg.Nomad.Address
@@ -39,34 +89,204 @@ func NewNomadService(cfg *config.Config) *NomadService {
 	}
 }
 
-func (ns *NomadService) GetJobStatus(jobID string) (*api.Job, error) {
+func (ns *NomadService) GetJobStatus(ctx context.Context, target NomadTarget, jobID string) (_ *api.Job, err error) {
+	ctx, end := startOperation(ctx, "get_job_status")
+	defer end(&err)
+
 	jobs := ns.client.Jobs()
-	job, _, err := jobs.Info(jobID, nil)
+	job, _, err := jobs.Info(jobID, target.queryOptions(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get job info: %w", err)
 	}
 	return job, nil
 }
 
-func (ns *NomadService) ListJobs() ([]*api.JobListStub, error) {
+// RunningAllocations counts the running allocations of a job across its
+// task groups
+func (ns *NomadService) RunningAllocations(ctx context.Context, target NomadTarget, jobID string) (_ int, err error) {
+	ctx, end := startOperation(ctx, "running_allocations")
+	defer end(&err)
+
+	summary, _, err := ns.client.Jobs().Summary(jobID, target.queryOptions(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get job summary: %w", err)
+	}
+
+	running := 0
+	for _, group := range summary.Summary {
+		running += group.Running
+	}
+	return running, nil
+}
+
+func (ns *NomadService) ListJobs(ctx context.Context, target NomadTarget) (_ []*api.JobListStub, err error) {
+	ctx, end := startOperation(ctx, "list_jobs")
+	defer end(&err)
+
 	jobs := ns.client.Jobs()
-	jobList, _, err := jobs.List(nil)
+	jobList, _, err := jobs.List(target.queryOptions(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("failed to list jobs: %w", err)
 	}
 	return jobList, nil
 }
 
-func (ns *NomadService) DeployService(service *models.Service, tenantID string) (*models.ServiceDeployment, error) {
-	logrus.WithFields(logrus.Fields{
+func (ns *NomadService) DeployService(ctx context.Context, service *models.Service, tenantID string, target NomadTarget) (_ *models.ServiceDeployment, err error) {
+	ctx, end := startOperation(ctx, "deploy_service")
+	defer end(&err)
+
+	namespace := target.Namespace
+	if namespace == "" {
+		namespace = ns.config.Nomad.Namespace
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"service_id":   service.ID,
 		"service_name": service.Name,
 		"tenant_id":    tenantID,
+		"namespace":    namespace,
 	}).Info("Deploying service")
 
 	// Generate unique job ID
-	jobID := fmt.Sprintf("%s-%s-%d", tenantID, service.Name, time.Now().Unix())
+	jobID := NewJobID(tenantID, service)
+
+	job, err := ns.renderJob(ctx, service, jobID, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the API request that deployed the job, so the job can be traced
+	// back to the API logs
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		if job.Meta == nil {
+			job.Meta = make(map[string]string)
+		}
+		job.Meta["request_id"] = requestID
+	}
+
+	// Submit job
+	jobs := ns.client.Jobs()
+	_, _, err = jobs.Register(job, target.writeOptions(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to register job: %w", err)
+	}
+
+	// Create deployment record
+	deployment := &models.ServiceDeployment{
+		ServiceID:      service.ID,
+		Status:         models.DeploymentStatusPending,
+		NomadJobID:     jobID,
+		NomadNamespace: namespace,
+		DeployedBy:     service.CreatedBy,
+	}
+
+	return deployment, nil
+}
+
+// JobPlan summarizes a Nomad dry run of a service's job
+type JobPlan struct {
+	JobID          string               `json:"job_id"`
+	Namespace      string               `json:"namespace"`
+	DiffType       string               `json:"diff_type"` // Added, Edited or None
+	TaskGroups     map[string]GroupPlan `json:"task_groups"`
+	FailedGroups   map[string]string    `json:"failed_groups,omitempty"` // task group -> why it cannot be placed
+	Warnings       string               `json:"warnings,omitempty"`
+	JobModifyIndex uint64               `json:"job_modify_index"`
+	Estimate       *ServiceEstimate     `json:"estimate,omitempty"`
+}
+
+// GroupPlan is the scheduler's decision for one task group
+type GroupPlan struct {
+	Count             int    `json:"count"`
+	Place             uint64 `json:"place"`
+	Stop              uint64 `json:"stop"`
+	InPlaceUpdate     uint64 `json:"in_place_update"`
+	DestructiveUpdate uint64 `json:"destructive_update"`
+	Ignore            uint64 `json:"ignore"`
+}
+
+// NewJobID returns a unique Nomad job ID for a deployment of service
+func NewJobID(tenantID string, service *models.Service) string {
+	return fmt.Sprintf("%s-%s-%d", tenantID, service.Name, time.Now().Unix())
+}
+
+// PlanService renders service's job as DeployService would and asks the
+// Nomad scheduler what registering it under jobID would do, without
+// changing anything
+func (ns *NomadService) PlanService(ctx context.Context, service *models.Service, jobID string, target NomadTarget) (_ *JobPlan, err error) {
+	ctx, end := startOperation(ctx, "plan_service")
+	defer end(&err)
+
+	namespace := target.Namespace
+	if namespace == "" {
+		namespace = ns.config.Nomad.Namespace
+	}
+
+	job, err := ns.renderJob(ctx, service, jobID, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, _, err := ns.client.Jobs().Plan(job, true, target.writeOptions(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan job: %w", err)
+	}
+
+	plan := &JobPlan{
+		JobID:          jobID,
+		Namespace:      namespace,
+		TaskGroups:     make(map[string]GroupPlan),
+		Warnings:       resp.Warnings,
+		JobModifyIndex: resp.JobModifyIndex,
+	}
+	if resp.Diff != nil {
+		plan.DiffType = resp.Diff.Type
+	}
+	for _, group := range job.TaskGroups {
+		groupPlan := GroupPlan{Count: *group.Count}
+		if resp.Annotations != nil {
+			if updates := resp.Annotations.DesiredTGUpdates[*group.Name]; updates != nil {
+				groupPlan.Place = updates.Place
+				groupPlan.Stop = updates.Stop
+				groupPlan.InPlaceUpdate = updates.InPlaceUpdate
+				groupPlan.DestructiveUpdate = updates.DestructiveUpdate
+				groupPlan.Ignore = updates.Ignore
+			}
+		}
+		plan.TaskGroups[*group.Name] = groupPlan
+	}
+	for name, metric := range resp.FailedTGAllocs {
+		if plan.FailedGroups == nil {
+			plan.FailedGroups = make(map[string]string)
+		}
+		plan.FailedGroups[name] = placementFailure(metric)
+	}
+
+	return plan, nil
+}
+
+// TaskGroupCounts returns the number of allocations of each task group of
+// service's rendered job. Services without a job file run a single group.
+func (ns *NomadService) TaskGroupCounts(ctx context.Context, service *models.Service) (map[string]int, error) {
+	if service.Config.NomadJobFile == "" {
+		return map[string]int{service.Name: service.Config.InstanceCount()}, nil
+	}
+
+	job, err := ns.renderJob(ctx, service, NewJobID("estimate", service), ns.config.Nomad.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(job.TaskGroups))
+	for _, group := range job.TaskGroups {
+		counts[*group.Name] = *group.Count
+	}
+	return counts, nil
+}
 
+// renderJob reads, fills in and parses service's job file, and applies the
+// job ID, namespace and instance count the API controls
+func (ns *NomadService) renderJob(ctx context.Context, service *models.Service, jobID, namespace string) (*api.Job, error) {
 	// Read job file
 	jobContent, err := ns.readJobFile(service.Config.NomadJobFile)
 	if err != nil {
@@ -77,51 +297,222 @@ func (ns *NomadService) DeployService(service *models.Service, tenantID string)
 	jobContent = ns.replaceVariables(jobContent, service, jobID)
 
 	// Parse job
-	job, err := ns.parseJob(jobContent)
+	job, err := ns.parseJob(ctx, jobContent)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse job: %w", err)
 	}
 
-	// Set job ID
+	// Set job ID and keep the job in the target namespace whatever the job file says
 	job.ID = &jobID
+	job.Namespace = &namespace
+
+	// Run the configured number of instances of every task group
+	count := service.Config.InstanceCount()
+	for _, group := range job.TaskGroups {
+		group.Count = &count
+	}
+
+	return job, nil
+}
+
+// placementFailure describes why the scheduler could not place a task group
+func placementFailure(metric *api.AllocationMetric) string {
+	if metric == nil {
+		return "no eligible nodes"
+	}
+	reasons := []string{}
+	for dimension, count := range metric.DimensionExhausted {
+		reasons = append(reasons, fmt.Sprintf("%s exhausted on %d nodes", dimension, count))
+	}
+	for constraint, count := range metric.ConstraintFiltered {
+		reasons = append(reasons, fmt.Sprintf("constraint %q filtered %d nodes", constraint, count))
+	}
+	if metric.NodesEvaluated == 0 {
+		reasons = append(reasons, "no nodes were eligible")
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, "; ")
+}
+
+func (ns *NomadService) StopService(ctx context.Context, target NomadTarget, jobID string) (err error) {
+	ctx, end := startOperation(ctx, "stop_service")
+	defer end(&err)
 
-	// Submit job
 	jobs := ns.client.Jobs()
-	_, _, err = jobs.Register(job, nil)
+	_, _, err = jobs.Deregister(jobID, true, target.writeOptions(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("failed to register job: %w", err)
+		return fmt.Errorf("failed to deregister job: %w", err)
 	}
+	return nil
+}
 
-	// Create deployment record
-	deployment := &models.ServiceDeployment{
-		ServiceID:  service.ID,
-		Status:     models.DeploymentStatusPending,
-		NomadJobID: jobID,
-		DeployedBy: service.CreatedBy,
+// ScaleJob sets the instance count of every task group of a job
+func (ns *NomadService) ScaleJob(ctx context.Context, target NomadTarget, jobID string, count int) (err error) {
+	ctx, end := startOperation(ctx, "scale_job")
+	defer end(&err)
+
+	job, err := ns.GetJobStatus(ctx, target, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to get job status: %w", err)
 	}
 
-	return deployment, nil
+	jobs := ns.client.Jobs()
+	for _, group := range job.TaskGroups {
+		if group.Name == nil {
+			continue
+		}
+		if _, _, err := jobs.Scale(jobID, *group.Name, &count, "scaled through the services API", false, nil, target.writeOptions(ctx)); err != nil {
+			return fmt.Errorf("failed to scale task group %s: %w", *group.Name, err)
+		}
+	}
+	return nil
 }
 
-func (ns *NomadService) StopService(jobID string) error {
-	jobs := ns.client.Jobs()
-	_, _, err := jobs.Deregister(jobID, true, nil)
+// RegisterQuota creates or updates a Nomad quota spec limiting CPU and
+// memory in the client's region. Zero limits are unlimited.
+func (ns *NomadService) RegisterQuota(ctx context.Context, name, description string, cpu, memory int) (err error) {
+	ctx, end := startOperation(ctx, "register_quota")
+	defer end(&err)
+
+	spec := &api.QuotaSpec{
+		Name:        name,
+		Description: description,
+		Limits: []*api.QuotaLimit{{
+			Region: ns.region(),
+			RegionLimit: &api.QuotaResources{
+				CPU:      &cpu,
+				MemoryMB: &memory,
+			},
+		}},
+	}
+
+	if _, err := ns.client.Quotas().Register(spec, NomadTarget{}.writeOptions(ctx)); err != nil {
+		return fmt.Errorf("failed to register quota %s: %w", name, err)
+	}
+	return nil
+}
+
+// RegisterNamespace creates or updates a namespace, optionally bound to a
+// quota spec
+func (ns *NomadService) RegisterNamespace(ctx context.Context, name, description, quota string) (err error) {
+	ctx, end := startOperation(ctx, "register_namespace")
+	defer end(&err)
+
+	namespace := &api.Namespace{
+		Name:        name,
+		Description: description,
+		Quota:       quota,
+	}
+	if _, err := ns.client.Namespaces().Register(namespace, NomadTarget{}.writeOptions(ctx)); err != nil {
+		return fmt.Errorf("failed to register namespace %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteNamespace deletes a namespace. Nomad refuses while it still has
+// non-terminal jobs.
+func (ns *NomadService) DeleteNamespace(ctx context.Context, name string) (err error) {
+	ctx, end := startOperation(ctx, "delete_namespace")
+	defer end(&err)
+
+	if _, err := ns.client.Namespaces().Delete(name, NomadTarget{}.writeOptions(ctx)); err != nil {
+		return fmt.Errorf("failed to delete namespace %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteQuota deletes a quota spec
+func (ns *NomadService) DeleteQuota(ctx context.Context, name string) (err error) {
+	ctx, end := startOperation(ctx, "delete_quota")
+	defer end(&err)
+
+	if _, err := ns.client.Quotas().Delete(name, NomadTarget{}.writeOptions(ctx)); err != nil {
+		return fmt.Errorf("failed to delete quota %s: %w", name, err)
+	}
+	return nil
+}
+
+// CreateNamespaceToken creates an ACL policy granting write access to a
+// single namespace and a client token holding only that policy
+func (ns *NomadService) CreateNamespaceToken(ctx context.Context, namespace string) (_ *api.ACLToken, err error) {
+	ctx, end := startOperation(ctx, "create_namespace_token")
+	defer end(&err)
+
+	policy := &api.ACLPolicy{
+		Name:        namespace,
+		Description: "Access to namespace " + namespace,
+		Rules:       fmt.Sprintf("namespace %q {\n  policy = \"write\"\n}\n", namespace),
+	}
+	if _, err := ns.client.ACLPolicies().Upsert(policy, NomadTarget{}.writeOptions(ctx)); err != nil {
+		return nil, fmt.Errorf("failed to register ACL policy %s: %w", namespace, err)
+	}
+
+	token, _, err := ns.client.ACLTokens().Create(&api.ACLToken{
+		Name:     namespace,
+		Type:     "client",
+		Policies: []string{namespace},
+	}, NomadTarget{}.writeOptions(ctx))
 	if err != nil {
-		return fmt.Errorf("failed to deregister job: %w", err)
+		return nil, fmt.Errorf("failed to create ACL token for %s: %w", namespace, err)
+	}
+	return token, nil
+}
+
+// DeleteNamespaceToken revokes a token created by CreateNamespaceToken and
+// deletes its policy
+func (ns *NomadService) DeleteNamespaceToken(ctx context.Context, namespace, accessorID string) (err error) {
+	ctx, end := startOperation(ctx, "delete_namespace_token")
+	defer end(&err)
+
+	if accessorID != "" {
+		if _, err := ns.client.ACLTokens().Delete(accessorID, NomadTarget{}.writeOptions(ctx)); err != nil {
+			return fmt.Errorf("failed to delete ACL token for %s: %w", namespace, err)
+		}
+	}
+	if _, err := ns.client.ACLPolicies().Delete(namespace, NomadTarget{}.writeOptions(ctx)); err != nil {
+		return fmt.Errorf("failed to delete ACL policy %s: %w", namespace, err)
 	}
 	return nil
 }
 
-func (ns *NomadService) RestartService(jobID string) error {
+// Leader returns the address of the Nomad cluster's leader, and fails when
+// the cluster has none or cannot be reached
+func (ns *NomadService) Leader(ctx context.Context) (_ string, err error) {
+	ctx, end := startOperation(ctx, "leader")
+	defer end(&err)
+
+	// Status().Leader() takes no options, so query the endpoint directly to
+	// honour ctx
+	var leader string
+	if _, err := ns.client.Raw().Query("/v1/status/leader", &leader, NomadTarget{}.queryOptions(ctx)); err != nil {
+		return "", fmt.Errorf("failed to get Nomad leader: %w", err)
+	}
+	if leader == "" {
+		return "", errors.New("no Nomad leader elected")
+	}
+	return leader, nil
+}
+
+func (ns *NomadService) region() string {
+	if region, err := ns.client.Agent().Region(); err == nil && region != "" {
+		return region
+	}
+	return "global"
+}
+
+func (ns *NomadService) RestartService(ctx context.Context, target NomadTarget, jobID string) (err error) {
+	ctx, end := startOperation(ctx, "restart_service")
+	defer end(&err)
+
 	// Get current job
-	job, err := ns.GetJobStatus(jobID)
+	job, err := ns.GetJobStatus(ctx, target, jobID)
 	if err != nil {
 		return fmt.Errorf("failed to get job status: %w", err)
 	}
 
 	// Force new deployment
 	jobs := ns.client.Jobs()
-	_, _, err = jobs.Register(job, nil)
+	_, _, err = jobs.Register(job, target.writeOptions(ctx))
 	if err != nil {
 		return fmt.Errorf("failed to restart job: %w", err)
 	}
@@ -129,15 +520,18 @@ func (ns *NomadService) RestartService(jobID string) error {
 	return nil
 }
 
-func (ns *NomadService) GetServiceLogs(jobID string, taskName string) ([]string, error) {
+func (ns *NomadService) GetServiceLogs(ctx context.Context, target NomadTarget, jobID string, taskName string) ([]string, error) {
 	// Temporarily return empty logs until API is fixed
 	return []string{"Logs not available - API needs fixing"}, nil
 }
 
-func (ns *NomadService) GetServiceMetrics(jobID string) (map[string]interface{}, error) {
+func (ns *NomadService) GetServiceMetrics(ctx context.Context, target NomadTarget, jobID string) (_ map[string]interface{}, err error) {
+	ctx, end := startOperation(ctx, "get_service_metrics")
+	defer end(&err)
+
 	// Get allocations for the job
 	jobs := ns.client.Jobs()
-	allocs, _, err := jobs.Allocations(jobID, false, nil)
+	allocs, _, err := jobs.Allocations(jobID, false, target.queryOptions(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get allocations: %w", err)
 	}
@@ -216,7 +610,10 @@ func (ns *NomadService) replaceVariables(content string, service *models.Service
 	return content
 }
 
-func (ns *NomadService) parseJob(content string) (*api.Job, error) {
+func (ns *NomadService) parseJob(ctx context.Context, content string) (_ *api.Job, err error) {
+	_, end := startOperation(ctx, "parse_job")
+	defer end(&err)
+
 	// Parse the job using Nomad's HCL parser
 	jobs := ns.client.Jobs()
 	job, err := jobs.ParseHCL(content, false)
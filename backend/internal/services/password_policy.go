@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"nomad-services-api/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PasswordPolicyError lists every rule a password failed
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet policy: " + strings.Join(e.Violations, "; ")
+}
+
+type PasswordPolicy struct {
+	config   config.PasswordConfig
+	breached map[string]struct{}
+}
+
+// NewPasswordPolicy builds the policy from config and loads the breached
+// password list, if one is configured. The list holds one entry per line,
+// either a plaintext password or an uppercase SHA-1 hash in the
+// "HASH" or "HASH:count" format used by Have I Been Pwned downloads.
+func NewPasswordPolicy(cfg *config.Config) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		config:   cfg.Password,
+		breached: make(map[string]struct{}),
+	}
+
+	if cfg.Password.BreachedPasswordFile == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(cfg.Password.BreachedPasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, ok := parseSHA1Line(line); ok {
+			policy.breached[hash] = struct{}{}
+		} else {
+			policy.breached[sha1Hex(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	logrus.WithField("entries", len(policy.breached)).Info("Loaded breached password list")
+	return policy, nil
+}
+
+// Validate checks password against the policy. identifiers (username, email)
+// must not appear in the password.
+func (p *PasswordPolicy) Validate(password string, identifiers ...string) error {
+	var violations []string
+
+	if len([]rune(password)) < p.config.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", p.config.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.config.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.config.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.config.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.config.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	lower := strings.ToLower(password)
+	for _, identifier := range identifiers {
+		if len(identifier) >= 3 && strings.Contains(lower, strings.ToLower(identifier)) {
+			violations = append(violations, "must not contain your username or email")
+			break
+		}
+	}
+
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		violations = append(violations, "appears in a list of breached passwords")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func parseSHA1Line(line string) (string, bool) {
+	hash := line
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		hash = line[:i]
+	}
+	if len(hash) != 40 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return strings.ToUpper(hash), true
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
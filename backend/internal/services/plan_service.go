@@ -0,0 +1,544 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrPlanNotFound is returned for plans that are not in the catalog, or are
+// private and requested through self-service
+var ErrPlanNotFound = errors.New("plan not found")
+
+// DefaultPlans seed the plan catalog. Plans already in the database are left
+// untouched so admin changes survive restarts.
+var DefaultPlans = []models.Plan{
+	{
+		ID:               models.TenantPlanFree,
+		Name:             "Free",
+		Description:      "For trying things out",
+		MaxServices:      5,
+		MaxSeats:         3,
+		CPU:              2000,
+		Memory:           2048,
+		Disk:             10240,
+		Allocations:      5,
+		Instances:        2,
+		UserRateLimits:   models.RateLimits{Auth: 20, Read: 120, Write: 30, Deploy: 10},
+		TenantRateLimits: models.RateLimits{Auth: 60, Read: 300, Write: 60, Deploy: 20},
+		APIKeyRateLimits: models.RateLimits{Auth: 20, Read: 120, Write: 30, Deploy: 10},
+		Features:         []string{"community_support"},
+		Public:           true,
+		SortOrder:        0,
+	},
+	{
+		ID:                  models.TenantPlanStarter,
+		Name:                "Starter",
+		Description:         "For small teams running production services",
+		PricePerMonth:       29,
+		MaxServices:         20,
+		MaxSeats:            10,
+		CPU:                 8000,
+		Memory:              8192,
+		Disk:                51200,
+		Allocations:         20,
+		Instances:           5,
+		IncludedCPUHours:    2920,
+		IncludedMemoryHours: 2920,
+		CPUHourPrice:        0.01,
+		MemoryHourPrice:     0.005,
+		UserRateLimits:      models.RateLimits{Auth: 30, Read: 300, Write: 60, Deploy: 30},
+		TenantRateLimits:    models.RateLimits{Auth: 120, Read: 1000, Write: 200, Deploy: 60},
+		APIKeyRateLimits:    models.RateLimits{Auth: 30, Read: 600, Write: 120, Deploy: 30},
+		Features:            []string{"email_support", "custom_domain"},
+		Public:              true,
+		SortOrder:           1,
+	},
+	{
+		ID:                  models.TenantPlanPro,
+		Name:                "Pro",
+		Description:         "For growing organizations",
+		PricePerMonth:       99,
+		MaxServices:         100,
+		MaxSeats:            50,
+		CPU:                 32000,
+		Memory:              32768,
+		Disk:                204800,
+		Allocations:         100,
+		Instances:           20,
+		IncludedCPUHours:    11680,
+		IncludedMemoryHours: 11680,
+		CPUHourPrice:        0.008,
+		MemoryHourPrice:     0.004,
+		UserRateLimits:      models.RateLimits{Auth: 60, Read: 600, Write: 120, Deploy: 60},
+		TenantRateLimits:    models.RateLimits{Auth: 300, Read: 3000, Write: 600, Deploy: 200},
+		APIKeyRateLimits:    models.RateLimits{Auth: 60, Read: 1200, Write: 300, Deploy: 120},
+		Features:            []string{"priority_support", "custom_domain", "custom_roles", "mfa_enforcement"},
+		Public:              true,
+		SortOrder:           2,
+	},
+	{
+		ID:               models.TenantPlanEnterprise,
+		Name:             "Enterprise",
+		Description:      "Unlimited resources and custom terms; contact sales",
+		UserRateLimits:   models.RateLimits{Auth: 120, Read: 1200, Write: 300, Deploy: 120},
+		TenantRateLimits: models.RateLimits{Auth: 1000, Read: 10000, Write: 2000, Deploy: 600},
+		APIKeyRateLimits: models.RateLimits{Auth: 120, Read: 3000, Write: 600, Deploy: 300},
+		Features:         []string{"dedicated_support", "custom_domain", "custom_roles", "mfa_enforcement", "nomad_quotas"},
+		Public:           false,
+		SortOrder:        3,
+	},
+}
+
+// PlanRequest creates or replaces a catalog plan
+type PlanRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Description   string   `json:"description"`
+	PricePerMonth float64  `json:"price_per_month"`
+	MaxServices   int      `json:"max_services"`
+	MaxSeats      int      `json:"max_seats"`
+	CPU           int      `json:"cpu"`
+	Memory        int      `json:"memory"`
+	Disk          int      `json:"disk"`
+	Allocations   int      `json:"allocations"`
+	Instances     int      `json:"instances"`
+	Features      []string `json:"features"`
+	Public        bool     `json:"public"`
+	SortOrder     int      `json:"sort_order"`
+
+	IncludedCPUHours    int     `json:"included_cpu_hours"`
+	IncludedMemoryHours int     `json:"included_memory_hours"`
+	CPUHourPrice        float64 `json:"cpu_hour_price"`
+	MemoryHourPrice     float64 `json:"memory_hour_price"`
+
+	UserRateLimits   models.RateLimits `json:"user_rate_limits"`
+	TenantRateLimits models.RateLimits `json:"tenant_rate_limits"`
+	APIKeyRateLimits models.RateLimits `json:"api_key_rate_limits"`
+}
+
+// ChangePlanRequest selects the plan a tenant moves to
+type ChangePlanRequest struct {
+	Plan models.TenantPlan `json:"plan" binding:"required"`
+}
+
+// CurrentPlan is a tenant's plan together with its subscription
+type CurrentPlan struct {
+	Plan         *models.Plan         `json:"plan"`
+	MaxServices  int                  `json:"max_services"`
+	Subscription *models.Subscription `json:"subscription"`
+}
+
+// PlanViolation is a limit the tenant's current usage exceeds on a plan
+type PlanViolation struct {
+	Limit string `json:"limit"`
+	Used  int    `json:"used"`
+	Max   int    `json:"max"`
+}
+
+// PlanChangeError is returned when a tenant uses more than the plan it is
+// changing to allows
+type PlanChangeError struct {
+	Plan       models.TenantPlan
+	Violations []PlanViolation
+}
+
+func (e *PlanChangeError) Error() string {
+	limits := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		limits = append(limits, fmt.Sprintf("%s (%d of %d)", v.Limit, v.Used, v.Max))
+	}
+	return fmt.Sprintf("current usage exceeds the %s plan: %s", e.Plan, strings.Join(limits, ", "))
+}
+
+// PlanChangePreview describes what changing to a plan would do
+type PlanChangePreview struct {
+	CurrentPlan models.TenantPlan `json:"current_plan"`
+	Plan        *models.Plan      `json:"plan"`
+	Allowed     bool              `json:"allowed"`
+	Violations  []PlanViolation   `json:"violations"`
+}
+
+// planCacheTTL is how long CachedPlan reuses a catalog entry. Changes made on
+// another instance of the API apply after at most this long.
+const planCacheTTL = 30 * time.Second
+
+type cachedPlan struct {
+	plan      *models.Plan
+	fetchedAt time.Time
+}
+
+type PlanService struct {
+	db           *gorm.DB
+	quotaService *QuotaService
+
+	cacheMu sync.Mutex
+	cache   map[models.TenantPlan]cachedPlan
+}
+
+func NewPlanService(db *gorm.DB, quotaService *QuotaService) *PlanService {
+	return &PlanService{
+		db:           db,
+		quotaService: quotaService,
+		cache:        make(map[models.TenantPlan]cachedPlan),
+	}
+}
+
+// SeedDefaults adds the default plans that are missing from the catalog
+func (ps *PlanService) SeedDefaults() error {
+	plans := make([]models.Plan, len(DefaultPlans))
+	copy(plans, DefaultPlans)
+	if err := ps.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&plans).Error; err != nil {
+		return fmt.Errorf("failed to seed plans: %w", err)
+	}
+	return nil
+}
+
+// ListPlans returns the catalog in display order. Private plans are only
+// included for admins.
+func (ps *PlanService) ListPlans(includePrivate bool) ([]models.Plan, error) {
+	query := ps.db.Order("sort_order, price_per_month")
+	if !includePrivate {
+		query = query.Where("public = ?", true)
+	}
+
+	var plans []models.Plan
+	if err := query.Find(&plans).Error; err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+	return plans, nil
+}
+
+// GetPlan returns a catalog plan
+func (ps *PlanService) GetPlan(id models.TenantPlan) (*models.Plan, error) {
+	return getPlan(ps.db, id)
+}
+
+// CachedPlan returns a catalog plan like GetPlan, reusing it for up to
+// planCacheTTL. It is meant for hot paths such as rate limiting.
+func (ps *PlanService) CachedPlan(id models.TenantPlan) (*models.Plan, error) {
+	ps.cacheMu.Lock()
+	cached, ok := ps.cache[id]
+	ps.cacheMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < planCacheTTL {
+		return cached.plan, nil
+	}
+
+	plan, err := getPlan(ps.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.cacheMu.Lock()
+	ps.cache[id] = cachedPlan{plan: plan, fetchedAt: time.Now()}
+	ps.cacheMu.Unlock()
+	return plan, nil
+}
+
+func (ps *PlanService) forgetCachedPlan(id models.TenantPlan) {
+	ps.cacheMu.Lock()
+	delete(ps.cache, id)
+	ps.cacheMu.Unlock()
+}
+
+// SavePlan creates or replaces a catalog plan. Tenants on the plan whose
+// service limit was not customized follow the new limit; quotas and seat
+// limits are read from the catalog and apply immediately.
+func (ps *PlanService) SavePlan(id models.TenantPlan, req *PlanRequest) (*models.Plan, error) {
+	if !slugPattern.MatchString(string(id)) {
+		return nil, fmt.Errorf("invalid plan ID: use lowercase letters, digits and hyphens")
+	}
+	if req.PricePerMonth < 0 || req.CPUHourPrice < 0 || req.MemoryHourPrice < 0 {
+		return nil, fmt.Errorf("prices cannot be negative")
+	}
+	for _, limit := range []int{req.MaxServices, req.MaxSeats, req.CPU, req.Memory, req.Disk, req.Allocations, req.Instances,
+		req.IncludedCPUHours, req.IncludedMemoryHours} {
+		if limit < 0 {
+			return nil, fmt.Errorf("limits cannot be negative")
+		}
+	}
+	for _, limits := range []models.RateLimits{req.UserRateLimits, req.TenantRateLimits, req.APIKeyRateLimits} {
+		if limits.Auth < 0 || limits.Read < 0 || limits.Write < 0 || limits.Deploy < 0 {
+			return nil, fmt.Errorf("rate limits cannot be negative")
+		}
+	}
+
+	plan := &models.Plan{
+		ID:            id,
+		Name:          strings.TrimSpace(req.Name),
+		Description:   req.Description,
+		PricePerMonth: req.PricePerMonth,
+		MaxServices:   req.MaxServices,
+		MaxSeats:      req.MaxSeats,
+		CPU:           req.CPU,
+		Memory:        req.Memory,
+		Disk:          req.Disk,
+		Allocations:   req.Allocations,
+		Instances:     req.Instances,
+		Features:      req.Features,
+		Public:        req.Public,
+		SortOrder:     req.SortOrder,
+
+		IncludedCPUHours:    req.IncludedCPUHours,
+		IncludedMemoryHours: req.IncludedMemoryHours,
+		CPUHourPrice:        req.CPUHourPrice,
+		MemoryHourPrice:     req.MemoryHourPrice,
+
+		UserRateLimits:   req.UserRateLimits,
+		TenantRateLimits: req.TenantRateLimits,
+		APIKeyRateLimits: req.APIKeyRateLimits,
+	}
+	if plan.Features == nil {
+		plan.Features = []string{}
+	}
+
+	var tenants []models.Tenant
+	err := ps.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := getPlan(tx, id)
+		if err != nil && !errors.Is(err, ErrPlanNotFound) {
+			return err
+		}
+
+		if existing != nil {
+			plan.CreatedAt = existing.CreatedAt
+		}
+		if err := tx.Save(plan).Error; err != nil {
+			return fmt.Errorf("failed to save plan: %w", err)
+		}
+		if existing == nil {
+			return nil
+		}
+
+		if err := tx.Model(&models.Tenant{}).
+			Where("plan = ? AND max_services = ?", id, existing.MaxServices).
+			Update("max_services", plan.MaxServices).Error; err != nil {
+			return fmt.Errorf("failed to update tenant limits: %w", err)
+		}
+		if err := tx.Model(&models.Subscription{}).Where("plan = ?", id).Updates(map[string]interface{}{
+			"price_per_month": plan.PricePerMonth,
+			"max_services":    gorm.Expr("(SELECT max_services FROM tenants WHERE tenants.id = subscriptions.tenant_id)"),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update subscriptions: %w", err)
+		}
+		return tx.Where("plan = ?", id).Find(&tenants).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	ps.forgetCachedPlan(id)
+
+	for i := range tenants {
+		ps.quotaService.SyncNomadQuota(&tenants[i])
+	}
+	return plan, nil
+}
+
+// CurrentPlan returns a tenant's plan, its effective service limit and its
+// subscription, if any
+func (ps *PlanService) CurrentPlan(tenantID uuid.UUID) (*CurrentPlan, error) {
+	var tenant models.Tenant
+	if err := ps.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	plan, err := getPlan(ps.db, tenant.Plan)
+	if err != nil {
+		return nil, err
+	}
+
+	current := &CurrentPlan{Plan: plan, MaxServices: tenant.MaxServices}
+	var subscription models.Subscription
+	err = ps.db.Where("tenant_id = ?", tenant.ID).Order("created_at DESC").First(&subscription).Error
+	if err == nil {
+		current.Subscription = &subscription
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	return current, nil
+}
+
+// PreviewChange reports whether a tenant's current usage fits a plan
+func (ps *PlanService) PreviewChange(tenantID uuid.UUID, planID models.TenantPlan, includePrivate bool) (*PlanChangePreview, error) {
+	tenant, plan, err := ps.loadChange(tenantID, planID, includePrivate)
+	if err != nil {
+		return nil, err
+	}
+
+	violations, err := ps.violations(tenant, plan)
+	if err != nil {
+		return nil, err
+	}
+	return &PlanChangePreview{
+		CurrentPlan: tenant.Plan,
+		Plan:        plan,
+		Allowed:     len(violations) == 0,
+		Violations:  violations,
+	}, nil
+}
+
+// ChangePlan moves a tenant to another plan. The change is refused with a
+// PlanChangeError while current usage exceeds any limit of the new plan. The
+// tenant's service limit, quotas and subscription follow the plan.
+func (ps *PlanService) ChangePlan(tenantID uuid.UUID, planID models.TenantPlan, includePrivate bool) (*models.Tenant, error) {
+	tenant, plan, err := ps.loadChange(tenantID, planID, includePrivate)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.Plan == plan.ID {
+		return tenant, nil
+	}
+
+	violations, err := ps.violations(tenant, plan)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		return nil, &PlanChangeError{Plan: plan.ID, Violations: violations}
+	}
+
+	previous := tenant.Plan
+	tenant.Plan = plan.ID
+	tenant.MaxServices = plan.MaxServices
+	err = ps.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(tenant).Updates(map[string]interface{}{
+			"plan":         tenant.Plan,
+			"max_services": tenant.MaxServices,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update tenant: %w", err)
+		}
+		return syncSubscription(tx, tenant, plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ps.quotaService.SyncNomadQuota(tenant)
+	logrus.WithFields(logrus.Fields{
+		"tenant_id": tenant.ID,
+		"from":      previous,
+		"to":        plan.ID,
+	}).Info("Tenant plan changed")
+	return tenant, nil
+}
+
+func (ps *PlanService) loadChange(tenantID uuid.UUID, planID models.TenantPlan, includePrivate bool) (*models.Tenant, *models.Plan, error) {
+	var tenant models.Tenant
+	if err := ps.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrTenantNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+
+	plan, err := getPlan(ps.db, planID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !plan.Public && !includePrivate && plan.ID != tenant.Plan {
+		return nil, nil, ErrPlanNotFound
+	}
+	return &tenant, plan, nil
+}
+
+// violations lists the limits of plan the tenant's current usage exceeds.
+// Admin quota overrides still apply on the new plan.
+func (ps *PlanService) violations(tenant *models.Tenant, plan *models.Plan) ([]PlanViolation, error) {
+	quota, _, err := ps.quotaService.QuotaForPlan(tenant, plan)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := ps.quotaService.Usage(tenant.ID, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	seats, err := countSeats(ps.db, tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []models.Service
+	if err := ps.db.Select("config").Where("tenant_id = ?", tenant.ID).Find(&services).Error; err != nil {
+		return nil, fmt.Errorf("failed to load tenant services: %w", err)
+	}
+	instances := 0
+	for _, service := range services {
+		if count := service.Config.InstanceCount(); count > instances {
+			instances = count
+		}
+	}
+
+	violations := []PlanViolation{}
+	for _, limit := range []PlanViolation{
+		{"services", usage.Services, quota.Services},
+		{"seats", seats, plan.MaxSeats},
+		{"cpu", usage.CPU, quota.CPU},
+		{"memory", usage.Memory, quota.Memory},
+		{"disk", usage.Disk, quota.Disk},
+		{"allocations", usage.Allocations, quota.Allocations},
+		{"instances", instances, quota.Instances},
+	} {
+		if limit.Max > 0 && limit.Used > limit.Max {
+			violations = append(violations, limit)
+		}
+	}
+	return violations, nil
+}
+
+// syncSubscription points the tenant's subscription at plan, starting a new
+// monthly subscription if the tenant has none
+func syncSubscription(tx *gorm.DB, tenant *models.Tenant, plan *models.Plan) error {
+	var subscription models.Subscription
+	err := tx.Where("tenant_id = ?", tenant.ID).Order("created_at DESC").First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		now := time.Now()
+		subscription = models.Subscription{
+			ID:                 uuid.New(),
+			TenantID:           tenant.ID,
+			Plan:               plan.ID,
+			Status:             models.SubscriptionStatusActive,
+			PricePerMonth:      plan.PricePerMonth,
+			MaxServices:        tenant.MaxServices,
+			BillingCycle:       "monthly",
+			CurrentPeriodStart: now,
+			CurrentPeriodEnd:   now.AddDate(0, 1, 0),
+		}
+		if err := tx.Create(&subscription).Error; err != nil {
+			return fmt.Errorf("failed to create subscription: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	if err := tx.Model(&subscription).Updates(map[string]interface{}{
+		"plan":            plan.ID,
+		"price_per_month": plan.PricePerMonth,
+		"max_services":    tenant.MaxServices,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update subscription: %w", err)
+	}
+	return nil
+}
+
+func getPlan(db *gorm.DB, id models.TenantPlan) (*models.Plan, error) {
+	var plan models.Plan
+	if err := db.First(&plan, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+	return &plan, nil
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrQuotaExceeded is returned when an operation would take a tenant over
+// one of its quotas
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Nomad reserves these resources for tasks that don't declare any, so
+// services without explicit resources still count against the quota
+const (
+	defaultTaskCPU    = 100 // MHz
+	defaultTaskMemory = 300 // MB
+)
+
+// Quota is a tenant's effective resource limits. Zero means unlimited.
+type Quota struct {
+	Services    int `json:"services"`
+	CPU         int `json:"cpu"`         // MHz
+	Memory      int `json:"memory"`      // MB
+	Disk        int `json:"disk"`        // MB
+	Allocations int `json:"allocations"` // running instances across all services
+	Instances   int `json:"instances"`   // instances per service
+}
+
+// ResourceUsage is what a tenant's services currently reserve. Only running
+// and pending services count towards CPU, memory, disk and allocations.
+type ResourceUsage struct {
+	Services    int `json:"services"`
+	CPU         int `json:"cpu"`
+	Memory      int `json:"memory"`
+	Disk        int `json:"disk"`
+	Allocations int `json:"allocations"`
+}
+
+// QuotaReport compares a tenant's usage with its quota
+type QuotaReport struct {
+	TenantID  uuid.UUID           `json:"tenant_id"`
+	Plan      models.TenantPlan   `json:"plan"`
+	Quota     Quota               `json:"quota"`
+	Usage     ResourceUsage       `json:"usage"`
+	Overrides *models.TenantQuota `json:"overrides,omitempty"`
+}
+
+// QuotaOverridesRequest replaces a tenant's quota overrides. Omitted fields
+// fall back to the plan default; zero means unlimited.
+type QuotaOverridesRequest struct {
+	CPU         *int `json:"cpu"`
+	Memory      *int `json:"memory"`
+	Disk        *int `json:"disk"`
+	Allocations *int `json:"allocations"`
+	Instances   *int `json:"instances"`
+}
+
+type QuotaService struct {
+	db           *gorm.DB
+	nomadService *NomadService
+	config       *config.Config
+}
+
+func NewQuotaService(db *gorm.DB, nomadService *NomadService, cfg *config.Config) *QuotaService {
+	return &QuotaService{
+		db:           db,
+		nomadService: nomadService,
+		config:       cfg,
+	}
+}
+
+// Quota returns the tenant's plan quota with any admin overrides applied. The
+// service count comes from Tenant.MaxServices.
+func (qs *QuotaService) Quota(tenant *models.Tenant) (Quota, *models.TenantQuota, error) {
+	plan, err := getPlan(qs.db, tenant.Plan)
+	if err != nil {
+		return Quota{}, nil, err
+	}
+
+	quota, overrides, err := qs.QuotaForPlan(tenant, plan)
+	quota.Services = tenant.MaxServices
+	return quota, overrides, err
+}
+
+// QuotaForPlan returns the quota the tenant would have on plan, keeping its
+// admin overrides
+func (qs *QuotaService) QuotaForPlan(tenant *models.Tenant, plan *models.Plan) (Quota, *models.TenantQuota, error) {
+	quota := Quota{
+		Services:    plan.MaxServices,
+		CPU:         plan.CPU,
+		Memory:      plan.Memory,
+		Disk:        plan.Disk,
+		Allocations: plan.Allocations,
+		Instances:   plan.Instances,
+	}
+
+	var overrides models.TenantQuota
+	err := qs.db.Where("tenant_id = ?", tenant.ID).First(&overrides).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return quota, nil, nil
+	}
+	if err != nil {
+		return quota, nil, fmt.Errorf("failed to load quota overrides: %w", err)
+	}
+
+	override(&quota.CPU, overrides.CPU)
+	override(&quota.Memory, overrides.Memory)
+	override(&quota.Disk, overrides.Disk)
+	override(&quota.Allocations, overrides.Allocations)
+	override(&quota.Instances, overrides.Instances)
+	return quota, &overrides, nil
+}
+
+// Usage sums the resources reserved by a tenant's services. excludeID skips
+// the service an operation is about to change.
+func (qs *QuotaService) Usage(tenantID, excludeID uuid.UUID) (ResourceUsage, error) {
+	var services []models.Service
+	if err := qs.db.Where("tenant_id = ?", tenantID).Find(&services).Error; err != nil {
+		return ResourceUsage{}, fmt.Errorf("failed to load tenant services: %w", err)
+	}
+
+	usage := ResourceUsage{Services: len(services)}
+	for _, service := range services {
+		if service.ID == excludeID || !isActiveStatus(service.Status) {
+			continue
+		}
+		usage.add(demand(service.Config))
+	}
+	return usage, nil
+}
+
+// Report returns the quota and usage of a tenant
+func (qs *QuotaService) Report(tenantID uuid.UUID) (*QuotaReport, error) {
+	tenant, err := qs.getTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	quota, overrides, err := qs.Quota(tenant)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := qs.Usage(tenantID, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuotaReport{
+		TenantID:  tenant.ID,
+		Plan:      tenant.Plan,
+		Quota:     quota,
+		Usage:     usage,
+		Overrides: overrides,
+	}, nil
+}
+
+// Check ensures service can use cfg. The per-service instance limit always
+// applies; resources are only checked when the service runs with cfg, i.e.
+// when it is being started or is already running.
+func (qs *QuotaService) Check(service *models.Service, cfg models.ServiceConfig, running bool) error {
+	if service.TenantID == nil {
+		return nil // No quotas for system services
+	}
+
+	tenant, err := qs.getTenant(*service.TenantID)
+	if err != nil {
+		return err
+	}
+	quota, _, err := qs.Quota(tenant)
+	if err != nil {
+		return err
+	}
+
+	if quota.Instances > 0 && cfg.InstanceCount() > quota.Instances {
+		return fmt.Errorf("%w: at most %d instances per service", ErrQuotaExceeded, quota.Instances)
+	}
+	if !running {
+		return nil
+	}
+
+	usage, err := qs.Usage(tenant.ID, service.ID)
+	if err != nil {
+		return err
+	}
+	usage.add(demand(cfg))
+
+	for _, limit := range []struct {
+		name      string
+		used, max int
+		unit      string
+	}{
+		{"cpu", usage.CPU, quota.CPU, " MHz"},
+		{"memory", usage.Memory, quota.Memory, " MB"},
+		{"disk", usage.Disk, quota.Disk, " MB"},
+		{"allocations", usage.Allocations, quota.Allocations, ""},
+	} {
+		if limit.max > 0 && limit.used > limit.max {
+			return fmt.Errorf("%w: %s would be %d%s of %d%s", ErrQuotaExceeded,
+				limit.name, limit.used, limit.unit, limit.max, limit.unit)
+		}
+	}
+	return nil
+}
+
+// SetOverrides replaces a tenant's quota overrides and mirrors the result
+// into Nomad
+func (qs *QuotaService) SetOverrides(tenantID uuid.UUID, req *QuotaOverridesRequest) (*QuotaReport, error) {
+	tenant, err := qs.getTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range []*int{req.CPU, req.Memory, req.Disk, req.Allocations, req.Instances} {
+		if value != nil && *value < 0 {
+			return nil, fmt.Errorf("quotas cannot be negative")
+		}
+	}
+
+	overrides := &models.TenantQuota{
+		ID:          uuid.New(),
+		TenantID:    tenant.ID,
+		CPU:         req.CPU,
+		Memory:      req.Memory,
+		Disk:        req.Disk,
+		Allocations: req.Allocations,
+		Instances:   req.Instances,
+	}
+	if err := qs.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"cpu", "memory", "disk", "allocations", "instances", "updated_at"}),
+	}).Create(overrides).Error; err != nil {
+		return nil, fmt.Errorf("failed to save quota overrides: %w", err)
+	}
+
+	qs.SyncNomadQuota(tenant)
+	return qs.Report(tenantID)
+}
+
+// SyncNomadQuota mirrors the tenant's CPU and memory quota into a Nomad quota
+// spec when NOMAD_QUOTAS_ENABLED is set. Quota specs need Nomad Enterprise,
+// so failures are logged rather than returned.
+func (qs *QuotaService) SyncNomadQuota(tenant *models.Tenant) {
+	if !qs.config.Nomad.QuotasEnabled {
+		return
+	}
+
+	quota, _, err := qs.Quota(tenant)
+	if err == nil {
+		err = qs.nomadService.RegisterQuota(context.Background(), NomadQuotaName(tenant), "Quota for tenant "+tenant.Name, quota.CPU, quota.Memory)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("tenant_id", tenant.ID).Warn("Failed to sync Nomad quota")
+	}
+}
+
+// NomadQuotaName is the name of a tenant's Nomad quota spec, which matches
+// its namespace
+func NomadQuotaName(tenant *models.Tenant) string {
+	if tenant.NomadNamespace != "" {
+		return tenant.NomadNamespace
+	}
+	return NamespaceName(tenant)
+}
+
+func (qs *QuotaService) getTenant(tenantID uuid.UUID) (*models.Tenant, error) {
+	var tenant models.Tenant
+	if err := qs.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	return &tenant, nil
+}
+
+func (u *ResourceUsage) add(other ResourceUsage) {
+	u.CPU += other.CPU
+	u.Memory += other.Memory
+	u.Disk += other.Disk
+	u.Allocations += other.Allocations
+}
+
+// demand returns the resources a service reserves with cfg
+func demand(cfg models.ServiceConfig) ResourceUsage {
+	instances := cfg.InstanceCount()
+	cpu, memory := cfg.Resources.CPU, cfg.Resources.Memory
+	if cpu <= 0 {
+		cpu = defaultTaskCPU
+	}
+	if memory <= 0 {
+		memory = defaultTaskMemory
+	}
+
+	return ResourceUsage{
+		CPU:         cpu * instances,
+		Memory:      memory * instances,
+		Disk:        cfg.Resources.Disk * instances,
+		Allocations: instances,
+	}
+}
+
+func isActiveStatus(status models.ServiceStatus) bool {
+	return status == models.ServiceStatusRunning || status == models.ServiceStatusPending
+}
+
+func override(value *int, override *int) {
+	if override != nil {
+		*value = *override
+	}
+}
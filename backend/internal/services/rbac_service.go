@@ -0,0 +1,322 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// tenantPermissions are the permissions that can be granted within a tenant.
+// Platform-wide admin:* permissions are reserved for system admins.
+var tenantPermissions = []models.Permission{
+	models.PermissionServiceRead,
+	models.PermissionServiceCreate,
+	models.PermissionServiceUpdate,
+	models.PermissionServiceDelete,
+	models.PermissionServiceDeploy,
+	models.PermissionServiceLogs,
+	models.PermissionServiceMetrics,
+	models.PermissionTemplateRead,
+	models.PermissionTenantRead,
+	models.PermissionTenantManage,
+	models.PermissionTenantBilling,
+	models.PermissionUserInvite,
+	models.PermissionUserManage,
+	models.PermissionRoleManage,
+	models.PermissionAuditRead,
+}
+
+// builtinRolePermissions maps each built-in role to its permissions
+var builtinRolePermissions = map[models.UserRole][]models.Permission{
+	models.UserRoleAdmin: append(append([]models.Permission{}, tenantPermissions...),
+		models.PermissionAdminUsers,
+		models.PermissionAdminTenants,
+		models.PermissionAdminAudit,
+		models.PermissionAdminSystem,
+	),
+	models.UserRoleTenantAdmin: tenantPermissions,
+	models.UserRoleUser: {
+		models.PermissionServiceRead,
+		models.PermissionServiceCreate,
+		models.PermissionServiceUpdate,
+		models.PermissionServiceDelete,
+		models.PermissionServiceDeploy,
+		models.PermissionServiceLogs,
+		models.PermissionServiceMetrics,
+		models.PermissionTemplateRead,
+		models.PermissionTenantRead,
+	},
+}
+
+var ErrRoleNotFound = errors.New("role not found")
+
+// BuiltinRole describes a built-in role and its permissions
+type BuiltinRole struct {
+	Name        models.UserRole     `json:"name"`
+	Permissions []models.Permission `json:"permissions"`
+}
+
+// RoleRequest represents a custom role creation or update request
+type RoleRequest struct {
+	Name        string              `json:"name" binding:"required"`
+	Description string              `json:"description"`
+	Permissions []models.Permission `json:"permissions" binding:"required"`
+}
+
+// AssignRoleRequest sets a tenant member's built-in role and custom role.
+// A nil CustomRoleID removes the member's custom role.
+type AssignRoleRequest struct {
+	Role         models.UserRole `json:"role" binding:"required"`
+	CustomRoleID *uuid.UUID      `json:"custom_role_id"`
+}
+
+type RBACService struct {
+	db *gorm.DB
+}
+
+func NewRBACService(db *gorm.DB) *RBACService {
+	return &RBACService{db: db}
+}
+
+// BuiltinRoles returns the built-in roles and their permissions
+func (rs *RBACService) BuiltinRoles() []BuiltinRole {
+	return []BuiltinRole{
+		{Name: models.UserRoleAdmin, Permissions: builtinRolePermissions[models.UserRoleAdmin]},
+		{Name: models.UserRoleTenantAdmin, Permissions: builtinRolePermissions[models.UserRoleTenantAdmin]},
+		{Name: models.UserRoleUser, Permissions: builtinRolePermissions[models.UserRoleUser]},
+	}
+}
+
+// Permissions returns the effective permissions of a user. System admins
+// always keep their built-in permissions; anyone else with a custom role gets
+// the custom role's permissions.
+func (rs *RBACService) Permissions(user *models.User) ([]models.Permission, error) {
+	if user.Role == models.UserRoleAdmin || user.CustomRoleID == nil {
+		return builtinRolePermissions[user.Role], nil
+	}
+
+	role := user.CustomRole
+	if role == nil || role.ID != *user.CustomRoleID {
+		role = &models.Role{}
+		if err := rs.db.First(role, "id = ?", *user.CustomRoleID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load custom role: %w", err)
+		}
+	}
+	return role.Permissions, nil
+}
+
+// HasPermission reports whether the user holds every one of perms
+func (rs *RBACService) HasPermission(user *models.User, perms ...models.Permission) (bool, error) {
+	granted, err := rs.Permissions(user)
+	if err != nil {
+		return false, err
+	}
+
+	for _, perm := range perms {
+		if !containsPermission(granted, perm) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ListRoles returns the custom roles of a tenant
+func (rs *RBACService) ListRoles(tenantID uuid.UUID) ([]models.Role, error) {
+	var roles []models.Role
+	if err := rs.db.Where("tenant_id = ?", tenantID).Order("name").Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+// GetRole retrieves a custom role of a tenant
+func (rs *RBACService) GetRole(tenantID, roleID uuid.UUID) (*models.Role, error) {
+	var role models.Role
+	if err := rs.db.Where("id = ? AND tenant_id = ?", roleID, tenantID).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return &role, nil
+}
+
+// CreateRole defines a custom role for a tenant. The actor can only grant
+// permissions they hold themselves.
+func (rs *RBACService) CreateRole(tenantID uuid.UUID, req *RoleRequest, actor *models.User) (*models.Role, error) {
+	if err := rs.validateRole(tenantID, uuid.Nil, req); err != nil {
+		return nil, err
+	}
+	if err := rs.checkGrantable(actor, req.Permissions); err != nil {
+		return nil, err
+	}
+
+	role := &models.Role{
+		ID:          uuid.New(),
+		TenantID:    tenantID,
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	if err := rs.db.Create(role).Error; err != nil {
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+	return role, nil
+}
+
+// UpdateRole replaces a custom role's name, description and permissions
+func (rs *RBACService) UpdateRole(tenantID, roleID uuid.UUID, req *RoleRequest, actor *models.User) (*models.Role, error) {
+	role, err := rs.GetRole(tenantID, roleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := rs.validateRole(tenantID, roleID, req); err != nil {
+		return nil, err
+	}
+	if err := rs.checkGrantable(actor, req.Permissions); err != nil {
+		return nil, err
+	}
+
+	role.Name = strings.TrimSpace(req.Name)
+	role.Description = req.Description
+	role.Permissions = req.Permissions
+	if err := rs.db.Save(role).Error; err != nil {
+		return nil, fmt.Errorf("failed to update role: %w", err)
+	}
+	return role, nil
+}
+
+// DeleteRole removes a custom role. Members holding it fall back to their
+// built-in role.
+func (rs *RBACService) DeleteRole(tenantID, roleID uuid.UUID) error {
+	if _, err := rs.GetRole(tenantID, roleID); err != nil {
+		return err
+	}
+
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.TenantMembership{}).
+			Where("custom_role_id = ?", roleID).
+			Update("custom_role_id", nil).Error; err != nil {
+			return fmt.Errorf("failed to unassign role: %w", err)
+		}
+		if err := tx.Model(&models.Invitation{}).
+			Where("custom_role_id = ?", roleID).
+			Update("custom_role_id", nil).Error; err != nil {
+			return fmt.Errorf("failed to unassign role: %w", err)
+		}
+		if err := tx.Delete(&models.Role{}, "id = ?", roleID).Error; err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+		return nil
+	})
+}
+
+// AssignRole sets the built-in and custom role of a member of the tenant.
+// Tenant members cannot be promoted to system admin, and the actor can only
+// grant permissions they hold themselves.
+func (rs *RBACService) AssignRole(tenantID, userID uuid.UUID, req *AssignRoleRequest, actor *models.User) (*models.TenantMembership, error) {
+	if err := rs.CheckAssignable(tenantID, actor, req.Role, req.CustomRoleID); err != nil {
+		return nil, err
+	}
+
+	var membership models.TenantMembership
+	if err := rs.db.Preload("User").Where("user_id = ? AND tenant_id = ?", userID, tenantID).
+		First(&membership).Error; err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if membership.User != nil && membership.User.Role == models.UserRoleAdmin {
+		return nil, fmt.Errorf("cannot change the role of a system admin")
+	}
+
+	if err := rs.db.Model(&membership).Updates(map[string]interface{}{
+		"role":           req.Role,
+		"custom_role_id": req.CustomRoleID,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	membership.Role = req.Role
+	membership.CustomRoleID = req.CustomRoleID
+	return &membership, nil
+}
+
+// CheckAssignable ensures a tenant member role and optional custom role exist
+// and that the actor holds every permission they grant
+func (rs *RBACService) CheckAssignable(tenantID uuid.UUID, actor *models.User, role models.UserRole, customRoleID *uuid.UUID) error {
+	if role != models.UserRoleUser && role != models.UserRoleTenantAdmin {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	granted := builtinRolePermissions[role]
+	if customRoleID != nil {
+		customRole, err := rs.GetRole(tenantID, *customRoleID)
+		if err != nil {
+			return err
+		}
+		granted = customRole.Permissions
+	}
+	return rs.checkGrantable(actor, granted)
+}
+
+func (rs *RBACService) validateRole(tenantID, roleID uuid.UUID, req *RoleRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return fmt.Errorf("role name is required")
+	}
+	if _, builtin := builtinRolePermissions[models.UserRole(name)]; builtin {
+		return fmt.Errorf("role name %q is reserved", name)
+	}
+
+	for _, perm := range req.Permissions {
+		if !containsPermission(tenantPermissions, perm) {
+			return fmt.Errorf("unknown permission: %s", perm)
+		}
+	}
+
+	var count int64
+	query := rs.db.Model(&models.Role{}).Where("tenant_id = ? AND name = ?", tenantID, name)
+	if roleID != uuid.Nil {
+		query = query.Where("id <> ?", roleID)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check role name: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("role %q already exists", name)
+	}
+	return nil
+}
+
+// checkGrantable ensures the actor holds every permission being granted
+func (rs *RBACService) checkGrantable(actor *models.User, perms []models.Permission) error {
+	held, err := rs.Permissions(actor)
+	if err != nil {
+		return err
+	}
+	for _, perm := range perms {
+		if !containsPermission(held, perm) {
+			return fmt.Errorf("cannot grant permission you do not hold: %s", perm)
+		}
+	}
+	return nil
+}
+
+// IsValidRole reports whether role is a built-in role
+func IsValidRole(role models.UserRole) bool {
+	_, ok := builtinRolePermissions[role]
+	return ok
+}
+
+func containsPermission(perms []models.Permission, perm models.Permission) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrServiceNotFound is returned for services that do not exist or are
+// outside the caller's scope, so other tenants' services are never disclosed
+var ErrServiceNotFound = errors.New("service not found")
+
+// Scope binds data access to the caller's tenant. Users without a tenant only
+// see system resources; AllTenants lets system admins cross tenants and must
+// be requested explicitly.
+type Scope struct {
+	UserID     uuid.UUID
+	TenantID   *uuid.UUID
+	AllTenants bool
+}
+
+// Apply restricts query to rows whose tenant column matches the scope. The
+// condition is ANDed to the query's own, so they must not use a bare Or.
+func (s Scope) Apply(query *gorm.DB, column string) *gorm.DB {
+	if s.AllTenants {
+		return query
+	}
+	if s.TenantID == nil {
+		return query.Where(column + " IS NULL")
+	}
+	return query.Where(column+" = ?", *s.TenantID)
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunDB returns a database handle that builds PostgreSQL statements
+// without connecting
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1 dbname=dry_run"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open dry run database: %v", err)
+	}
+	return db
+}
+
+func TestScopeApply(t *testing.T) {
+	db := dryRunDB(t)
+	serviceID := uuid.New()
+	tenantID := uuid.New()
+
+	tests := []struct {
+		name    string
+		scope   Scope
+		want    string
+		notWant string
+	}{
+		{
+			name:  "tenant",
+			scope: Scope{UserID: uuid.New(), TenantID: &tenantID},
+			want:  "WHERE id = '" + serviceID.String() + "' AND tenant_id = '" + tenantID.String() + "'",
+		},
+		{
+			name:    "no tenant",
+			scope:   Scope{UserID: uuid.New()},
+			want:    "WHERE id = '" + serviceID.String() + "' AND tenant_id IS NULL",
+			notWant: "tenant_id =",
+		},
+		{
+			name:    "all tenants",
+			scope:   Scope{UserID: uuid.New(), AllTenants: true},
+			notWant: "tenant_id",
+		},
+		{
+			name:    "all tenants with a tenant",
+			scope:   Scope{UserID: uuid.New(), TenantID: &tenantID, AllTenants: true},
+			notWant: "tenant_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+				var service models.Service
+				return tt.scope.Apply(tx.Where("id = ?", serviceID), "tenant_id").First(&service)
+			})
+
+			if !strings.Contains(sql, "id = '"+serviceID.String()+"'") {
+				t.Errorf("scope dropped the query's own conditions: %s", sql)
+			}
+			if tt.want != "" && !strings.Contains(sql, tt.want) {
+				t.Errorf("got %s, want it to contain %q", sql, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(sql, tt.notWant) {
+				t.Errorf("got %s, want it not to contain %q", sql, tt.notWant)
+			}
+		})
+	}
+}
@@ -1,26 +1,44 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/metrics"
 	"nomad-services-api/internal/models"
+	"nomad-services-api/internal/tracing"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
 	"gorm.io/gorm"
 )
 
+// ErrTenantSuspended is returned when a suspended tenant tries to create or
+// start services
+var ErrTenantSuspended = errors.New("tenant is suspended")
+
 type ServiceManager struct {
-	nomadService *NomadService
-	config       *config.Config
-	db           *gorm.DB
+	nomadService     *NomadService
+	quotaService     *QuotaService
+	namespaceService *NamespaceService
+	config           *config.Config
+	db               *gorm.DB
+	stop             chan struct{}
+	reconciledAt     atomic.Int64 // unix nanoseconds of the last finished reconcile pass
 }
 
-func NewServiceManager(nomadService *NomadService, cfg *config.Config) *ServiceManager {
+func NewServiceManager(nomadService *NomadService, quotaService *QuotaService, namespaceService *NamespaceService, cfg *config.Config) *ServiceManager {
 	return &ServiceManager{
-		nomadService: nomadService,
-		config:       cfg,
+		nomadService:     nomadService,
+		quotaService:     quotaService,
+		namespaceService: namespaceService,
+		config:           cfg,
+		stop:             make(chan struct{}),
 	}
 }
 
@@ -28,14 +46,20 @@ func (sm *ServiceManager) SetDB(db *gorm.DB) {
 	sm.db = db
 }
 
-// CreateService creates a new service with the constraint of one instance per service type per tenant
-func (sm *ServiceManager) CreateService(req *CreateServiceRequest, userID uuid.UUID, tenantID *uuid.UUID) (*models.Service, error) {
+// CreateService creates a new service with the constraint of one instance per service type per tenant.
+// The service belongs to the scope's tenant.
+func (sm *ServiceManager) CreateService(ctx context.Context, scope Scope, req *CreateServiceRequest) (*models.Service, error) {
+	userID, tenantID := scope.UserID, scope.TenantID
+
 	// Check if service already exists for this tenant
-	if err := sm.validateServiceUniqueness(req.Name, req.Type, tenantID); err != nil {
+	if err := sm.validateServiceUniqueness(req.Name, req.Type, tenantID, uuid.Nil); err != nil {
 		return nil, err
 	}
 
 	// Check tenant limits
+	if err := sm.checkSuspended(tenantID); err != nil {
+		return nil, err
+	}
 	if err := sm.validateTenantLimits(tenantID); err != nil {
 		return nil, err
 	}
@@ -51,11 +75,15 @@ func (sm *ServiceManager) CreateService(req *CreateServiceRequest, userID uuid.U
 		TenantID:    tenantID,
 	}
 
-	if err := sm.db.Create(service).Error; err != nil {
+	if err := sm.quotaService.Check(service, service.Config, false); err != nil {
+		return nil, err
+	}
+
+	if err := sm.db.WithContext(ctx).Create(service).Error; err != nil {
 		return nil, fmt.Errorf("failed to create service: %w", err)
 	}
 
-	logrus.WithFields(logrus.Fields{
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"service_id":   service.ID,
 		"service_name": service.Name,
 		"service_type": service.Type,
@@ -67,12 +95,12 @@ func (sm *ServiceManager) CreateService(req *CreateServiceRequest, userID uuid.U
 }
 
 // StartService starts a service (deploys to Nomad)
-func (sm *ServiceManager) StartService(serviceID uuid.UUID, userID uuid.UUID) (*models.ServiceDeployment, error) {
-	// Get service
-	var service models.Service
-	if err := sm.db.First(&service, serviceID).Error; err != nil {
-		return nil, fmt.Errorf("service not found: %w", err)
+func (sm *ServiceManager) StartService(ctx context.Context, scope Scope, serviceID uuid.UUID) (*models.ServiceDeployment, error) {
+	service, err := sm.GetService(ctx, scope, serviceID)
+	if err != nil {
+		return nil, err
 	}
+	userID := scope.UserID
 
 	// Check if service is already running
 	if service.Status == models.ServiceStatusRunning {
@@ -81,7 +109,7 @@ func (sm *ServiceManager) StartService(serviceID uuid.UUID, userID uuid.UUID) (*
 
 	// Check for existing running deployment
 	var existingDeployment models.ServiceDeployment
-	err := sm.db.Where("service_id = ? AND status IN (?)", serviceID, 
+	err = sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", serviceID, 
 		[]models.DeploymentStatus{models.DeploymentStatusPending, models.DeploymentStatusRunning}).
 		First(&existingDeployment).Error
 	
@@ -89,31 +117,47 @@ func (sm *ServiceManager) StartService(serviceID uuid.UUID, userID uuid.UUID) (*
 		return nil, fmt.Errorf("service deployment already in progress")
 	}
 
+	if err := sm.checkSuspended(service.TenantID); err != nil {
+		return nil, err
+	}
+	if err := sm.checkBudget(service.TenantID); err != nil {
+		return nil, err
+	}
+	if err := sm.quotaService.Check(service, service.Config, true); err != nil {
+		return nil, err
+	}
+
 	// Generate tenant ID for job naming
 	tenantID := "default"
 	if service.TenantID != nil {
 		tenantID = service.TenantID.String()[:8] // Use first 8 chars of UUID
 	}
 
+	// Deploy into the tenant's namespace
+	target, err := sm.namespaceService.Target(ctx, service.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare Nomad namespace: %w", err)
+	}
+
 	// Deploy service
-	deployment, err := sm.nomadService.DeployService(&service, tenantID)
+	deployment, err := sm.nomadService.DeployService(ctx, service, tenantID, target)
 	if err != nil {
 		return nil, fmt.Errorf("failed to deploy service: %w", err)
 	}
 
 	// Save deployment
 	deployment.DeployedBy = userID
-	if err := sm.db.Create(deployment).Error; err != nil {
+	if err := sm.db.WithContext(ctx).Create(deployment).Error; err != nil {
 		return nil, fmt.Errorf("failed to save deployment: %w", err)
 	}
 
 	// Update service status
 	service.Status = models.ServiceStatusPending
-	if err := sm.db.Save(&service).Error; err != nil {
-		logrus.WithError(err).Error("Failed to update service status")
+	if err := sm.db.WithContext(ctx).Save(service).Error; err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Failed to update service status")
 	}
 
-	logrus.WithFields(logrus.Fields{
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"service_id":     service.ID,
 		"deployment_id":  deployment.ID,
 		"nomad_job_id":   deployment.NomadJobID,
@@ -123,13 +167,59 @@ func (sm *ServiceManager) StartService(serviceID uuid.UUID, userID uuid.UUID) (*
 	return deployment, nil
 }
 
+// ListTemplates returns the templates for the job files in the jobs directory
+func (sm *ServiceManager) ListTemplates() ([]models.ServiceTemplate, error) {
+	return sm.nomadService.GetAvailableJobTemplates()
+}
+
+// PlanDeployment asks Nomad what starting the service, or redeploying its
+// current job with the stored configuration, would do without changing
+// anything. Suspended tenants, capped budgets and quota violations fail as
+// StartService would.
+func (sm *ServiceManager) PlanDeployment(ctx context.Context, scope Scope, serviceID uuid.UUID) (*JobPlan, error) {
+	service, err := sm.GetService(ctx, scope, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sm.checkSuspended(service.TenantID); err != nil {
+		return nil, err
+	}
+	if err := sm.checkBudget(service.TenantID); err != nil {
+		return nil, err
+	}
+	if err := sm.quotaService.Check(service, service.Config, true); err != nil {
+		return nil, err
+	}
+
+	// Plan against the job that is already deployed, so the plan shows the
+	// changes to it
+	var deployment models.ServiceDeployment
+	err = sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", serviceID,
+		[]models.DeploymentStatus{models.DeploymentStatusPending, models.DeploymentStatusRunning}).
+		Order("created_at DESC").First(&deployment).Error
+	if err == nil {
+		return sm.nomadService.PlanService(ctx, service, deployment.NomadJobID, sm.namespaceService.DeploymentTarget(service, &deployment))
+	}
+
+	tenantID := "default"
+	if service.TenantID != nil {
+		tenantID = service.TenantID.String()[:8]
+	}
+	target, err := sm.namespaceService.Target(ctx, service.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare Nomad namespace: %w", err)
+	}
+	return sm.nomadService.PlanService(ctx, service, NewJobID(tenantID, service), target)
+}
+
 // StopService stops a running service
-func (sm *ServiceManager) StopService(serviceID uuid.UUID, userID uuid.UUID) error {
-	// Get service
-	var service models.Service
-	if err := sm.db.First(&service, serviceID).Error; err != nil {
-		return fmt.Errorf("service not found: %w", err)
+func (sm *ServiceManager) StopService(ctx context.Context, scope Scope, serviceID uuid.UUID) error {
+	service, err := sm.GetService(ctx, scope, serviceID)
+	if err != nil {
+		return err
 	}
+	userID := scope.UserID
 
 	// Check if service is running
 	if service.Status != models.ServiceStatusRunning {
@@ -138,24 +228,24 @@ func (sm *ServiceManager) StopService(serviceID uuid.UUID, userID uuid.UUID) err
 
 	// Get active deployment
 	var deployment models.ServiceDeployment
-	if err := sm.db.Where("service_id = ? AND status IN (?)", serviceID,
+	if err := sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", serviceID,
 		[]models.DeploymentStatus{models.DeploymentStatusRunning, models.DeploymentStatusCompleted}).
 		Order("created_at DESC").First(&deployment).Error; err != nil {
 		return fmt.Errorf("no active deployment found: %w", err)
 	}
 
 	// Stop service in Nomad
-	if err := sm.nomadService.StopService(deployment.NomadJobID); err != nil {
+	if err := sm.nomadService.StopService(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID); err != nil {
 		return fmt.Errorf("failed to stop service in Nomad: %w", err)
 	}
 
 	// Update service status
 	service.Status = models.ServiceStatusStopped
-	if err := sm.db.Save(&service).Error; err != nil {
+	if err := sm.db.WithContext(ctx).Save(service).Error; err != nil {
 		return fmt.Errorf("failed to update service status: %w", err)
 	}
 
-	logrus.WithFields(logrus.Fields{
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"service_id":    service.ID,
 		"nomad_job_id":  deployment.NomadJobID,
 		"user_id":       userID,
@@ -165,12 +255,12 @@ func (sm *ServiceManager) StopService(serviceID uuid.UUID, userID uuid.UUID) err
 }
 
 // RestartService restarts a running service
-func (sm *ServiceManager) RestartService(serviceID uuid.UUID, userID uuid.UUID) error {
-	// Get service
-	var service models.Service
-	if err := sm.db.First(&service, serviceID).Error; err != nil {
-		return fmt.Errorf("service not found: %w", err)
+func (sm *ServiceManager) RestartService(ctx context.Context, scope Scope, serviceID uuid.UUID) error {
+	service, err := sm.GetService(ctx, scope, serviceID)
+	if err != nil {
+		return err
 	}
+	userID := scope.UserID
 
 	// Check if service is running
 	if service.Status != models.ServiceStatusRunning {
@@ -179,18 +269,18 @@ func (sm *ServiceManager) RestartService(serviceID uuid.UUID, userID uuid.UUID)
 
 	// Get active deployment
 	var deployment models.ServiceDeployment
-	if err := sm.db.Where("service_id = ? AND status IN (?)", serviceID,
+	if err := sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", serviceID,
 		[]models.DeploymentStatus{models.DeploymentStatusRunning, models.DeploymentStatusCompleted}).
 		Order("created_at DESC").First(&deployment).Error; err != nil {
 		return fmt.Errorf("no active deployment found: %w", err)
 	}
 
 	// Restart service in Nomad
-	if err := sm.nomadService.RestartService(deployment.NomadJobID); err != nil {
+	if err := sm.nomadService.RestartService(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID); err != nil {
 		return fmt.Errorf("failed to restart service in Nomad: %w", err)
 	}
 
-	logrus.WithFields(logrus.Fields{
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"service_id":    service.ID,
 		"nomad_job_id":  deployment.NomadJobID,
 		"user_id":       userID,
@@ -199,30 +289,25 @@ func (sm *ServiceManager) RestartService(serviceID uuid.UUID, userID uuid.UUID)
 	return nil
 }
 
-// GetService retrieves a service by ID
-func (sm *ServiceManager) GetService(serviceID uuid.UUID, tenantID *uuid.UUID) (*models.Service, error) {
+// GetService retrieves a service by ID within the caller's scope
+func (sm *ServiceManager) GetService(ctx context.Context, scope Scope, serviceID uuid.UUID) (*models.Service, error) {
 	var service models.Service
-	query := sm.db.Where("id = ?", serviceID)
-	
-	if tenantID != nil {
-		query = query.Where("tenant_id = ?", tenantID)
-	}
+	query := scope.Apply(sm.db.WithContext(ctx).Where("id = ?", serviceID), "tenant_id")
 
 	if err := query.First(&service).Error; err != nil {
-		return nil, fmt.Errorf("service not found: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrServiceNotFound
+		}
+		return nil, fmt.Errorf("failed to get service: %w", err)
 	}
 
 	return &service, nil
 }
 
-// ListServices retrieves all services for a tenant
-func (sm *ServiceManager) ListServices(tenantID *uuid.UUID) ([]models.Service, error) {
+// ListServices retrieves all services within the caller's scope
+func (sm *ServiceManager) ListServices(ctx context.Context, scope Scope) ([]models.Service, error) {
 	var services []models.Service
-	query := sm.db.Preload("Deployments").Order("created_at DESC")
-	
-	if tenantID != nil {
-		query = query.Where("tenant_id = ?", tenantID)
-	}
+	query := scope.Apply(sm.db.WithContext(ctx).Preload("Deployments").Order("created_at DESC"), "tenant_id")
 
 	if err := query.Find(&services).Error; err != nil {
 		return nil, fmt.Errorf("failed to list services: %w", err)
@@ -231,17 +316,142 @@ func (sm *ServiceManager) ListServices(tenantID *uuid.UUID) ([]models.Service, e
 	return services, nil
 }
 
+// UpdateService replaces a service's name, description and configuration.
+// The type of a service cannot be changed.
+func (sm *ServiceManager) UpdateService(ctx context.Context, scope Scope, serviceID uuid.UUID, req *CreateServiceRequest) (*models.Service, error) {
+	service, err := sm.GetService(ctx, scope, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Type != service.Type {
+		return nil, fmt.Errorf("service type cannot be changed")
+	}
+
+	if err := sm.validateServiceUniqueness(req.Name, service.Type, service.TenantID, service.ID); err != nil {
+		return nil, err
+	}
+
+	// Running services keep their current deployment, but the new configuration
+	// must still fit the quota for the next start
+	if err := sm.quotaService.Check(service, req.Config, isActiveStatus(service.Status)); err != nil {
+		return nil, err
+	}
+
+	service.Name = req.Name
+	service.Description = req.Description
+	service.Config = req.Config
+
+	if err := sm.db.WithContext(ctx).Save(service).Error; err != nil {
+		return nil, fmt.Errorf("failed to update service: %w", err)
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"service_id": service.ID,
+		"user_id":    scope.UserID,
+	}).Info("Service updated")
+
+	return service, nil
+}
+
+// ScaleService sets the number of instances of a service. Running services
+// are scaled in Nomad right away.
+func (sm *ServiceManager) ScaleService(ctx context.Context, scope Scope, serviceID uuid.UUID, instances int) (*models.Service, error) {
+	service, err := sm.GetService(ctx, scope, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if instances < 1 {
+		return nil, fmt.Errorf("instances must be at least 1")
+	}
+
+	cfg := service.Config
+	cfg.Instances = instances
+	active := isActiveStatus(service.Status)
+	if active && instances > service.Config.InstanceCount() {
+		if err := sm.checkBudget(service.TenantID); err != nil {
+			return nil, err
+		}
+	}
+	if err := sm.quotaService.Check(service, cfg, active); err != nil {
+		return nil, err
+	}
+
+	if active {
+		var deployment models.ServiceDeployment
+		if err := sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", service.ID,
+			[]models.DeploymentStatus{models.DeploymentStatusPending, models.DeploymentStatusRunning, models.DeploymentStatusCompleted}).
+			Order("created_at DESC").First(&deployment).Error; err != nil {
+			return nil, fmt.Errorf("no active deployment found: %w", err)
+		}
+
+		if err := sm.nomadService.ScaleJob(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID, instances); err != nil {
+			return nil, fmt.Errorf("failed to scale service in Nomad: %w", err)
+		}
+	}
+
+	service.Config = cfg
+	if err := sm.db.WithContext(ctx).Save(service).Error; err != nil {
+		return nil, fmt.Errorf("failed to update service: %w", err)
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"service_id": service.ID,
+		"instances":  instances,
+		"user_id":    scope.UserID,
+	}).Info("Service scaled")
+
+	return service, nil
+}
+
+// DeleteService stops a service's Nomad job if it has one and deletes the
+// service with its deployments
+func (sm *ServiceManager) DeleteService(ctx context.Context, scope Scope, serviceID uuid.UUID) error {
+	service, err := sm.GetService(ctx, scope, serviceID)
+	if err != nil {
+		return err
+	}
+
+	var deployment models.ServiceDeployment
+	err = sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", service.ID,
+		[]models.DeploymentStatus{models.DeploymentStatusPending, models.DeploymentStatusRunning, models.DeploymentStatusCompleted}).
+		Order("created_at DESC").First(&deployment).Error
+	if err == nil && service.Status != models.ServiceStatusStopped {
+		if err := sm.nomadService.StopService(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID); err != nil {
+			return fmt.Errorf("failed to stop service in Nomad: %w", err)
+		}
+	}
+
+	err = sm.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("service_id = ?", service.ID).Delete(&models.ServiceDeployment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(service).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"service_id": service.ID,
+		"user_id":    scope.UserID,
+	}).Info("Service deleted")
+
+	return nil
+}
+
 // GetServiceLogs retrieves logs for a service
-func (sm *ServiceManager) GetServiceLogs(serviceID uuid.UUID, tenantID *uuid.UUID) ([]string, error) {
+func (sm *ServiceManager) GetServiceLogs(ctx context.Context, scope Scope, serviceID uuid.UUID) ([]string, error) {
 	// Get service
-	service, err := sm.GetService(serviceID, tenantID)
+	service, err := sm.GetService(ctx, scope, serviceID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get active deployment
 	var deployment models.ServiceDeployment
-	if err := sm.db.Where("service_id = ? AND status IN (?)", serviceID,
+	if err := sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", serviceID,
 		[]models.DeploymentStatus{models.DeploymentStatusRunning, models.DeploymentStatusCompleted}).
 		Order("created_at DESC").First(&deployment).Error; err != nil {
 		return []string{}, nil // No active deployment
@@ -249,7 +459,7 @@ func (sm *ServiceManager) GetServiceLogs(serviceID uuid.UUID, tenantID *uuid.UUI
 
 	// Get logs from Nomad
 	taskName := service.Name // Default task name
-	logs, err := sm.nomadService.GetServiceLogs(deployment.NomadJobID, taskName)
+	logs, err := sm.nomadService.GetServiceLogs(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID, taskName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get service logs: %w", err)
 	}
@@ -258,23 +468,23 @@ func (sm *ServiceManager) GetServiceLogs(serviceID uuid.UUID, tenantID *uuid.UUI
 }
 
 // GetServiceMetrics retrieves metrics for a service
-func (sm *ServiceManager) GetServiceMetrics(serviceID uuid.UUID, tenantID *uuid.UUID) (map[string]interface{}, error) {
+func (sm *ServiceManager) GetServiceMetrics(ctx context.Context, scope Scope, serviceID uuid.UUID) (map[string]interface{}, error) {
 	// Get service
-	_, err := sm.GetService(serviceID, tenantID)
+	service, err := sm.GetService(ctx, scope, serviceID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get active deployment
 	var deployment models.ServiceDeployment
-	if err := sm.db.Where("service_id = ? AND status IN (?)", serviceID,
+	if err := sm.db.WithContext(ctx).Where("service_id = ? AND status IN (?)", serviceID,
 		[]models.DeploymentStatus{models.DeploymentStatusRunning, models.DeploymentStatusCompleted}).
 		Order("created_at DESC").First(&deployment).Error; err != nil {
 		return map[string]interface{}{"status": "no_active_deployment"}, nil
 	}
 
 	// Get metrics from Nomad
-	metrics, err := sm.nomadService.GetServiceMetrics(deployment.NomadJobID)
+	metrics, err := sm.nomadService.GetServiceMetrics(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get service metrics: %w", err)
 	}
@@ -282,11 +492,64 @@ func (sm *ServiceManager) GetServiceMetrics(serviceID uuid.UUID, tenantID *uuid.
 	return metrics, nil
 }
 
+// StartReconciler refreshes service statuses from Nomad in the background
+// until StopReconciler is called
+func (sm *ServiceManager) StartReconciler() {
+	go sm.runReconciler(sm.config.Nomad.ReconcileInterval)
+}
+
+// StopReconciler stops the status refreshes
+func (sm *ServiceManager) StopReconciler() {
+	close(sm.stop)
+}
+
+func (sm *ServiceManager) runReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sm.reconcile()
+	for {
+		select {
+		case <-sm.stop:
+			return
+		case <-ticker.C:
+			sm.reconcile()
+		}
+	}
+}
+
+// reconcile runs one pass in a trace of its own, so a pass's queries and
+// Nomad calls are recorded together
+func (sm *ServiceManager) reconcile() {
+	ctx, span := tracing.Tracer.Start(context.Background(), "reconcile")
+	defer span.End()
+
+	start := time.Now()
+	err := sm.UpdateServiceStatus(ctx)
+	metrics.ObserveReconcile(time.Since(start), err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logrus.WithContext(ctx).WithError(err).Error("Failed to reconcile service statuses")
+		return
+	}
+	sm.reconciledAt.Store(time.Now().UnixNano())
+}
+
+// LastReconciled returns when the reconciler last finished a pass, or the
+// zero time if it hasn't yet
+func (sm *ServiceManager) LastReconciled() time.Time {
+	if at := sm.reconciledAt.Load(); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
+}
+
 // UpdateServiceStatus updates the status of services based on Nomad job status
-func (sm *ServiceManager) UpdateServiceStatus() error {
+func (sm *ServiceManager) UpdateServiceStatus(ctx context.Context) error {
 	// Get all services with pending or running status
 	var services []models.Service
-	if err := sm.db.Where("status IN (?)", 
+	if err := sm.db.WithContext(ctx).Where("status IN (?)", 
 		[]models.ServiceStatus{models.ServiceStatusPending, models.ServiceStatusRunning}).
 		Find(&services).Error; err != nil {
 		return fmt.Errorf("failed to get services for status update: %w", err)
@@ -295,15 +558,15 @@ func (sm *ServiceManager) UpdateServiceStatus() error {
 	for _, service := range services {
 		// Get latest deployment
 		var deployment models.ServiceDeployment
-		if err := sm.db.Where("service_id = ?", service.ID).
+		if err := sm.db.WithContext(ctx).Where("service_id = ?", service.ID).
 			Order("created_at DESC").First(&deployment).Error; err != nil {
 			continue
 		}
 
 		// Get job status from Nomad
-		job, err := sm.nomadService.GetJobStatus(deployment.NomadJobID)
+		job, err := sm.nomadService.GetJobStatus(ctx, sm.namespaceService.DeploymentTarget(&service, &deployment), deployment.NomadJobID)
 		if err != nil {
-			logrus.WithError(err).Errorf("Failed to get job status for %s", deployment.NomadJobID)
+			logrus.WithContext(ctx).WithError(err).Errorf("Failed to get job status for %s", deployment.NomadJobID)
 			continue
 		}
 
@@ -322,8 +585,8 @@ func (sm *ServiceManager) UpdateServiceStatus() error {
 
 		if service.Status != newStatus {
 			service.Status = newStatus
-			if err := sm.db.Save(&service).Error; err != nil {
-				logrus.WithError(err).Errorf("Failed to update service status for %s", service.ID)
+			if err := sm.db.WithContext(ctx).Save(&service).Error; err != nil {
+				logrus.WithContext(ctx).WithError(err).Errorf("Failed to update service status for %s", service.ID)
 			}
 		}
 	}
@@ -331,10 +594,150 @@ func (sm *ServiceManager) UpdateServiceStatus() error {
 	return nil
 }
 
-// validateServiceUniqueness ensures only one instance of each service type per tenant
-func (sm *ServiceManager) validateServiceUniqueness(name string, serviceType models.ServiceType, tenantID *uuid.UUID) error {
+// SuspendServices stops the running and pending services of a suspended
+// tenant. They keep their configuration and are marked suspended so
+// ResumeServices can start them again. Nomad failures are logged so one
+// service cannot block the suspension.
+func (sm *ServiceManager) SuspendServices(ctx context.Context, tenantID uuid.UUID) (int, error) {
+	var services []models.Service
+	if err := sm.db.WithContext(ctx).Where("tenant_id = ? AND status IN (?)", tenantID,
+		[]models.ServiceStatus{models.ServiceStatusPending, models.ServiceStatusRunning}).
+		Find(&services).Error; err != nil {
+		return 0, fmt.Errorf("failed to get tenant services: %w", err)
+	}
+
+	for i := range services {
+		service := &services[i]
+		log := logrus.WithContext(ctx).WithFields(logrus.Fields{"service_id": service.ID, "tenant_id": tenantID})
+
+		var deployment models.ServiceDeployment
+		if err := sm.db.WithContext(ctx).Where("service_id = ?", service.ID).Order("created_at DESC").First(&deployment).Error; err == nil {
+			if err := sm.nomadService.StopService(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID); err != nil {
+				log.WithError(err).Error("Failed to stop service of suspended tenant")
+			}
+			if err := sm.db.WithContext(ctx).Model(&models.ServiceDeployment{}).Where("service_id = ? AND status IN (?)", service.ID,
+				[]models.DeploymentStatus{models.DeploymentStatusPending, models.DeploymentStatusRunning}).
+				Updates(map[string]interface{}{"status": models.DeploymentStatusCompleted, "completed_at": time.Now()}).Error; err != nil {
+				log.WithError(err).Error("Failed to close deployment of suspended service")
+			}
+		}
+
+		if err := sm.db.WithContext(ctx).Model(service).Update("status", models.ServiceStatusSuspended).Error; err != nil {
+			return i, fmt.Errorf("failed to update service status: %w", err)
+		}
+		log.Info("Service suspended")
+	}
+
+	return len(services), nil
+}
+
+// ResumeServices starts the services stopped by SuspendServices. Services that
+// fail to start, e.g. because they no longer fit the quota, are left stopped.
+func (sm *ServiceManager) ResumeServices(ctx context.Context, tenantID uuid.UUID) (int, error) {
+	var services []models.Service
+	if err := sm.db.WithContext(ctx).Where("tenant_id = ? AND status = ?", tenantID, models.ServiceStatusSuspended).
+		Find(&services).Error; err != nil {
+		return 0, fmt.Errorf("failed to get tenant services: %w", err)
+	}
+
+	started := 0
+	for _, service := range services {
+		scope := Scope{UserID: service.CreatedBy, TenantID: &tenantID}
+		if _, err := sm.StartService(ctx, scope, service.ID); err != nil {
+			logrus.WithContext(ctx).WithError(err).WithField("service_id", service.ID).Warn("Failed to resume service")
+			if err := sm.db.WithContext(ctx).Model(&service).Update("status", models.ServiceStatusStopped).Error; err != nil {
+				logrus.WithContext(ctx).WithError(err).WithField("service_id", service.ID).Error("Failed to update service status")
+			}
+			continue
+		}
+		started++
+	}
+
+	return started, nil
+}
+
+// ScaleDownServices scales the running services of a tenant whose budget is
+// capped down to one instance. Nomad failures are logged so one service
+// cannot block the others.
+func (sm *ServiceManager) ScaleDownServices(ctx context.Context, tenantID uuid.UUID) (int, error) {
+	var services []models.Service
+	if err := sm.db.WithContext(ctx).Where("tenant_id = ? AND status IN (?)", tenantID,
+		[]models.ServiceStatus{models.ServiceStatusPending, models.ServiceStatusRunning}).
+		Find(&services).Error; err != nil {
+		return 0, fmt.Errorf("failed to get tenant services: %w", err)
+	}
+
+	scaled := 0
+	for i := range services {
+		service := &services[i]
+		if service.Config.InstanceCount() <= 1 {
+			continue
+		}
+		log := logrus.WithContext(ctx).WithFields(logrus.Fields{"service_id": service.ID, "tenant_id": tenantID})
+
+		var deployment models.ServiceDeployment
+		if err := sm.db.WithContext(ctx).Where("service_id = ?", service.ID).Order("created_at DESC").First(&deployment).Error; err != nil {
+			log.WithError(err).Error("No deployment found for service to scale down")
+			continue
+		}
+		if err := sm.nomadService.ScaleJob(ctx, sm.namespaceService.DeploymentTarget(service, &deployment), deployment.NomadJobID, 1); err != nil {
+			log.WithError(err).Error("Failed to scale down service")
+			continue
+		}
+
+		service.Config.Instances = 1
+		if err := sm.db.WithContext(ctx).Save(service).Error; err != nil {
+			return scaled, fmt.Errorf("failed to update service: %w", err)
+		}
+		log.Info("Service scaled down to one instance")
+		scaled++
+	}
+
+	return scaled, nil
+}
+
+// checkBudget fails for tenants whose spend budget is capped
+func (sm *ServiceManager) checkBudget(tenantID *uuid.UUID) error {
+	if tenantID == nil {
+		return nil
+	}
+
+	var count int64
+	if err := sm.db.Model(&models.TenantBudget{}).
+		Where("tenant_id = ? AND capped_at IS NOT NULL AND cap_action <> ?", *tenantID, models.BudgetCapNone).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check budget: %w", err)
+	}
+	if count > 0 {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+// checkSuspended fails for services of a suspended tenant
+func (sm *ServiceManager) checkSuspended(tenantID *uuid.UUID) error {
+	if tenantID == nil {
+		return nil
+	}
+
+	var tenant models.Tenant
+	if err := sm.db.Select("suspended_at").First(&tenant, "id = ?", *tenantID).Error; err != nil {
+		return fmt.Errorf("tenant not found: %w", err)
+	}
+	if tenant.SuspendedAt != nil {
+		return ErrTenantSuspended
+	}
+	return nil
+}
+
+// validateServiceUniqueness ensures only one instance of each service type per tenant.
+// excludeID skips the service being updated.
+func (sm *ServiceManager) validateServiceUniqueness(name string, serviceType models.ServiceType, tenantID *uuid.UUID, excludeID uuid.UUID) error {
 	var count int64
 	query := sm.db.Model(&models.Service{}).Where("name = ? AND type = ?", name, serviceType)
+	if excludeID != uuid.Nil {
+		query = query.Where("id <> ?", excludeID)
+	}
 	
 	if tenantID != nil {
 		query = query.Where("tenant_id = ?", tenantID)
@@ -353,7 +756,8 @@ func (sm *ServiceManager) validateServiceUniqueness(name string, serviceType mod
 	return nil
 }
 
-// validateTenantLimits checks if tenant can create more services
+// validateTenantLimits checks if tenant can create more services. A limit of
+// zero is unlimited.
 func (sm *ServiceManager) validateTenantLimits(tenantID *uuid.UUID) error {
 	if tenantID == nil {
 		return nil // No limits for system services
@@ -371,7 +775,7 @@ func (sm *ServiceManager) validateTenantLimits(tenantID *uuid.UUID) error {
 		return fmt.Errorf("failed to count tenant services: %w", err)
 	}
 
-	if int(count) >= tenant.MaxServices {
+	if tenant.MaxServices > 0 && int(count) >= tenant.MaxServices {
 		return fmt.Errorf("tenant has reached maximum number of services (%d)", tenant.MaxServices)
 	}
 
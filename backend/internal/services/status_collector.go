@@ -0,0 +1,66 @@
+package services
+
+import (
+	"nomad-services-api/internal/metrics"
+	"nomad-services-api/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// StatusCollector exports the number of deployments by status and of
+// services by status and tenant, counted in the database on each scrape
+type StatusCollector struct {
+	db          *gorm.DB
+	deployments *prometheus.Desc
+	services    *prometheus.Desc
+}
+
+func NewStatusCollector(db *gorm.DB) *StatusCollector {
+	return &StatusCollector{
+		db: db,
+		deployments: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "", "deployments"),
+			"Service deployments, by status.", []string{"status"}, nil),
+		services: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "", "services"),
+			"Services, by status and tenant. Services without a tenant have an empty tenant_id.",
+			[]string{"status", "tenant_id"}, nil),
+	}
+}
+
+func (sc *StatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sc.deployments
+	ch <- sc.services
+}
+
+// Collect counts the current rows. A failed query is reported to the scraper
+// as an invalid metric, so the other metrics are still served.
+func (sc *StatusCollector) Collect(ch chan<- prometheus.Metric) {
+	var deployments []struct {
+		Status string
+		Count  int64
+	}
+	if err := sc.db.Model(&models.ServiceDeployment{}).
+		Select("status, COUNT(*) AS count").Group("status").Scan(&deployments).Error; err != nil {
+		logrus.WithError(err).Error("Failed to count deployments for metrics")
+		ch <- prometheus.NewInvalidMetric(sc.deployments, err)
+	}
+	for _, row := range deployments {
+		ch <- prometheus.MustNewConstMetric(sc.deployments, prometheus.GaugeValue, float64(row.Count), row.Status)
+	}
+
+	var services []struct {
+		Status   string
+		TenantID string
+		Count    int64
+	}
+	if err := sc.db.Model(&models.Service{}).
+		Select("status, COALESCE(CAST(tenant_id AS TEXT), '') AS tenant_id, COUNT(*) AS count").
+		Group("status, tenant_id").Scan(&services).Error; err != nil {
+		logrus.WithError(err).Error("Failed to count services for metrics")
+		ch <- prometheus.NewInvalidMetric(sc.services, err)
+	}
+	for _, row := range services {
+		ch <- prometheus.MustNewConstMetric(sc.services, prometheus.GaugeValue, float64(row.Count), row.Status, row.TenantID)
+	}
+}
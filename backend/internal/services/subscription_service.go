@@ -0,0 +1,493 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrSubscriptionNotFound is returned for tenants without a subscription
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// Reasons a tenant is suspended, phrased to follow "because"
+const (
+	suspensionReasonExpired  = "its subscription expired without payment"
+	suspensionReasonCanceled = "its subscription was canceled"
+)
+
+// SubscriptionService advances subscriptions through their billing periods
+// and suspends tenants whose subscription ended.
+//
+// At the end of a period an active subscription renews. Paid subscriptions
+// renew as past due when billing is enabled and expire if no payment is
+// recorded within the grace period. Subscriptions set to cancel at period
+// end are canceled instead. Expired and canceled subscriptions suspend the
+// tenant; recording a payment reactivates it.
+type SubscriptionService struct {
+	db             *gorm.DB
+	serviceManager *ServiceManager
+	invoiceService *InvoiceService
+	auditService   *AuditService
+	mailService    *MailService
+	config         *config.Config
+	stop           chan struct{}
+}
+
+func NewSubscriptionService(db *gorm.DB, serviceManager *ServiceManager, invoiceService *InvoiceService, auditService *AuditService, mailService *MailService, cfg *config.Config) *SubscriptionService {
+	return &SubscriptionService{
+		db:             db,
+		serviceManager: serviceManager,
+		invoiceService: invoiceService,
+		auditService:   auditService,
+		mailService:    mailService,
+		config:         cfg,
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start runs the scheduler in the background until Stop is called. Each
+// subscription is locked while it is processed, so several API instances can
+// run the scheduler at once.
+func (ss *SubscriptionService) Start() {
+	go ss.run(ss.config.SaaS.SubscriptionCheckInterval)
+}
+
+// Stop stops the scheduler
+func (ss *SubscriptionService) Stop() {
+	close(ss.stop)
+}
+
+func (ss *SubscriptionService) run(interval time.Duration) {
+	ss.ProcessDue(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ss.stop:
+			return
+		case now := <-ticker.C:
+			ss.ProcessDue(now)
+		}
+	}
+}
+
+// ProcessDue advances every subscription whose period ended or whose grace
+// period ran out by now, then issues the invoices that are due
+func (ss *SubscriptionService) ProcessDue(now time.Time) {
+	defer ss.invoiceService.FinalizeDue(now)
+
+	var ids []uuid.UUID
+	if err := ss.db.Model(&models.Subscription{}).
+		Where("status IN (?) AND (current_period_end <= ? OR (status = ? AND current_period_start <= ?))",
+			[]models.SubscriptionStatus{models.SubscriptionStatusActive, models.SubscriptionStatusPastDue},
+			now, models.SubscriptionStatusPastDue, now.Add(-ss.config.SaaS.PaymentGracePeriod)).
+		Pluck("id", &ids).Error; err != nil {
+		logrus.WithError(err).Error("Failed to load due subscriptions")
+		return
+	}
+
+	for _, id := range ids {
+		if err := ss.process(id, now); err != nil {
+			logrus.WithError(err).WithField("subscription_id", id).Error("Failed to process subscription")
+		}
+	}
+}
+
+// process advances one subscription and applies the side effects once the
+// change is committed
+func (ss *SubscriptionService) process(id uuid.UUID, now time.Time) error {
+	var subscription models.Subscription
+	var tenant models.Tenant
+	var action string
+
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		// Another instance holds the row: it is processing it
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			First(&subscription, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&tenant, "id = ?", subscription.TenantID).Error; err != nil {
+			return fmt.Errorf("failed to get tenant: %w", err)
+		}
+
+		periodStart := subscription.CurrentPeriodStart
+		if action = ss.advance(&subscription, now); action == "" {
+			return nil
+		}
+		if err := tx.Omit(clause.Associations).Save(&subscription).Error; err != nil {
+			return fmt.Errorf("failed to update subscription: %w", err)
+		}
+
+		// Renewals bill the new period and the usage of the ended one; a
+		// canceled subscription only bills its final usage
+		switch action {
+		case AuditActionSubscriptionRenewed, AuditActionSubscriptionPastDue:
+			if _, err := ss.invoiceService.generate(tx, &tenant, &subscription, periodStart, subscription.CurrentPeriodStart, true); err != nil {
+				return err
+			}
+		case AuditActionSubscriptionCanceled:
+			if _, err := ss.invoiceService.generate(tx, &tenant, &subscription, periodStart, subscription.CurrentPeriodEnd, false); err != nil {
+				return err
+			}
+		}
+
+		switch action {
+		case AuditActionSubscriptionExpired:
+			return suspendTenant(tx, &tenant, suspensionReasonExpired, now)
+		case AuditActionSubscriptionCanceled:
+			return suspendTenant(tx, &tenant, suspensionReasonCanceled, now)
+		}
+		return nil
+	})
+	if err != nil || action == "" {
+		return err
+	}
+
+	ss.audit(nil, &subscription, action, nil, ClientInfo{})
+	switch action {
+	case AuditActionSubscriptionRenewed:
+		ss.notify(&tenant, &subscription, MailTemplateSubscriptionRenewed, nil)
+	case AuditActionSubscriptionPastDue:
+		ss.notify(&tenant, &subscription, MailTemplateSubscriptionPaymentDue, map[string]interface{}{
+			"GraceEnd": formatDate(subscription.CurrentPeriodStart.Add(ss.config.SaaS.PaymentGracePeriod)),
+		})
+	case AuditActionSubscriptionExpired, AuditActionSubscriptionCanceled:
+		ss.suspendServices(&tenant, &subscription)
+	}
+	return nil
+}
+
+// advance applies the transition that is due for subscription at now and
+// returns its audit action, or "" if nothing is due
+func (ss *SubscriptionService) advance(subscription *models.Subscription, now time.Time) string {
+	periodEnded := !now.Before(subscription.CurrentPeriodEnd)
+
+	switch {
+	case subscription.Status == models.SubscriptionStatusPastDue &&
+		(periodEnded || !now.Before(subscription.CurrentPeriodStart.Add(ss.config.SaaS.PaymentGracePeriod))):
+		subscription.Status = models.SubscriptionStatusExpired
+		return AuditActionSubscriptionExpired
+
+	case periodEnded && subscription.CancelAtPeriodEnd:
+		subscription.Status = models.SubscriptionStatusCanceled
+		subscription.CanceledAt = &now
+		return AuditActionSubscriptionCanceled
+
+	case periodEnded && subscription.Status == models.SubscriptionStatusActive:
+		// Catch up on periods missed while the scheduler was not running
+		for !now.Before(subscription.CurrentPeriodEnd) {
+			subscription.CurrentPeriodStart = subscription.CurrentPeriodEnd
+			subscription.CurrentPeriodEnd = nextPeriodEnd(subscription.CurrentPeriodStart, subscription.BillingCycle)
+		}
+		if ss.requiresPayment(subscription) {
+			subscription.Status = models.SubscriptionStatusPastDue
+			return AuditActionSubscriptionPastDue
+		}
+		return AuditActionSubscriptionRenewed
+	}
+	return ""
+}
+
+// Cancel schedules a tenant's subscription to end with its current period
+func (ss *SubscriptionService) Cancel(tenantID, userID uuid.UUID, client ClientInfo) (*models.Subscription, error) {
+	return ss.setCancelAtPeriodEnd(tenantID, userID, true, client)
+}
+
+// Resume undoes a scheduled cancellation before the period ends
+func (ss *SubscriptionService) Resume(tenantID, userID uuid.UUID, client ClientInfo) (*models.Subscription, error) {
+	return ss.setCancelAtPeriodEnd(tenantID, userID, false, client)
+}
+
+func (ss *SubscriptionService) setCancelAtPeriodEnd(tenantID, userID uuid.UUID, cancel bool, client ClientInfo) (*models.Subscription, error) {
+	var subscription models.Subscription
+	changed := false
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockSubscription(tx, tenantID, &subscription); err != nil {
+			return err
+		}
+		if subscription.Status != models.SubscriptionStatusActive && subscription.Status != models.SubscriptionStatusPastDue {
+			return fmt.Errorf("subscription is %s", subscription.Status)
+		}
+		if subscription.CancelAtPeriodEnd == cancel {
+			return nil
+		}
+
+		changed = true
+		subscription.CancelAtPeriodEnd = cancel
+		if err := tx.Model(&subscription).Update("cancel_at_period_end", cancel).Error; err != nil {
+			return fmt.Errorf("failed to update subscription: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return &subscription, nil
+	}
+
+	if cancel {
+		ss.audit(&userID, &subscription, AuditActionSubscriptionCancelScheduled, nil, client)
+		if tenant, err := ss.getTenant(tenantID); err == nil {
+			ss.notify(tenant, &subscription, MailTemplateSubscriptionCancelScheduled, nil)
+		}
+	} else {
+		ss.audit(&userID, &subscription, AuditActionSubscriptionResumed, nil, client)
+	}
+	return &subscription, nil
+}
+
+// RecordPayment settles a tenant's subscription. A past due subscription
+// becomes active for its current period; an expired or canceled one starts a
+// new period now. A suspended tenant is reactivated and its suspended
+// services are started again. The tenant's outstanding invoices are marked
+// paid.
+func (ss *SubscriptionService) RecordPayment(tenantID uuid.UUID, actorID *uuid.UUID, client ClientInfo) (*models.Subscription, error) {
+	return ss.recordPayment(tenantID, actorID, client, true)
+}
+
+// recordPayment settles the subscription, and the outstanding invoices if
+// settleInvoices is set. Payments collected for a single invoice leave the
+// other invoices alone.
+func (ss *SubscriptionService) recordPayment(tenantID uuid.UUID, actorID *uuid.UUID, client ClientInfo, settleInvoices bool) (*models.Subscription, error) {
+	payment, err := ss.settlePayment(ss.db, tenantID, settleInvoices)
+	if err != nil {
+		return nil, err
+	}
+
+	ss.paymentSettled(payment, actorID, client)
+	return &payment.subscription, nil
+}
+
+// subscriptionPayment is a payment applied by settlePayment
+type subscriptionPayment struct {
+	subscription models.Subscription
+	tenant       models.Tenant
+	reactivated  bool
+}
+
+// settlePayment applies a payment to the subscription with db, which may be
+// a transaction. The caller passes the result to paymentSettled once the
+// change is committed.
+func (ss *SubscriptionService) settlePayment(db *gorm.DB, tenantID uuid.UUID, settleInvoices bool) (*subscriptionPayment, error) {
+	var subscription models.Subscription
+	var tenant models.Tenant
+	reactivated := false
+	now := time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockSubscription(tx, tenantID, &subscription); err != nil {
+			return err
+		}
+		if err := tx.First(&tenant, "id = ?", tenantID).Error; err != nil {
+			return ErrTenantNotFound
+		}
+
+		switch subscription.Status {
+		case models.SubscriptionStatusPastDue:
+			subscription.Status = models.SubscriptionStatusActive
+		case models.SubscriptionStatusExpired, models.SubscriptionStatusCanceled:
+			subscription.Status = models.SubscriptionStatusActive
+			subscription.CurrentPeriodStart = now
+			subscription.CurrentPeriodEnd = nextPeriodEnd(now, subscription.BillingCycle)
+			subscription.CancelAtPeriodEnd = false
+			subscription.CanceledAt = nil
+		default:
+			return fmt.Errorf("subscription has no outstanding payment")
+		}
+		if err := tx.Omit(clause.Associations).Save(&subscription).Error; err != nil {
+			return fmt.Errorf("failed to update subscription: %w", err)
+		}
+		if settleInvoices {
+			if err := markInvoicesPaid(tx, tenantID, now); err != nil {
+				return err
+			}
+		}
+
+		if tenant.SuspendedAt != nil {
+			reactivated = true
+			tenant.SuspendedAt = nil
+			tenant.SuspensionReason = ""
+			if err := tx.Model(&tenant).Updates(map[string]interface{}{
+				"suspended_at":      nil,
+				"suspension_reason": "",
+			}).Error; err != nil {
+				return fmt.Errorf("failed to reactivate tenant: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &subscriptionPayment{subscription: subscription, tenant: tenant, reactivated: reactivated}, nil
+}
+
+// paymentSettled records a settled payment and notifies the tenant. A
+// reactivated tenant's suspended services are started again.
+func (ss *SubscriptionService) paymentSettled(payment *subscriptionPayment, actorID *uuid.UUID, client ClientInfo) {
+	subscription, tenant := &payment.subscription, &payment.tenant
+
+	ss.audit(actorID, subscription, AuditActionSubscriptionPaid, map[string]interface{}{
+		"amount": periodAmount(subscription),
+	}, client)
+	if !payment.reactivated {
+		ss.notify(tenant, subscription, MailTemplateSubscriptionRenewed, nil)
+		return
+	}
+
+	resumed, err := ss.serviceManager.ResumeServices(context.Background(), tenant.ID)
+	if err != nil {
+		logrus.WithError(err).WithField("tenant_id", tenant.ID).Error("Failed to resume services")
+	}
+	ss.audit(actorID, subscription, AuditActionTenantReactivated, map[string]interface{}{
+		"services_resumed": resumed,
+	}, client)
+	ss.notify(tenant, subscription, MailTemplateTenantReactivated, nil)
+}
+
+// suspendServices stops a suspended tenant's services and reports it
+func (ss *SubscriptionService) suspendServices(tenant *models.Tenant, subscription *models.Subscription) {
+	stopped, err := ss.serviceManager.SuspendServices(context.Background(), tenant.ID)
+	if err != nil {
+		logrus.WithError(err).WithField("tenant_id", tenant.ID).Error("Failed to suspend services")
+	}
+
+	ss.audit(nil, subscription, AuditActionTenantSuspended, map[string]interface{}{
+		"reason":           tenant.SuspensionReason,
+		"services_stopped": stopped,
+	}, ClientInfo{})
+	ss.notify(tenant, subscription, MailTemplateTenantSuspended, map[string]interface{}{
+		"Reason": tenant.SuspensionReason,
+	})
+	logrus.WithFields(logrus.Fields{
+		"tenant_id": tenant.ID,
+		"reason":    tenant.SuspensionReason,
+	}).Warn("Tenant suspended")
+}
+
+func (ss *SubscriptionService) audit(userID *uuid.UUID, subscription *models.Subscription, action string, details map[string]interface{}, client ClientInfo) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["subscription_id"] = subscription.ID
+	details["plan"] = subscription.Plan
+	details["status"] = subscription.Status
+	details["current_period_end"] = subscription.CurrentPeriodEnd
+
+	ss.auditService.Record(userID, &subscription.TenantID, action, "subscription", details, client)
+}
+
+// notify emails the tenant admins. Failures are logged so notifications never
+// hold up the subscription change.
+func (ss *SubscriptionService) notify(tenant *models.Tenant, subscription *models.Subscription, template string, extra map[string]interface{}) {
+	emails, err := billingContacts(ss.db, tenant.ID)
+	if err != nil {
+		logrus.WithError(err).WithField("tenant_id", tenant.ID).Error("Failed to load billing contacts")
+		return
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	planName := string(subscription.Plan)
+	if plan, err := getPlan(ss.db, subscription.Plan); err == nil {
+		planName = plan.Name
+	}
+
+	data := map[string]interface{}{
+		"TenantName": tenant.Name,
+		"PlanName":   planName,
+		"PeriodEnd":  formatDate(subscription.CurrentPeriodEnd),
+		"Amount":     fmt.Sprintf("%.2f", periodAmount(subscription)),
+		"Link":       ss.mailService.AppLink("/billing"),
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+
+	if err := ss.mailService.SendTemplate(emails, template, data); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"tenant_id": tenant.ID,
+			"template":  template,
+		}).Error("Failed to send subscription notification")
+	}
+}
+
+func (ss *SubscriptionService) requiresPayment(subscription *models.Subscription) bool {
+	return ss.config.SaaS.BillingEnabled && subscription.PricePerMonth > 0
+}
+
+func (ss *SubscriptionService) getTenant(tenantID uuid.UUID) (*models.Tenant, error) {
+	var tenant models.Tenant
+	if err := ss.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, ErrTenantNotFound
+	}
+	return &tenant, nil
+}
+
+// suspendTenant marks a tenant suspended; its services are stopped once the
+// transaction commits
+func suspendTenant(tx *gorm.DB, tenant *models.Tenant, reason string, now time.Time) error {
+	if tenant.SuspendedAt != nil {
+		return nil
+	}
+
+	tenant.SuspendedAt = &now
+	tenant.SuspensionReason = reason
+	if err := tx.Model(tenant).Updates(map[string]interface{}{
+		"suspended_at":      now,
+		"suspension_reason": reason,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to suspend tenant: %w", err)
+	}
+	return nil
+}
+
+// lockSubscription loads and locks the tenant's current subscription
+func lockSubscription(tx *gorm.DB, tenantID uuid.UUID, subscription *models.Subscription) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("tenant_id = ?", tenantID).Order("created_at DESC").First(subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+	return nil
+}
+
+// nextPeriodEnd returns the end of the billing period starting at start
+func nextPeriodEnd(start time.Time, cycle string) time.Time {
+	if cycle == "yearly" {
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// periodAmount is the price of one billing period
+func periodAmount(subscription *models.Subscription) float64 {
+	if subscription.BillingCycle == "yearly" {
+		return subscription.PricePerMonth * 12
+	}
+	return subscription.PricePerMonth
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("January 2, 2006")
+}
@@ -0,0 +1,699 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"nomad-services-api/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTenantNotFound  = errors.New("tenant not found")
+	ErrTenantSlugTaken = errors.New("tenant slug is already taken")
+	ErrNotTenantMember = errors.New("user is not a member of this tenant")
+
+	// slugPattern allows 3-63 lowercase letters, digits and inner hyphens
+	slugPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+	domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	slugInvalid   = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// reservedSlugs cannot be claimed through self-service signup because they
+// collide with routes, subdomains or could be used to impersonate the platform
+var reservedSlugs = map[string]bool{
+	"admin": true, "administrator": true, "api": true, "app": true, "apps": true,
+	"assets": true, "auth": true, "billing": true, "blog": true, "dashboard": true,
+	"default": true, "docs": true, "help": true, "login": true, "mail": true,
+	"nomad": true, "root": true, "signup": true, "static": true, "status": true,
+	"support": true, "system": true, "tenant": true, "tenants": true, "www": true,
+}
+
+// SlugAvailability reports whether a slug can be claimed at signup
+type SlugAvailability struct {
+	Slug       string `json:"slug"`
+	Available  bool   `json:"available"`
+	Reason     string `json:"reason,omitempty"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// CreateTenantRequest represents a tenant creation request
+type CreateTenantRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Slug        string            `json:"slug" binding:"required"`
+	Description string            `json:"description"`
+	Domain      *string           `json:"domain"`
+	Plan        models.TenantPlan `json:"plan"`
+	MaxServices int               `json:"max_services"`
+}
+
+// UpdateTenantRequest represents an admin update of a tenant. Omitted fields
+// are left unchanged.
+type UpdateTenantRequest struct {
+	Name        *string            `json:"name"`
+	Slug        *string            `json:"slug"`
+	Description *string            `json:"description"`
+	Domain      *string            `json:"domain"`
+	Plan        *models.TenantPlan `json:"plan"`
+	MaxServices *int               `json:"max_services"`
+	TaxRate     *float64           `json:"tax_rate"`
+	TaxName     *string            `json:"tax_name"`
+}
+
+// TenantSettingsRequest represents the settings tenant admins can change on
+// their own tenant. Omitted fields are left unchanged; an empty domain clears it.
+type TenantSettingsRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Domain      *string `json:"domain"`
+}
+
+type TenantService struct {
+	db               *gorm.DB
+	namespaceService *NamespaceService
+}
+
+func NewTenantService(db *gorm.DB, namespaceService *NamespaceService) *TenantService {
+	return &TenantService{
+		db:               db,
+		namespaceService: namespaceService,
+	}
+}
+
+// CreateTenant creates a tenant with a unique, validated slug
+func (ts *TenantService) CreateTenant(req *CreateTenantRequest) (*models.Tenant, error) {
+	tenant := &models.Tenant{
+		ID:          uuid.New(),
+		Name:        strings.TrimSpace(req.Name),
+		Slug:        strings.TrimSpace(req.Slug),
+		Description: req.Description,
+		IsActive:    true,
+		Plan:        models.TenantPlanFree,
+	}
+	if req.Plan != "" {
+		tenant.Plan = req.Plan
+	}
+	plan, err := ts.planFor(tenant)
+	if err != nil {
+		return nil, err
+	}
+	tenant.MaxServices = plan.MaxServices
+	if req.MaxServices > 0 {
+		tenant.MaxServices = req.MaxServices
+	}
+	if err := ts.setDomain(tenant, req.Domain); err != nil {
+		return nil, err
+	}
+
+	if err := ts.validateTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	err = ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(tenant).Error; err != nil {
+			return fmt.Errorf("failed to create tenant: %w", err)
+		}
+		return syncSubscription(tx, tenant, plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ts.namespaceService.TryProvision(tenant)
+	return tenant, nil
+}
+
+// ProvisionTenant creates a tenant on the free plan together with its first
+// tenant admin, their membership and a subscription, all in one transaction.
+// An empty slug is generated from the name; an explicit slug must be
+// available.
+func (ts *TenantService) ProvisionTenant(name, slug string, admin *models.User) (*models.Tenant, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("organization name is required")
+	}
+
+	if slug == "" {
+		generated, err := ts.GenerateSlug(name)
+		if err != nil {
+			return nil, err
+		}
+		slug = generated
+	} else if availability := ts.CheckSlug(slug); !availability.Available {
+		if availability.Reason == "taken" {
+			return nil, ErrTenantSlugTaken
+		}
+		return nil, fmt.Errorf("slug is %s", availability.Reason)
+	}
+
+	tenant := &models.Tenant{
+		ID:       uuid.New(),
+		Name:     name,
+		Slug:     slug,
+		IsActive: true,
+		Plan:     models.TenantPlanFree,
+	}
+	plan, err := ts.planFor(tenant)
+	if err != nil {
+		return nil, err
+	}
+	tenant.MaxServices = plan.MaxServices
+
+	err = ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(tenant).Error; err != nil {
+			return fmt.Errorf("failed to create tenant: %w", err)
+		}
+
+		admin.TenantID = &tenant.ID
+		if err := tx.Create(admin).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		membership := &models.TenantMembership{
+			UserID:   admin.ID,
+			TenantID: tenant.ID,
+			Role:     models.UserRoleTenantAdmin,
+			Tenant:   tenant,
+		}
+		if err := addMembership(tx, membership); err != nil {
+			return err
+		}
+		ApplyMembership(admin, membership)
+
+		return syncSubscription(tx, tenant, plan)
+	})
+	if err != nil {
+		// Lost a race for the slug against a concurrent signup
+		if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "idx_tenants_slug") {
+			return nil, ErrTenantSlugTaken
+		}
+		return nil, err
+	}
+
+	ts.namespaceService.TryProvision(tenant)
+	return tenant, nil
+}
+
+// CheckSlug reports whether slug is valid, not reserved and not yet taken
+func (ts *TenantService) CheckSlug(slug string) *SlugAvailability {
+	result := &SlugAvailability{Slug: slug}
+
+	switch {
+	case !isValidSlug(slug):
+		result.Reason = "invalid"
+	case reservedSlugs[slug]:
+		result.Reason = "reserved"
+	case ts.slugTaken(slug):
+		result.Reason = "taken"
+	default:
+		result.Available = true
+		return result
+	}
+
+	if suggestion, err := ts.GenerateSlug(slug); err == nil {
+		result.Suggestion = suggestion
+	}
+	return result
+}
+
+// GenerateSlug derives an available slug from name, adding a random suffix
+// when the plain slug is reserved or taken
+func (ts *TenantService) GenerateSlug(name string) (string, error) {
+	base := strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(base) > 50 {
+		base = strings.TrimRight(base[:50], "-")
+	}
+	if len(base) < 3 {
+		base = strings.Trim("org-"+base, "-")
+	}
+
+	if !reservedSlugs[base] && !ts.slugTaken(base) {
+		return base, nil
+	}
+
+	for i := 0; i < 5; i++ {
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", fmt.Errorf("failed to generate slug: %w", err)
+		}
+		candidate := base + "-" + hex.EncodeToString(suffix)
+		if !ts.slugTaken(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate an available slug")
+}
+
+func (ts *TenantService) slugTaken(slug string) bool {
+	var count int64
+	if err := ts.db.Model(&models.Tenant{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+		return true
+	}
+	return count > 0
+}
+
+// GetTenant retrieves a tenant by ID
+func (ts *TenantService) GetTenant(id uuid.UUID) (*models.Tenant, error) {
+	var tenant models.Tenant
+	if err := ts.db.First(&tenant, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	return &tenant, nil
+}
+
+// ListTenants retrieves all tenants (with pagination support)
+func (ts *TenantService) ListTenants(limit, offset int) ([]models.Tenant, int64, error) {
+	var total int64
+	if err := ts.db.Model(&models.Tenant{}).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count tenants: %w", err)
+	}
+
+	var tenants []models.Tenant
+	query := ts.db.Order("created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+	if err := query.Find(&tenants).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	return tenants, total, nil
+}
+
+// UpdateTenant applies an admin update to a tenant
+func (ts *TenantService) UpdateTenant(id uuid.UUID, req *UpdateTenantRequest) (*models.Tenant, error) {
+	tenant, err := ts.GetTenant(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Slug != nil {
+		tenant.Slug = strings.TrimSpace(*req.Slug)
+	}
+
+	// Admins may assign any plan regardless of usage; the service limit
+	// follows the new plan unless set explicitly
+	var plan *models.Plan
+	if req.Plan != nil && *req.Plan != tenant.Plan {
+		tenant.Plan = *req.Plan
+		if plan, err = ts.planFor(tenant); err != nil {
+			return nil, err
+		}
+		tenant.MaxServices = plan.MaxServices
+	}
+	if req.MaxServices != nil {
+		if *req.MaxServices < 0 {
+			return nil, fmt.Errorf("max_services cannot be negative")
+		}
+		tenant.MaxServices = *req.MaxServices
+	}
+	if req.TaxRate != nil {
+		if *req.TaxRate < 0 || *req.TaxRate > 100 {
+			return nil, fmt.Errorf("tax_rate must be a percentage between 0 and 100")
+		}
+		tenant.TaxRate = *req.TaxRate
+	}
+	if req.TaxName != nil {
+		tenant.TaxName = strings.TrimSpace(*req.TaxName)
+	}
+
+	settings := &TenantSettingsRequest{Name: req.Name, Description: req.Description, Domain: req.Domain}
+	if tenant, err = ts.saveSettings(tenant, settings); err != nil {
+		return nil, err
+	}
+
+	if plan != nil || req.MaxServices != nil {
+		if plan == nil {
+			if plan, err = ts.planFor(tenant); err != nil {
+				return nil, err
+			}
+		}
+		if err := syncSubscription(ts.db, tenant, plan); err != nil {
+			return nil, err
+		}
+	}
+	return tenant, nil
+}
+
+// UpdateSettings applies a tenant admin's settings update to their tenant
+func (ts *TenantService) UpdateSettings(id uuid.UUID, req *TenantSettingsRequest) (*models.Tenant, error) {
+	tenant, err := ts.GetTenant(id)
+	if err != nil {
+		return nil, err
+	}
+	return ts.saveSettings(tenant, req)
+}
+
+// SetActive activates or deactivates a tenant. Users of inactive tenants
+// cannot log in or use existing tokens.
+func (ts *TenantService) SetActive(id uuid.UUID, active bool) error {
+	result := ts.db.Model(&models.Tenant{}).Where("id = ?", id).Update("is_active", active)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update tenant: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrTenantNotFound
+	}
+	return nil
+}
+
+// DeleteTenant deletes a tenant that has no services and no unpaid invoices
+// left. Memberships, roles, API keys, invitations, quotas, the subscription,
+// the budget, usage records, invoices and payment events are deleted. Its audit
+// entries, chain head and checkpoints are kept unchanged, so the tenant's audit
+// chain can still be verified. Its Nomad namespace is removed last.
+func (ts *TenantService) DeleteTenant(id uuid.UUID) error {
+	tenant, err := ts.GetTenant(id)
+	if err != nil {
+		return err
+	}
+
+	var services int64
+	if err := ts.db.Model(&models.Service{}).Where("tenant_id = ?", id).Count(&services).Error; err != nil {
+		return fmt.Errorf("failed to count tenant services: %w", err)
+	}
+	if services > 0 {
+		return fmt.Errorf("tenant still has %d services", services)
+	}
+
+	var unpaid int64
+	if err := ts.db.Model(&models.Invoice{}).
+		Where("tenant_id = ? AND status IN ?", id, []models.InvoiceStatus{models.InvoiceStatusDraft, models.InvoiceStatusOpen}).
+		Count(&unpaid).Error; err != nil {
+		return fmt.Errorf("failed to count unpaid invoices: %w", err)
+	}
+	if unpaid > 0 {
+		return fmt.Errorf("tenant still has %d unpaid invoices; collect or void them first", unpaid)
+	}
+
+	err = ts.db.Transaction(func(tx *gorm.DB) error {
+		if err := removeMemberships(tx, id, tx.Where("tenant_id = ?", id)); err != nil {
+			return err
+		}
+		if err := tx.Where("invoice_id IN (?)", tx.Model(&models.Invoice{}).Select("id").Where("tenant_id = ?", id)).
+			Delete(&models.InvoiceLine{}).Error; err != nil {
+			return fmt.Errorf("failed to delete invoice lines: %w", err)
+		}
+		for _, model := range []interface{}{&models.ApiKey{}, &models.Invitation{}, &models.Role{}, &models.Subscription{}, &models.TenantQuota{},
+			&models.TenantBudget{}, &models.UsageRecord{}, &models.PaymentEvent{}, &models.Invoice{}} {
+			if err := tx.Where("tenant_id = ?", id).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to delete tenant data: %w", err)
+			}
+		}
+		if err := tx.Delete(&models.Tenant{}, "id = ?", id).Error; err != nil {
+			return fmt.Errorf("failed to delete tenant: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ts.namespaceService.Deprovision(tenant)
+	return nil
+}
+
+// ListMembers returns the memberships of a tenant with their users
+func (ts *TenantService) ListMembers(tenantID uuid.UUID) ([]models.TenantMembership, error) {
+	var members []models.TenantMembership
+	if err := ts.db.Preload("User").Preload("CustomRole").Where("tenant_id = ?", tenantID).
+		Order("created_at").Find(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tenant members: %w", err)
+	}
+	return members, nil
+}
+
+// AddMember adds a user to a tenant with the given built-in role, or changes
+// the role of an existing member. Any custom role in the tenant is cleared.
+func (ts *TenantService) AddMember(tenantID, userID uuid.UUID, role models.UserRole) error {
+	if _, err := ts.GetTenant(tenantID); err != nil {
+		return err
+	}
+	if role == "" {
+		role = models.UserRoleUser
+	}
+	if role != models.UserRoleUser && role != models.UserRoleTenantAdmin {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	var user models.User
+	if err := ts.db.Where("id = ? AND role <> ?", userID, models.UserRoleAdmin).First(&user).Error; err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	return addMembership(ts.db, &models.TenantMembership{
+		UserID:   userID,
+		TenantID: tenantID,
+		Role:     role,
+	})
+}
+
+// RemoveMember removes a user from a tenant
+func (ts *TenantService) RemoveMember(tenantID, userID uuid.UUID) error {
+	if _, err := ts.GetMembership(userID, tenantID); err != nil {
+		return err
+	}
+
+	return ts.db.Transaction(func(tx *gorm.DB) error {
+		return removeMemberships(tx, tenantID, tx.Where("tenant_id = ? AND user_id = ?", tenantID, userID))
+	})
+}
+
+// GetMembership returns a user's membership in a tenant
+func (ts *TenantService) GetMembership(userID, tenantID uuid.UUID) (*models.TenantMembership, error) {
+	var membership models.TenantMembership
+	if err := ts.db.Preload("Tenant").Preload("CustomRole").
+		Where("user_id = ? AND tenant_id = ?", userID, tenantID).First(&membership).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotTenantMember
+		}
+		return nil, fmt.Errorf("failed to get tenant membership: %w", err)
+	}
+	return &membership, nil
+}
+
+// ListMemberships returns the tenants a user belongs to, oldest first
+func (ts *TenantService) ListMemberships(userID uuid.UUID) ([]models.TenantMembership, error) {
+	var memberships []models.TenantMembership
+	if err := ts.db.Preload("Tenant").Preload("CustomRole").Where("user_id = ?", userID).
+		Order("created_at").Find(&memberships).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tenant memberships: %w", err)
+	}
+	return memberships, nil
+}
+
+// LoginMembership picks the tenant a user works in after logging in: the last
+// selected tenant if it is still active, otherwise the first active one. It
+// returns nil for users without memberships.
+func (ts *TenantService) LoginMembership(user *models.User) (*models.TenantMembership, error) {
+	memberships, err := ts.ListMemberships(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return nil, nil
+	}
+
+	var firstActive *models.TenantMembership
+	for i := range memberships {
+		membership := &memberships[i]
+		if membership.Tenant == nil || !membership.Tenant.IsActive {
+			continue
+		}
+		if user.TenantID != nil && membership.TenantID == *user.TenantID {
+			return membership, nil
+		}
+		if firstActive == nil {
+			firstActive = membership
+		}
+	}
+	if firstActive != nil {
+		return firstActive, nil
+	}
+
+	// Every tenant is inactive; the caller rejects the login
+	return &memberships[0], nil
+}
+
+// ResolveActiveTenant applies the user's membership in tenantID, the tenant a
+// token or API key was issued for. A nil tenantID selects no tenant.
+func (ts *TenantService) ResolveActiveTenant(user *models.User, tenantID *uuid.UUID) error {
+	if tenantID == nil {
+		ApplyMembership(user, nil)
+		return nil
+	}
+
+	membership, err := ts.GetMembership(user.ID, *tenantID)
+	if err != nil {
+		return err
+	}
+	ApplyMembership(user, membership)
+	return nil
+}
+
+// SelectTenant remembers tenantID as the tenant the user logs into
+func (ts *TenantService) SelectTenant(userID, tenantID uuid.UUID) error {
+	if err := ts.db.Model(&models.User{}).Where("id = ?", userID).
+		Update("tenant_id", tenantID).Error; err != nil {
+		return fmt.Errorf("failed to select tenant: %w", err)
+	}
+	return nil
+}
+
+// ApplyMembership makes membership the user's active tenant for the rest of
+// the request. System admins keep their global role; a nil membership leaves
+// the user without a tenant.
+func ApplyMembership(user *models.User, membership *models.TenantMembership) {
+	user.CustomRoleID = nil
+	user.CustomRole = nil
+
+	if membership == nil {
+		user.TenantID = nil
+		user.Tenant = nil
+		if user.Role != models.UserRoleAdmin {
+			user.Role = models.UserRoleUser
+		}
+		return
+	}
+
+	tenantID := membership.TenantID
+	user.TenantID = &tenantID
+	user.Tenant = membership.Tenant
+	if user.Role != models.UserRoleAdmin {
+		user.Role = membership.Role
+		user.CustomRoleID = membership.CustomRoleID
+		user.CustomRole = membership.CustomRole
+	}
+}
+
+// addMembership creates or updates a membership and makes the tenant the
+// user's login tenant if they had none
+func addMembership(tx *gorm.DB, membership *models.TenantMembership) error {
+	if membership.ID == uuid.Nil {
+		membership.ID = uuid.New()
+	}
+	if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "tenant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "custom_role_id", "updated_at"}),
+	}).Create(membership).Error; err != nil {
+		return fmt.Errorf("failed to add tenant member: %w", err)
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ? AND tenant_id IS NULL", membership.UserID).
+		Update("tenant_id", membership.TenantID).Error; err != nil {
+		return fmt.Errorf("failed to select tenant: %w", err)
+	}
+	return nil
+}
+
+// removeMemberships deletes the memberships of tenantID matched by query.
+// Users who logged into that tenant fall back to their oldest remaining one.
+func removeMemberships(tx *gorm.DB, tenantID uuid.UUID, query *gorm.DB) error {
+	if err := query.Delete(&models.TenantMembership{}).Error; err != nil {
+		return fmt.Errorf("failed to remove tenant members: %w", err)
+	}
+
+	fallback := gorm.Expr(`(SELECT m.tenant_id FROM tenant_memberships m
+		WHERE m.user_id = users.id ORDER BY m.created_at LIMIT 1)`)
+	if err := tx.Model(&models.User{}).
+		Where("tenant_id = ? AND NOT EXISTS (SELECT 1 FROM tenant_memberships m WHERE m.user_id = users.id AND m.tenant_id = ?)", tenantID, tenantID).
+		Update("tenant_id", fallback).Error; err != nil {
+		return fmt.Errorf("failed to reset selected tenant: %w", err)
+	}
+	return nil
+}
+
+func (ts *TenantService) saveSettings(tenant *models.Tenant, req *TenantSettingsRequest) (*models.Tenant, error) {
+	if req.Name != nil {
+		tenant.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		tenant.Description = *req.Description
+	}
+	if req.Domain != nil {
+		if err := ts.setDomain(tenant, req.Domain); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ts.validateTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	if err := ts.db.Save(tenant).Error; err != nil {
+		return nil, fmt.Errorf("failed to update tenant: %w", err)
+	}
+	return tenant, nil
+}
+
+// setDomain normalizes and validates a custom domain; an empty domain clears it
+func (ts *TenantService) setDomain(tenant *models.Tenant, domain *string) error {
+	if domain == nil || strings.TrimSpace(*domain) == "" {
+		tenant.Domain = nil
+		return nil
+	}
+
+	normalized := strings.ToLower(strings.TrimSpace(*domain))
+	if !domainPattern.MatchString(normalized) {
+		return fmt.Errorf("invalid domain: %s", *domain)
+	}
+
+	var count int64
+	if err := ts.db.Model(&models.Tenant{}).Where("domain = ? AND id <> ?", normalized, tenant.ID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check domain: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("domain is already in use")
+	}
+
+	tenant.Domain = &normalized
+	return nil
+}
+
+func (ts *TenantService) validateTenant(tenant *models.Tenant) error {
+	if tenant.Name == "" {
+		return fmt.Errorf("tenant name is required")
+	}
+	if !isValidSlug(tenant.Slug) {
+		return fmt.Errorf("invalid slug: use 3-63 lowercase letters, digits and single hyphens")
+	}
+	if _, err := ts.planFor(tenant); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := ts.db.Model(&models.Tenant{}).Where("slug = ? AND id <> ?", tenant.Slug, tenant.ID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check slug: %w", err)
+	}
+	if count > 0 {
+		return ErrTenantSlugTaken
+	}
+	return nil
+}
+
+func isValidSlug(slug string) bool {
+	return slugPattern.MatchString(slug) && !strings.Contains(slug, "--")
+}
+
+// planFor returns the catalog plan of a tenant
+func (ts *TenantService) planFor(tenant *models.Tenant) (*models.Plan, error) {
+	plan, err := getPlan(ts.db, tenant.Plan)
+	if errors.Is(err, ErrPlanNotFound) {
+		return nil, fmt.Errorf("invalid plan: %s", tenant.Plan)
+	}
+	return plan, err
+}
@@ -1,12 +1,17 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"time"
 
 	"nomad-services-api/internal/models"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserService struct {
@@ -49,9 +54,12 @@ func (us *UserService) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
-// UpdateUser updates a user in the database
+// UpdateUser saves a user's profile fields. Tenant and role fields are left
+// alone since they reflect the active tenant membership during a request.
 func (us *UserService) UpdateUser(user *models.User) error {
-	return us.db.Save(user).Error
+	return us.db.Model(user).
+		Select("first_name", "last_name", "email", "email_verified", "email_verified_at").
+		Updates(user).Error
 }
 
 // DeleteUser deletes a user from the database
@@ -79,10 +87,11 @@ func (us *UserService) ListUsers(limit, offset int) ([]models.User, error) {
 	return users, nil
 }
 
-// GetUsersByTenant retrieves all users for a specific tenant
+// GetUsersByTenant retrieves all members of a specific tenant
 func (us *UserService) GetUsersByTenant(tenantID uuid.UUID) ([]models.User, error) {
 	var users []models.User
-	if err := us.db.Where("tenant_id = ?", tenantID).Find(&users).Error; err != nil {
+	if err := us.db.Joins("JOIN tenant_memberships ON tenant_memberships.user_id = users.id").
+		Where("tenant_memberships.tenant_id = ?", tenantID).Find(&users).Error; err != nil {
 		return nil, fmt.Errorf("failed to get users by tenant: %w", err)
 	}
 	return users, nil
@@ -107,17 +116,115 @@ func (us *UserService) DeactivateUser(id uuid.UUID) error {
 	return us.db.Model(&models.User{}).Where("id = ?", id).Update("is_active", false).Error
 }
 
-// AssignUserToTenant assigns a user to a tenant
-func (us *UserService) AssignUserToTenant(userID, tenantID uuid.UUID) error {
-	return us.db.Model(&models.User{}).Where("id = ?", userID).Update("tenant_id", tenantID).Error
+// UpdateUserRole updates a user's role
+func (us *UserService) UpdateUserRole(userID uuid.UUID, role models.UserRole) error {
+	return us.db.Model(&models.User{}).Where("id = ?", userID).Update("role", role).Error
 }
 
-// RemoveUserFromTenant removes a user from a tenant
-func (us *UserService) RemoveUserFromTenant(userID uuid.UUID) error {
-	return us.db.Model(&models.User{}).Where("id = ?", userID).Update("tenant_id", nil).Error
+// UpdatePassword replaces a user's password hash
+func (us *UserService) UpdatePassword(userID uuid.UUID, passwordHash string) error {
+	return us.db.Model(&models.User{}).Where("id = ?", userID).Update("password", passwordHash).Error
 }
 
-// UpdateUserRole updates a user's role
-func (us *UserService) UpdateUserRole(userID uuid.UUID, role models.UserRole) error {
-	return us.db.Model(&models.User{}).Where("id = ?", userID).Update("role", role).Error
+// MarkEmailVerified records that the user confirmed their email address
+func (us *UserService) MarkEmailVerified(userID uuid.UUID) error {
+	return us.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"email_verified":    true,
+		"email_verified_at": time.Now(),
+	}).Error
+}
+
+// CreateUserToken issues a single-use token for the given purpose and returns
+// its plaintext value. Outstanding tokens for the same purpose are invalidated.
+func (us *UserService) CreateUserToken(userID uuid.UUID, purpose models.UserTokenPurpose, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserToken{
+			ID:        uuid.New(),
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashUserToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+
+	return token, nil
+}
+
+// ConsumeUserToken validates a token for the given purpose, marks it used and
+// returns the owning user's ID
+func (us *UserService) ConsumeUserToken(token string, purpose models.UserTokenPurpose) (uuid.UUID, error) {
+	var userToken models.UserToken
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND purpose = ?", hashUserToken(token), purpose).
+			First(&userToken).Error; err != nil {
+			return err
+		}
+
+		if userToken.UsedAt != nil || time.Now().After(userToken.ExpiresAt) {
+			return fmt.Errorf("token expired")
+		}
+
+		return tx.Model(&userToken).Update("used_at", time.Now()).Error
+	})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid or expired token")
+	}
+
+	return userToken.UserID, nil
+}
+
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordFailedLogin increments a user's failed login counter and locks the
+// account once maxAttempts is reached. It returns the new counter and the lock
+// expiry, if the account is now locked.
+func (us *UserService) RecordFailedLogin(userID uuid.UUID, maxAttempts int, lockout time.Duration) (int, *time.Time, error) {
+	var user models.User
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"failed_login_attempts": user.FailedLoginAttempts + 1}
+		if maxAttempts > 0 && user.FailedLoginAttempts+1 >= maxAttempts {
+			lockedUntil := time.Now().Add(lockout)
+			updates["locked_until"] = lockedUntil
+			user.LockedUntil = &lockedUntil
+		}
+		user.FailedLoginAttempts++
+
+		return tx.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to record failed login: %w", err)
+	}
+
+	return user.FailedLoginAttempts, user.LockedUntil, nil
+}
+
+// ResetFailedLogins clears a user's failed login counter and any lockout
+func (us *UserService) ResetFailedLogins(userID uuid.UUID) error {
+	return us.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
 }
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin records a span for each query run with a context that is
+// already part of a trace, e.g. db.WithContext(c.Request.Context()). Queries
+// without one, such as those of background loops, are not traced, so they
+// don't each start a trace of their own.
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("tracing:before_create", startQuerySpan("create")),
+		callback.Create().After("gorm:create").Register("tracing:after_create", endQuerySpan),
+		callback.Query().Before("gorm:query").Register("tracing:before_query", startQuerySpan("query")),
+		callback.Query().After("gorm:query").Register("tracing:after_query", endQuerySpan),
+		callback.Update().Before("gorm:update").Register("tracing:before_update", startQuerySpan("update")),
+		callback.Update().After("gorm:update").Register("tracing:after_update", endQuerySpan),
+		callback.Delete().Before("gorm:delete").Register("tracing:before_delete", startQuerySpan("delete")),
+		callback.Delete().After("gorm:delete").Register("tracing:after_delete", endQuerySpan),
+		callback.Row().Before("gorm:row").Register("tracing:before_row", startQuerySpan("row")),
+		callback.Row().After("gorm:row").Register("tracing:after_row", endQuerySpan),
+		callback.Raw().Before("gorm:raw").Register("tracing:before_raw", startQuerySpan("raw")),
+		callback.Raw().After("gorm:raw").Register("tracing:after_raw", endQuerySpan),
+	)
+}
+
+func startQuerySpan(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+		ctx, span := Tracer.Start(ctx, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "postgresql"),
+				attribute.String("db.operation", operation),
+				attribute.String("db.sql.table", tx.Statement.Table),
+			))
+		tx.Statement.Context = ctx
+		tx.InstanceSet(gormSpanKey, span)
+	}
+}
+
+// endQuerySpan records the statement, which holds placeholders rather than
+// the query's values, and any error other than a missing record
+func endQuerySpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(
+		attribute.String("db.statement", tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(tx.Error)
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,105 @@
+// Package tracing sets up OpenTelemetry tracing: the exporter spans are sent
+// to, W3C trace context propagation, trace IDs in log entries and spans for
+// GORM queries.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"nomad-services-api/internal/config"
+	"nomad-services-api/internal/version"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies the API server in exported spans
+const ServiceName = "nomad-services-api"
+
+// Tracer starts the spans of the server's own operations. It delegates to
+// the provider installed by Setup, so it can be used before Setup runs.
+var Tracer = otel.Tracer(ServiceName)
+
+// Setup installs the tracer provider for cfg.Tracing.Exporter: "otlp" sends
+// spans over OTLP/HTTP to cfg.Tracing.OTLPEndpoint, "stdout" prints them and
+// "none" drops them. Trace context is propagated either way. The returned
+// function flushes pending spans and should be called on shutdown.
+func Setup(cfg *config.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Tracing.Exporter {
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Tracing.OTLPEndpoint)}
+		if cfg.Tracing.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q: use none, stdout or otlp", cfg.Tracing.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Tracing.Exporter, err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithAttributes(
+			attribute.String("service.name", ServiceName),
+			attribute.String("service.version", version.Version),
+			attribute.String("deployment.environment", cfg.Server.Environment),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	logrus.WithFields(logrus.Fields{
+		"exporter":     cfg.Tracing.Exporter,
+		"sample_ratio": cfg.Tracing.SampleRatio,
+	}).Info("Tracing enabled")
+
+	return provider.Shutdown, nil
+}
+
+// LogHook adds the trace and span IDs of an entry's context to its fields,
+// for entries logged with logrus.WithContext
+type LogHook struct{}
+
+func (LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (LogHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	spanContext := trace.SpanContextFromContext(entry.Context)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	entry.Data["trace_id"] = spanContext.TraceID().String()
+	entry.Data["span_id"] = spanContext.SpanID().String()
+	return nil
+}
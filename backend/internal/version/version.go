@@ -0,0 +1,36 @@
+// Package version reports the build of the API server. The values are set
+// at build time:
+//
+//	go build -ldflags "-X nomad-services-api/internal/version.Version=1.4.0 \
+//	  -X nomad-services-api/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X nomad-services-api/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import "runtime"
+
+var (
+	// Version is the release the binary was built from
+	Version = "dev"
+	// Commit is the git commit the binary was built from
+	Commit = "unknown"
+	// BuildTime is when the binary was built, in RFC 3339
+	BuildTime = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running build
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"nomad-services-api/internal/api"
 	"nomad-services-api/internal/config"
 	"nomad-services-api/internal/database"
+	"nomad-services-api/internal/metrics"
+	"nomad-services-api/internal/payments"
+	"nomad-services-api/internal/ratelimit"
+	"nomad-services-api/internal/requestid"
 	"nomad-services-api/internal/services"
+	"nomad-services-api/internal/tracing"
+	"nomad-services-api/internal/version"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -28,26 +39,138 @@ func main() {
 	// Setup logging
 	setupLogging(cfg)
 
+	// Setup tracing
+	shutdownTracing, err := tracing.Setup(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize tracing:", err)
+	}
+
 	// Initialize database
 	db, err := database.Initialize(cfg)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		log.Fatal("Failed to initialize database tracing:", err)
+	}
+
+	// Initialize mailer
+	mailer, err := services.NewMailer(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
+
+	passwordPolicy, err := services.NewPasswordPolicy(cfg)
+	if err != nil {
+		log.Fatal("Failed to load password policy:", err)
+	}
 
 	// Initialize services
 	nomadService := services.NewNomadService(cfg)
 	userService := services.NewUserService(db)
-	authService := services.NewAuthService(cfg, userService)
-	serviceManager := services.NewServiceManager(nomadService, cfg)
+	mailService := services.NewMailService(mailer, cfg)
+	auditService, err := services.NewAuditService(db, cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize audit log:", err)
+	}
+	auditService.Start()
+	mfaService := services.NewMFAService(db, cfg)
+	apiKeyService := services.NewApiKeyService(db)
+	rbacService := services.NewRBACService(db)
+	quotaService := services.NewQuotaService(db, nomadService, cfg)
+	planService := services.NewPlanService(db, quotaService)
+	namespaceService := services.NewNamespaceService(db, nomadService, quotaService, cfg)
+	tenantService := services.NewTenantService(db, namespaceService)
+	authService := services.NewAuthService(cfg, userService, mfaService, mailService, auditService, tenantService, passwordPolicy)
+	invitationService := services.NewInvitationService(db, cfg, mailService, authService, rbacService)
+	serviceManager := services.NewServiceManager(nomadService, quotaService, namespaceService, cfg)
 	serviceManager.SetDB(db)
+	serviceManager.StartReconciler()
+
+	if err := planService.SeedDefaults(); err != nil {
+		log.Fatal("Failed to seed plan catalog:", err)
+	}
+
+	invoiceService := services.NewInvoiceService(db, auditService, mailService, cfg)
+	subscriptionService := services.NewSubscriptionService(db, serviceManager, invoiceService, auditService, mailService, cfg)
+
+	paymentProvider, err := setupPaymentProvider(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize payment provider:", err)
+	}
+	billingService := services.NewBillingService(db, paymentProvider, invoiceService, subscriptionService, auditService)
+	estimateService := services.NewEstimateService(db, nomadService, quotaService, cfg)
+	invoiceService.SetCollector(billingService.Collect)
+	subscriptionService.Start()
+
+	meteringService := services.NewMeteringService(db, nomadService, namespaceService, cfg)
+	budgetService := services.NewBudgetService(db, serviceManager, auditService, mailService, cfg)
+	if cfg.Metering.Enabled {
+		meteringService.Start()
+		budgetService.Start()
+	}
+
+	if cfg.Server.MetricsEnabled {
+		if err := setupMetrics(cfg, db, serviceManager); err != nil {
+			log.Fatal("Failed to initialize metrics:", err)
+		}
+	}
+
+	healthService := services.NewHealthService(db, nomadService, serviceManager, cfg)
+
+	rateLimiter, err := setupRateLimiter(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize rate limiter:", err)
+	}
 
 	// Initialize API server
-	server := api.NewServer(cfg, authService, serviceManager, userService)
+	server := api.NewServer(cfg, authService, serviceManager, userService, mfaService, apiKeyService, rbacService, tenantService, invitationService, quotaService, planService, subscriptionService, meteringService, invoiceService, billingService, estimateService, budgetService, auditService, healthService, rateLimiter)
 
 	// Start server
-	logrus.Infof("Starting server on port %s", cfg.Server.Port)
-	if err := server.Start(); err != nil {
-		log.Fatal("Failed to start server:", err)
+	logrus.WithFields(logrus.Fields{
+		"version": version.Version,
+		"commit":  version.Commit,
+	}).Infof("Starting server on port %s", cfg.Server.Port)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			logrus.WithError(err).Error("Failed to start server")
+			exitCode = 1
+		}
+	case <-ctx.Done():
+		logrus.Info("Shutting down")
+	}
+	stop()
+
+	// Let requests in flight finish, then write the audit entries they queued
+	// before stopping the background loops
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logrus.WithError(err).Warn("Requests were still running at shutdown")
+	}
+	auditService.Stop()
+	serviceManager.StopReconciler()
+	if cfg.Metering.Enabled {
+		meteringService.Stop()
+		budgetService.Stop()
+	}
+	subscriptionService.Stop()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logrus.WithError(err).Warn("Failed to flush traces")
+	}
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
 }
 
@@ -57,6 +180,8 @@ func setupLogging(cfg *config.Config) {
 		level = logrus.InfoLevel
 	}
 	logrus.SetLevel(level)
+	logrus.AddHook(tracing.LogHook{})
+	logrus.AddHook(requestid.LogHook{})
 
 	if cfg.Server.Environment == "production" {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
@@ -76,3 +201,48 @@ func setupLogging(cfg *config.Config) {
 		}
 	}
 }
+
+func setupPaymentProvider(cfg *config.Config) (payments.Provider, error) {
+	switch cfg.Payments.Provider {
+	case "stripe":
+		return payments.NewStripeProvider(cfg.Payments.StripeAPIURL, cfg.Payments.StripeSecretKey, cfg.Payments.WebhookSecret)
+	case "fake":
+		logrus.Warn("Using the fake payment provider; no real payments are collected")
+		return payments.NewFakeProvider(cfg.Payments.WebhookSecret), nil
+	case "none", "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider: %s", cfg.Payments.Provider)
+	}
+}
+
+func setupMetrics(cfg *config.Config, db *gorm.DB, serviceManager *services.ServiceManager) error {
+	if cfg.Server.MetricsToken == "" {
+		logrus.Warn("METRICS_TOKEN is not set; /metrics is readable without authentication")
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	metrics.RegisterDBStats(sqlDB, cfg.Database.DBName)
+	metrics.RegisterReconcilerLag(serviceManager.LastReconciled)
+	metrics.Register(services.NewStatusCollector(db))
+	return nil
+}
+
+func setupRateLimiter(cfg *config.Config) (ratelimit.Store, error) {
+	if !cfg.RateLimit.Enabled {
+		logrus.Info("Rate limiting disabled")
+		return nil, nil
+	}
+
+	switch cfg.RateLimit.Backend {
+	case "redis":
+		return ratelimit.NewRedisStore(cfg.RateLimit.RedisAddr, cfg.RateLimit.RedisPassword, cfg.RateLimit.RedisDB)
+	case "memory", "":
+		return ratelimit.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend: %s", cfg.RateLimit.Backend)
+	}
+}